@@ -22,17 +22,22 @@ func main() {
 	log := setupLogger(cfg.Env)
 	log.Info("starting app", slog.Any("config", cfg))
 
-	application := app.New(log, cfg.GRPC.Port, cfg.PostgresConfig.Username, cfg.PostgresConfig.Password, cfg.PostgresConfig.Host, cfg.PostgresConfig.Port, cfg.PostgresConfig.Database, cfg.TokenTTL, cfg.RefreshTTL)
+	application := app.New(log, cfg)
 
 	go func() {
 		application.GRPCSrv.MustRun()
 	}()
 
+	go func() {
+		application.HTTPSrv.MustRun()
+	}()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	<-stop
 	application.GRPCSrv.Stop()
+	application.HTTPSrv.Stop()
 	log.Info("app stopped")
 }
 
@@ -1,38 +1,213 @@
 package app
 
 import (
+	"fmt"
 	"log/slog"
+	"net/url"
+	"os"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/config"
+	"sso/internal/lib/federation"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/secret"
 	"sso/internal/services/auth"
+	"sso/internal/services/keys"
 	"sso/internal/storage/postgres"
+	"strings"
 	"time"
 )
 
+const revocationPruneInterval = time.Hour
+
 type App struct {
 	GRPCSrv *grpcapp.App
+	HTTPSrv *httpapp.App
 }
 
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	username string,
-	password string,
-	host string,
-	port int,
-	dbName string,
-	tokenTTL time.Duration,
-	refreshTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := postgres.New(username, password, host, port, dbName) // TODO: fix config
+	storage, err := postgres.New(
+		cfg.PostgresConfig.Username,
+		cfg.PostgresConfig.Password,
+		cfg.PostgresConfig.Host,
+		cfg.PostgresConfig.Port,
+		cfg.PostgresConfig.Database,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	staticKeys, err := loadSigningKeys(cfg.Signing)
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, storage, storage, storage, tokenTTL, refreshTTL)
+	keySet := jwt.NewKeySet(staticKeys...)
+
+	rotation := cfg.Signing.Rotation
+	if rotation.Enabled && rotation.Retention <= max(cfg.TokenTTL, auth.MaxAccessTTL) {
+		panic("signing.rotation.retention must be longer than the access token lifetime")
+	}
+
+	keysService := keys.New(log, storage, newSigningKeyBox(rotation), keySet, staticKeys, rotation.Enabled, rotation.Algorithm, rotation.Interval, rotation.PublishAhead, rotation.Retention)
+	if err := keysService.Sync(); err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for range time.Tick(rotation.CheckInterval) {
+			_ = keysService.Sync()
+		}
+	}()
+
+	authService := auth.New(log, storage, auth.Options{
+		Providers:       newProviders(cfg.Issuer, cfg.Providers),
+		Mailer:          newMailSender(log, cfg.Mail),
+		Secrets:         newSecretBox(log, cfg.MFA),
+		MFAIssuer:       cfg.MFA.Issuer,
+		Issuer:          jwt.Issuer{Name: cfg.Issuer, Keys: keySet},
+		TokenTTL:        cfg.TokenTTL,
+		RefreshTTL:      cfg.RefreshTTL,
+		SessionLifetime: cfg.SessionLifetime,
+		IdleTimeout:     cfg.IdleTimeout,
+		CodeTTL:         cfg.OAuth.CodeTTL,
+		DeviceCodeTTL:   cfg.OAuth.DeviceCodeTTL,
+		DeviceInterval:  cfg.OAuth.DeviceInterval,
+		VerificationTTL: cfg.Account.VerificationTTL,
+		ResetTTL:        cfg.Account.PasswordResetTTL,
+		PasswordlessTTL: cfg.Account.PasswordlessTTL,
+		MFAChallengeTTL: cfg.MFA.ChallengeTTL,
+		WebAuthnTimeout: cfg.WebAuthn.Timeout,
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+		PasswordlessEmailLimit:  auth.RateLimit{Hits: cfg.Account.PasswordlessEmailLimit, Window: cfg.Account.RateLimitWindow},
+		PasswordlessIPLimit:     auth.RateLimit{Hits: cfg.Account.PasswordlessIPLimit, Window: cfg.Account.RateLimitWindow},
+		PasswordResetEmailLimit: auth.RateLimit{Hits: cfg.Account.PasswordResetEmailLimit, Window: cfg.Account.RateLimitWindow},
+		PasswordResetIPLimit:    auth.RateLimit{Hits: cfg.Account.PasswordResetIPLimit, Window: cfg.Account.RateLimitWindow},
+	})
+
+	go func() {
+		for range time.Tick(revocationPruneInterval) {
+			_ = authService.PruneRevocations()
+		}
+	}()
+
+	grpcApp := grpcapp.New(log, authService, cfg.GRPC.Port)
+	httpApp := httpapp.New(log, authService, cfg.Issuer, cfg.HTTP.Port, cfg.HTTP.Timeout)
 
 	return &App{
 		GRPCSrv: grpcApp,
+		HTTPSrv: httpApp,
+	}
+}
+
+// defaultProviderScopes are requested from providers without configured
+// scopes.
+var defaultProviderScopes = []string{"openid", "email"}
+
+func newProviders(issuer string, cfgs []config.ProviderConfig) map[string]*federation.Provider {
+	providers := make(map[string]*federation.Provider, len(cfgs))
+	for _, cfg := range cfgs {
+		provider := &federation.Provider{
+			Name:         cfg.Name,
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Scopes:       cfg.Scopes,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoints: federation.Endpoints{
+				Authorization: cfg.AuthorizationEndpoint,
+				Token:         cfg.TokenEndpoint,
+				UserInfo:      cfg.UserInfoEndpoint,
+			},
+			TrustEmail: cfg.TrustEmail,
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = defaultProviderScopes
+		}
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = strings.TrimSuffix(issuer, "/") + "/login/" + url.PathEscape(cfg.Name) + "/callback"
+		}
+
+		providers[cfg.Name] = provider
+	}
+
+	return providers
+}
+
+func newMailSender(log *slog.Logger, cfg config.MailConfig) mail.Sender {
+	switch cfg.Sender {
+	case "log":
+		return mail.NewLogSender(log)
+	case "file":
+		return mail.NewFileSender(cfg.Dir, cfg.From)
+	case "smtp":
+		return mail.NewSMTPSender(cfg.SMTP.Addr, cfg.SMTP.Username, cfg.SMTP.Password, cfg.From)
+	default:
+		panic("unknown mail sender: " + cfg.Sender)
 	}
 }
+
+// newSecretBox returns nil without a configured key, which keeps MFA turned
+// off.
+func newSecretBox(log *slog.Logger, cfg config.MFAConfig) *secret.Box {
+	if cfg.EncryptionKey == "" {
+		log.Warn("mfa encryption key is not configured, users can't turn on mfa")
+		return nil
+	}
+
+	box, err := secret.NewBox(cfg.EncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return box
+}
+
+// newSigningKeyBox returns nil without a configured key, which is only
+// allowed with rotation turned off.
+func newSigningKeyBox(cfg config.KeyRotationConfig) *secret.Box {
+	if cfg.EncryptionKey == "" {
+		if cfg.Enabled {
+			panic("signing key rotation requires signing.rotation.encryption_key")
+		}
+		return nil
+	}
+
+	box, err := secret.NewBox(cfg.EncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return box
+}
+
+func loadSigningKeys(cfg config.SigningConfig) ([]jwt.SigningKey, error) {
+	const op = "app.loadSigningKeys"
+
+	keys := make([]jwt.SigningKey, 0, len(cfg.Keys))
+	for _, keyCfg := range cfg.Keys {
+		data, err := os.ReadFile(keyCfg.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		private, err := jwt.ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", op, keyCfg.ID, err)
+		}
+
+		key, err := jwt.NewSigningKey(keyCfg.ID, keyCfg.Algorithm, keyCfg.AppID, private)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", op, keyCfg.ID, err)
+		}
+		key.Active = true
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
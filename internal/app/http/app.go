@@ -0,0 +1,73 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	authhttp "sso/internal/http/auth"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	port       int
+}
+
+func New(log *slog.Logger, authService authhttp.Auth, issuer string, port int, timeout time.Duration) *App {
+	mux := http.NewServeMux()
+
+	authhttp.Register(mux, log, authService, issuer)
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Handler:      mux,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		},
+		port: port,
+	}
+}
+
+func (app *App) MustRun() {
+	if err := app.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (app *App) Run() error {
+	const op = "httpapp.Run"
+
+	log := app.log.With(
+		slog.String("op", op),
+		slog.Int("port", app.port),
+	)
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", app.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("http server running", slog.String("addr", l.Addr().String()))
+
+	if err := app.httpServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (app *App) Stop() {
+	const op = "httpapp.Stop"
+
+	app.log.With(slog.String("op", op)).Info("stopping HTTP server")
+
+	if err := app.httpServer.Shutdown(context.Background()); err != nil {
+		app.log.With(slog.String("op", op)).Error("failed to stop HTTP server", sl.Err(err))
+	}
+}
@@ -10,11 +10,25 @@ import (
 )
 
 type Config struct {
-	Env            string        `yaml:"env" env-default:"local"`
-	TokenTTL       time.Duration `yaml:"token_ttl" env-default:"15m"`
-	RefreshTTL     time.Duration `yaml:"refresh_ttl" env-default:"1h"`
-	GRPC           GRPCConfig    `yaml:"grpc"`
-	PostgresConfig `yaml:"postgres"`
+	Env        string        `yaml:"env" env-default:"local"`
+	Issuer     string        `yaml:"issuer" env-default:"sso"`
+	TokenTTL   time.Duration `yaml:"token_ttl" env-default:"15m"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"1h"`
+	// SessionLifetime and IdleTimeout limit how long a login can be kept
+	// alive with refresh tokens, zero means no limit. Apps can override
+	// every token lifetime in the apps table.
+	SessionLifetime time.Duration    `yaml:"session_lifetime" env-default:"0"`
+	IdleTimeout     time.Duration    `yaml:"idle_timeout" env-default:"0"`
+	GRPC            GRPCConfig       `yaml:"grpc"`
+	HTTP            HTTPConfig       `yaml:"http"`
+	Signing         SigningConfig    `yaml:"signing"`
+	OAuth           OAuthConfig      `yaml:"oauth"`
+	Providers       []ProviderConfig `yaml:"providers"`
+	Mail            MailConfig       `yaml:"mail"`
+	Account         AccountConfig    `yaml:"account"`
+	MFA             MFAConfig        `yaml:"mfa"`
+	WebAuthn        WebAuthnConfig   `yaml:"webauthn"`
+	PostgresConfig  `yaml:"postgres"`
 }
 
 type GRPCConfig struct {
@@ -22,6 +36,113 @@ type GRPCConfig struct {
 	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
 }
 
+type HTTPConfig struct {
+	Port    int           `yaml:"port" env-default:"8081"`
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// SigningConfig lists asymmetric keys access tokens are signed with. Apps
+// without a key of their own use a global one (app_id 0), and if there is
+// none tokens are signed with HS256 using the app secret.
+type SigningConfig struct {
+	Keys     []SigningKeyConfig `yaml:"keys"`
+	Rotation KeyRotationConfig  `yaml:"rotation"`
+}
+
+// KeyRotationConfig controls the keys stored in the signing_keys table.
+// Retention must be longer than the access token lifetime of every app.
+// EncryptionKey is
+// the base64 encoded 32 byte AES key the private keys are stored with,
+// rotation can't be enabled without it.
+type KeyRotationConfig struct {
+	Enabled       bool          `yaml:"enabled" env-default:"false"`
+	EncryptionKey string        `yaml:"encryption_key" env:"SIGNING_ENCRYPTION_KEY"`
+	Algorithm     string        `yaml:"algorithm" env-default:"RS256"`
+	Interval      time.Duration `yaml:"interval" env-default:"720h"`
+	PublishAhead  time.Duration `yaml:"publish_ahead" env-default:"24h"`
+	Retention     time.Duration `yaml:"retention" env-default:"48h"`
+	CheckInterval time.Duration `yaml:"check_interval" env-default:"1m"`
+}
+
+type SigningKeyConfig struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"`
+	PrivateKeyPath string `yaml:"private_key_path"`
+	AppID          int32  `yaml:"app_id"`
+}
+
+// OAuthConfig controls the OAuth endpoints served over HTTP. DeviceInterval
+// is how often device flow clients may poll the token endpoint.
+type OAuthConfig struct {
+	CodeTTL        time.Duration `yaml:"code_ttl" env-default:"1m"`
+	DeviceCodeTTL  time.Duration `yaml:"device_code_ttl" env-default:"10m"`
+	DeviceInterval time.Duration `yaml:"device_interval" env-default:"5s"`
+}
+
+// ProviderConfig is an upstream OpenID Connect or OAuth 2.0 identity
+// provider users can sign in with. Endpoints left empty are discovered from
+// the issuer, the redirect URL defaults to /login/<name>/callback under the
+// issuer of this service.
+type ProviderConfig struct {
+	Name                  string   `yaml:"name"`
+	Issuer                string   `yaml:"issuer"`
+	ClientID              string   `yaml:"client_id"`
+	ClientSecret          string   `yaml:"client_secret"`
+	Scopes                []string `yaml:"scopes"`
+	RedirectURL           string   `yaml:"redirect_url"`
+	AuthorizationEndpoint string   `yaml:"authorization_endpoint"`
+	TokenEndpoint         string   `yaml:"token_endpoint"`
+	UserInfoEndpoint      string   `yaml:"userinfo_endpoint"`
+	// TrustEmail treats the emails of the provider as verified.
+	TrustEmail bool `yaml:"trust_email"`
+}
+
+// MailConfig selects how emails to users are sent: "log" writes them to the
+// log, "file" stores them in Dir and "smtp" sends them through SMTP.
+type MailConfig struct {
+	Sender string     `yaml:"sender" env-default:"log"`
+	From   string     `yaml:"from" env-default:"sso@localhost"`
+	Dir    string     `yaml:"dir" env-default:"./var/mail"`
+	SMTP   SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// AccountConfig controls the links and codes mailed to users to manage their
+// account and to sign in without a password. The limits cap how many mails
+// are sent to an email and requested from an IP within RateLimitWindow, zero
+// turns a limit off.
+type AccountConfig struct {
+	VerificationTTL         time.Duration `yaml:"verification_ttl" env-default:"24h"`
+	PasswordResetTTL        time.Duration `yaml:"password_reset_ttl" env-default:"1h"`
+	PasswordlessTTL         time.Duration `yaml:"passwordless_ttl" env-default:"10m"`
+	RateLimitWindow         time.Duration `yaml:"rate_limit_window" env-default:"15m"`
+	PasswordlessEmailLimit  int           `yaml:"passwordless_email_limit" env-default:"5"`
+	PasswordlessIPLimit     int           `yaml:"passwordless_ip_limit" env-default:"30"`
+	PasswordResetEmailLimit int           `yaml:"password_reset_email_limit" env-default:"3"`
+	PasswordResetIPLimit    int           `yaml:"password_reset_ip_limit" env-default:"30"`
+}
+
+// MFAConfig controls multi-factor authentication. EncryptionKey is the
+// base64 encoded 32 byte AES key authenticator app secrets are stored with,
+// users can't turn on MFA without it. Issuer names the account in
+// authenticator apps.
+type MFAConfig struct {
+	EncryptionKey string        `yaml:"encryption_key" env:"MFA_ENCRYPTION_KEY"`
+	Issuer        string        `yaml:"issuer" env-default:"sso"`
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+}
+
+// WebAuthnConfig controls passkeys. The relying party of every app is set in
+// the apps table, Timeout limits how long a ceremony can take.
+type WebAuthnConfig struct {
+	Timeout time.Duration `yaml:"timeout" env-default:"5m"`
+}
+
 type PostgresConfig struct {
 	Host     string `yaml:"host" env-required:"true"`
 	Port     int    `yaml:"port" env-required:"true" env-default:"5432"`
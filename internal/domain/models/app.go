@@ -1,8 +1,63 @@
 package models
 
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
 type App struct {
-	ID            int32  `db:"id"`
-	Name          string `db:"name"`
-	Secret        string `db:"secret"`
-	RefreshSecret string `db:"refresh_secret"`
+	ID             int32          `db:"id"`
+	Name           string         `db:"name"`
+	Secret         string         `db:"secret"`
+	RefreshSecret  string         `db:"refresh_secret"`
+	ClaimsTemplate ClaimsTemplate `db:"claims_template"`
+	// RedirectURIs are the exact URIs the app may receive OAuth
+	// authorization responses at.
+	RedirectURIs pq.StringArray `db:"redirect_uris"`
+	// ClientSecretHash makes the app a confidential client that can get
+	// tokens for itself. Only the SHA-256 hash of the secret is stored.
+	ClientSecretHash *string `db:"client_secret_hash"`
+	// ClientScopes are the scopes the app may request for itself.
+	ClientScopes pq.StringArray `db:"client_scopes"`
+	// ExchangeAudiences are the other apps the client may exchange the
+	// tokens issued to it for.
+	ExchangeAudiences pq.Int32Array `db:"exchange_audiences"`
+	// Scopes are the scopes users can grant the app, besides the OpenID
+	// Connect scopes.
+	Scopes pq.StringArray `db:"scopes"`
+	// RequireVerifiedEmail refuses logins of users that haven't verified
+	// their email.
+	RequireVerifiedEmail bool `db:"require_verified_email"`
+	// WebAuthnRPID is the relying party ID passkeys of the app are scoped
+	// to, nil disables passkeys. WebAuthnOrigins are the exact origins the
+	// ceremonies may run at.
+	WebAuthnRPID    *string        `db:"webauthn_rp_id"`
+	WebAuthnOrigins pq.StringArray `db:"webauthn_origins"`
+
+	// Token lifetimes of the app, nil falls back to the global config.
+	// A zero RefreshTTL disables refresh tokens for the app.
+	AccessTTL       *Seconds `db:"access_ttl"`
+	RefreshTTL      *Seconds `db:"refresh_ttl"`
+	SessionLifetime *Seconds `db:"session_lifetime"`
+	IdleTimeout     *Seconds `db:"idle_timeout"`
+}
+
+// HasRedirectURI reports whether the URI is registered for the app. URIs are
+// compared as is, without any normalization.
+func (a App) HasRedirectURI(uri string) bool {
+	for _, registered := range a.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Seconds is a duration stored as a number of seconds.
+type Seconds int64
+
+func (s Seconds) Duration() time.Duration {
+	return time.Duration(s) * time.Second
 }
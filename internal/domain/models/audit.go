@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Audit events.
+const (
+	AuditRecoveryCodeUsed = "recovery_code_used"
+)
+
+// AuditEvent records a security relevant action of a user and the client it
+// was made from.
+type AuditEvent struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Event     string    `db:"event"`
+	IP        string    `db:"ip"`
+	UserAgent string    `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+}
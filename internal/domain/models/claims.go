@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	// MaxCustomClaims limits the number of claims an app template may add.
+	MaxCustomClaims = 16
+	// MaxCustomClaimsSize limits the JSON encoded size of the added claims.
+	MaxCustomClaimsSize = 1024
+)
+
+var ErrInvalidClaimsTemplate = errors.New("invalid claims template")
+
+// reservedClaims can't be overridden by an app template.
+var reservedClaims = map[string]struct{}{
+	"iss": {}, "sub": {}, "aud": {}, "exp": {}, "nbf": {}, "iat": {}, "jti": {},
+	"user_id": {}, "app_id": {}, "fid": {}, "auth_time": {}, "client_id": {}, "scope": {}, "act": {},
+}
+
+// userAttributes are the user fields an app template may embed. Roles are
+// the names of the roles the user holds in the app the token is issued for,
+// so that a role in one app never shows up in the tokens of another.
+var userAttributes = map[string]func(user User, roles []string) any{
+	"email":    func(user User, _ []string) any { return user.Email },
+	"is_admin": func(_ User, roles []string) any { return slices.Contains(roles, RoleAdmin) },
+	"roles": func(_ User, roles []string) any {
+		if roles == nil {
+			return []string{}
+		}
+		return roles
+	},
+}
+
+// roleAttributes are the user attributes that depend on the roles of the
+// user in the app.
+var roleAttributes = map[string]struct{}{"is_admin": {}, "roles": {}}
+
+// ClaimsTemplate selects the extra claims embedded into the access tokens
+// of an app. User maps a claim name to a user attribute (e.g. "email"),
+// Static claims are copied into every token as is.
+//
+// Templates are validated whenever they are loaded from or saved to the
+// database, so that a broken template fails the app right away instead of
+// the first login.
+type ClaimsTemplate struct {
+	User   map[string]string `json:"user,omitempty"`
+	Static map[string]any    `json:"static,omitempty"`
+}
+
+func (t *ClaimsTemplate) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*t = ClaimsTemplate{}
+		return nil
+	case []byte:
+		err = json.Unmarshal(v, t)
+	case string:
+		err = json.Unmarshal([]byte(v), t)
+	default:
+		return errors.New("unsupported claims template type")
+	}
+	if err != nil {
+		return err
+	}
+
+	return t.Validate()
+}
+
+func (t ClaimsTemplate) Value() (driver.Value, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(t)
+}
+
+// Validate checks that the template only uses known user attributes,
+// doesn't touch reserved claims and stays within size limits.
+func (t ClaimsTemplate) Validate() error {
+	_, err := t.Render(User{}, nil)
+	return err
+}
+
+// UsesRoles reports whether the template embeds attributes derived from the
+// roles of the user, which then have to be passed to Render.
+func (t ClaimsTemplate) UsesRoles() bool {
+	for _, attribute := range t.User {
+		if _, ok := roleAttributes[attribute]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Render returns the claims of the template for the user holding the roles
+// in the app of the token. The size is checked again since user attributes
+// such as the email have no fixed size.
+func (t ClaimsTemplate) Render(user User, roles []string) (map[string]any, error) {
+	if len(t.User)+len(t.Static) == 0 {
+		return nil, nil
+	}
+	if len(t.User)+len(t.Static) > MaxCustomClaims {
+		return nil, fmt.Errorf("%w: more than %d claims", ErrInvalidClaimsTemplate, MaxCustomClaims)
+	}
+
+	claims := make(map[string]any, len(t.User)+len(t.Static))
+
+	for name, value := range t.Static {
+		if _, ok := reservedClaims[name]; ok {
+			return nil, fmt.Errorf("%w: claim %q is reserved", ErrInvalidClaimsTemplate, name)
+		}
+		claims[name] = value
+	}
+
+	for name, attribute := range t.User {
+		if _, ok := reservedClaims[name]; ok {
+			return nil, fmt.Errorf("%w: claim %q is reserved", ErrInvalidClaimsTemplate, name)
+		}
+		if _, ok := claims[name]; ok {
+			return nil, fmt.Errorf("%w: claim %q is defined twice", ErrInvalidClaimsTemplate, name)
+		}
+
+		value, ok := userAttributes[attribute]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown user attribute %q", ErrInvalidClaimsTemplate, attribute)
+		}
+		claims[name] = value(user, roles)
+	}
+
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClaimsTemplate, err)
+	}
+	if len(encoded) > MaxCustomClaimsSize {
+		return nil, fmt.Errorf("%w: claims exceed %d bytes", ErrInvalidClaimsTemplate, MaxCustomClaimsSize)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimsTemplate_Validate(t *testing.T) {
+	manyClaims := map[string]any{}
+	for i := range MaxCustomClaims + 1 {
+		manyClaims[fmt.Sprintf("claim_%d", i)] = i
+	}
+
+	tests := []struct {
+		name  string
+		tpl   ClaimsTemplate
+		valid bool
+	}{
+		{
+			name:  "Empty",
+			valid: true,
+		},
+		{
+			name: "Valid",
+			tpl: ClaimsTemplate{
+				User:   map[string]string{"email": "email"},
+				Static: map[string]any{"tenant": "acme"},
+			},
+			valid: true,
+		},
+		{
+			name: "Reserved static claim",
+			tpl:  ClaimsTemplate{Static: map[string]any{"sub": "admin"}},
+		},
+		{
+			name: "Reserved user claim",
+			tpl:  ClaimsTemplate{User: map[string]string{"scope": "email"}},
+		},
+		{
+			name: "Claim defined twice",
+			tpl: ClaimsTemplate{
+				User:   map[string]string{"tenant": "email"},
+				Static: map[string]any{"tenant": "acme"},
+			},
+		},
+		{
+			name: "Unknown user attribute",
+			tpl:  ClaimsTemplate{User: map[string]string{"password": "pass_hash"}},
+		},
+		{
+			name: "Too many claims",
+			tpl:  ClaimsTemplate{Static: manyClaims},
+		},
+		{
+			name: "Too large",
+			tpl:  ClaimsTemplate{Static: map[string]any{"blob": strings.Repeat("a", MaxCustomClaimsSize)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tpl.Validate()
+			if tt.valid {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidClaimsTemplate)
+		})
+	}
+}
+
+func TestClaimsTemplate_ScanValidates(t *testing.T) {
+	var tpl ClaimsTemplate
+
+	require.NoError(t, tpl.Scan([]byte(`{"user": {"email": "email"}, "static": {"tenant": "acme"}}`)))
+	assert.Equal(t, "email", tpl.User["email"])
+	assert.Equal(t, "acme", tpl.Static["tenant"])
+
+	// Broken templates fail the app when it is loaded.
+	err := tpl.Scan([]byte(`{"static": {"sub": "admin"}}`))
+	assert.ErrorIs(t, err, ErrInvalidClaimsTemplate)
+
+	// And can't be saved.
+	_, err = ClaimsTemplate{User: map[string]string{"role": "password"}}.Value()
+	assert.ErrorIs(t, err, ErrInvalidClaimsTemplate)
+}
+
+func TestClaimsTemplate_UsesRoles(t *testing.T) {
+	assert.False(t, ClaimsTemplate{}.UsesRoles())
+	assert.False(t, ClaimsTemplate{User: map[string]string{"email": "email"}}.UsesRoles())
+	assert.True(t, ClaimsTemplate{User: map[string]string{"admin": "is_admin"}}.UsesRoles())
+	assert.True(t, ClaimsTemplate{User: map[string]string{"groups": "roles"}}.UsesRoles())
+}
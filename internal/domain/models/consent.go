@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Consent is the set of scopes a user granted to an app. Later grants add
+// to the set.
+type Consent struct {
+	UserID    int64          `db:"user_id"`
+	AppID     int32          `db:"app_id"`
+	AppName   string         `db:"app_name"`
+	Scopes    pq.StringArray `db:"scopes"`
+	GrantedAt time.Time      `db:"granted_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Purposes of email tokens.
+const (
+	EmailTokenVerify        = "verify_email"
+	EmailTokenResetPassword = "reset_password"
+)
+
+// EmailToken is a single-use secret mailed to a user. It is only valid for
+// the address it was sent to. The ID is the hash of the secret.
+type EmailToken struct {
+	ID        string    `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Purpose   string    `db:"purpose"`
+	Email     string    `db:"email"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// UserIdentity links the account of a user at an external identity provider
+// to the local user. Subject is the stable user id at the provider.
+type UserIdentity struct {
+	Provider  string    `db:"provider"`
+	Subject   string    `db:"subject"`
+	UserID    int64     `db:"user_id"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// FederationState keeps an authorization request while the user signs in at
+// an external identity provider. Only the hash of the state sent to the
+// provider is stored.
+type FederationState struct {
+	ID       string `db:"id"`
+	Provider string `db:"provider"`
+	// Nonce and CodeVerifier bind the response of the provider to this
+	// login.
+	Nonce        string `db:"nonce"`
+	CodeVerifier string `db:"code_verifier"`
+	// The authorization request of the client, ClientState is echoed back
+	// with the code.
+	AppID               int32     `db:"app_id"`
+	RedirectURI         string    `db:"redirect_uri"`
+	CodeChallenge       string    `db:"code_challenge"`
+	CodeChallengeMethod string    `db:"code_challenge_method"`
+	Scope               string    `db:"scope"`
+	ClientNonce         string    `db:"client_nonce"`
+	ClientState         string    `db:"client_state"`
+	ExpiresAt           time.Time `db:"expires_at"`
+	CreatedAt           time.Time `db:"created_at"`
+}
+
+// AuthorizationRequest returns the authorization request of the client.
+func (s FederationState) AuthorizationRequest() AuthorizationRequest {
+	return AuthorizationRequest{
+		AppID:               s.AppID,
+		RedirectURI:         s.RedirectURI,
+		CodeChallenge:       s.CodeChallenge,
+		CodeChallengeMethod: s.CodeChallengeMethod,
+		Scope:               s.Scope,
+		Nonce:               s.ClientNonce,
+	}
+}
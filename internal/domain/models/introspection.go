@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Introspection describes a token as seen by this service. Inactive tokens
+// carry no other information. Tokens an app got for itself have a ClientID
+// instead of a UserID.
+type Introspection struct {
+	Active    bool
+	TokenID   string
+	Subject   string
+	UserID    int64
+	ClientID  string
+	AppID     int32
+	Issuer    string
+	Audience  []string
+	TokenType string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Scopes    []string
+	Roles     []string
+	// Actor is the subject acting on behalf of the user or client of an
+	// exchanged token.
+	Actor string
+}
+
+// IntrospectionRequest asks about Token on behalf of a resource server. The
+// resource server authenticates with its client credentials, or with an
+// AccessToken it got with the client credentials grant.
+type IntrospectionRequest struct {
+	Token        string
+	ClientID     string
+	ClientSecret string
+	AccessToken  string
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// TOTP is the authenticator app enrolled by a user. The secret is stored
+// encrypted, and MFA is only required once the enrollment is confirmed with
+// a code.
+type TOTP struct {
+	UserID         int64      `db:"user_id"`
+	Secret         string     `db:"secret"`
+	ConfirmedAt    *time.Time `db:"confirmed_at"`
+	LastUsedStep   int64      `db:"last_used_step"`
+	FailedAttempts int        `db:"failed_attempts"`
+	LastFailedAt   *time.Time `db:"last_failed_at"`
+	CreatedAt      time.Time  `db:"created_at"`
+}
+
+// TOTPEnrollment is what the user adds to their authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAChallenge is a login that passed the password check and waits for the
+// second factor. The ID is the hash of the challenge token.
+type MFAChallenge struct {
+	ID        string    `db:"id"`
+	UserID    int64     `db:"user_id"`
+	AppID     int32     `db:"app_id"`
+	Scope     string    `db:"scope"`
+	AuthTime  time.Time `db:"auth_time"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
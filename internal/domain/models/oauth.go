@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// AuthorizationRequest holds the parameters of an OAuth authorization
+// request that are bound to the issued code.
+type AuthorizationRequest struct {
+	AppID               int32
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Scope is the space separated list of requested scopes, "openid" asks
+	// for an ID token.
+	Scope string
+	// Nonce is echoed in the ID token to bind it to the client session.
+	Nonce string
+}
+
+// AuthorizationCode is a single-use OAuth authorization code. Only the hash
+// of the code is stored.
+type AuthorizationCode struct {
+	ID                  string     `db:"id"`
+	AppID               int32      `db:"app_id"`
+	UserID              int64      `db:"user_id"`
+	FamilyID            string     `db:"family_id"`
+	RedirectURI         string     `db:"redirect_uri"`
+	CodeChallenge       string     `db:"code_challenge"`
+	CodeChallengeMethod string     `db:"code_challenge_method"`
+	Scope               string     `db:"scope"`
+	Nonce               string     `db:"nonce"`
+	IP                  string     `db:"ip"`
+	UserAgent           string     `db:"user_agent"`
+	AuthTime            time.Time  `db:"auth_time"`
+	ExpiresAt           time.Time  `db:"expires_at"`
+	UsedAt              *time.Time `db:"used_at"`
+	CreatedAt           time.Time  `db:"created_at"`
+}
+
+// States of a device code. A pending code waits for the user to approve or
+// deny it on another device, an approved code is redeemed once for tokens.
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeRedeemed = "redeemed"
+)
+
+// DeviceCode is a pending grant of the device authorization flow (RFC 8628).
+// Only the hash of the device code is stored, the user code is shown to the
+// user and stored as is.
+type DeviceCode struct {
+	ID       string `db:"id"`
+	UserCode string `db:"user_code"`
+	AppID    int32  `db:"app_id"`
+	Scope    string `db:"scope"`
+	State    string `db:"state"`
+	// UserID and AuthTime are set once the user approved the code.
+	UserID   *int64     `db:"user_id"`
+	AuthTime *time.Time `db:"auth_time"`
+	// Interval is the minimum number of seconds between two polls.
+	Interval     Seconds    `db:"interval"`
+	LastPolledAt *time.Time `db:"last_polled_at"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// DeviceAuthorization is the response to a device authorization request.
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresAt  time.Time
+	Interval   time.Duration
+}
+
+// TokenExchangeRequest asks for a token for another app on behalf of the
+// subject of SubjectToken (RFC 8693). The actor is the user of ActorToken
+// if set, otherwise the client.
+type TokenExchangeRequest struct {
+	ClientID     string
+	ClientSecret string
+	SubjectToken string
+	ActorToken   string
+	// Audience is the client_id of the app the token is for, the app of the
+	// subject token if empty.
+	Audience string
+	Scopes   []string
+}
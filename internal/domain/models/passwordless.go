@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Ways a passwordless login code is delivered.
+const (
+	PasswordlessCode = "code"
+	PasswordlessLink = "link"
+)
+
+// PasswordlessRequest asks to mail a login code to the email. Links point to
+// RedirectURI, which has to be registered for the app. Client is who asked,
+// requests are rate limited by its IP.
+type PasswordlessRequest struct {
+	Email       string
+	AppID       int32
+	Scopes      []string
+	Method      string
+	RedirectURI string
+	Client      ClientInfo
+}
+
+// PasswordlessLogin is a login waiting for the code mailed to the email. The
+// ID is the hash of the login token handed to the client, the code is stored
+// hashed as well. Every completion attempt is counted.
+type PasswordlessLogin struct {
+	ID        string    `db:"id"`
+	Email     string    `db:"email"`
+	AppID     int32     `db:"app_id"`
+	Scope     string    `db:"scope"`
+	CodeHash  string    `db:"code_hash"`
+	Attempts  int       `db:"attempts"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Built-in roles.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleCommon    = "common"
+)
+
+// Permissions checked by the service itself. Roles may carry any other
+// permissions for the apps to check.
+const (
+	PermissionManageRoles      = "roles:manage"
+	PermissionManageSessions   = "sessions:manage"
+	PermissionManageClients    = "clients:manage"
+	PermissionImpersonateUsers = "users:impersonate"
+)
+
+// Role is a named set of permissions. Users are granted roles per app.
+// Roles created by an app can only be granted in that app, built-in roles
+// have no AppID and can be granted in every app.
+type Role struct {
+	ID          int32          `db:"id"`
+	AppID       *int32         `db:"app_id"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Permissions pq.StringArray `db:"permissions"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
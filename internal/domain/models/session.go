@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Session is a single login of a user. Its ID is the family id of the refresh
+// tokens rotated from that login.
+type Session struct {
+	ID         string     `db:"id"`
+	UserID     int64      `db:"user_id"`
+	AppID      int32      `db:"app_id"`
+	IP         string     `db:"ip"`
+	UserAgent  string     `db:"user_agent"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt time.Time  `db:"last_used_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+
+	// Current is set for the session of the access token the sessions were
+	// listed with.
+	Current bool `db:"-"`
+}
+
+// ClientInfo describes the client a login was made from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
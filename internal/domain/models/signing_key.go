@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	KeyStatePending = "pending"
+	KeyStateActive  = "active"
+	KeyStateRetired = "retired"
+)
+
+// SigningKey is a stored asymmetric key. AppID 0 marks a global key. The
+// PKCS #8 private key is stored encrypted.
+type SigningKey struct {
+	ID          string     `db:"id"`
+	AppID       int32      `db:"app_id"`
+	Algorithm   string     `db:"algorithm"`
+	PrivateKey  string     `db:"private_key"`
+	State       string     `db:"state"`
+	ActivatesAt time.Time  `db:"activates_at"`
+	RetiredAt   *time.Time `db:"retired_at"`
+	ExpiresAt   *time.Time `db:"expires_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
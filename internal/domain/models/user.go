@@ -1,7 +1,9 @@
 package models
 
+// User is an account. Roles are granted per app, see UserRoles.
 type User struct {
-	ID       int64  `db:"id"`
-	Email    string `db:"email"`
-	PassHash []byte `db:"pass_hash"`
+	ID            int64  `db:"id"`
+	Email         string `db:"email"`
+	PassHash      []byte `db:"pass_hash"`
+	EmailVerified bool   `db:"email_verified"`
 }
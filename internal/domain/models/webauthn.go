@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Purposes of WebAuthn ceremonies.
+const (
+	WebAuthnRegister = "register"
+	WebAuthnLogin    = "login"
+	WebAuthnMFA      = "mfa"
+)
+
+// WebAuthnCredential is a passkey of a user. The ID is the base64url encoded
+// credential ID, the public key is COSE encoded.
+type WebAuthnCredential struct {
+	ID         string     `db:"id"`
+	UserID     int64      `db:"user_id"`
+	RPID       string     `db:"rp_id"`
+	PublicKey  []byte     `db:"public_key"`
+	SignCount  int64      `db:"sign_count"`
+	Name       string     `db:"name"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+}
+
+// WebAuthnCeremony is a started registration or authentication waiting for
+// the response of the authenticator. The ID is the hash of the challenge.
+// Passwordless logins don't know the user yet, second factor logins continue
+// the MFA challenge.
+type WebAuthnCeremony struct {
+	ID             string    `db:"id"`
+	Purpose        string    `db:"purpose"`
+	UserID         *int64    `db:"user_id"`
+	AppID          int32     `db:"app_id"`
+	Scope          string    `db:"scope"`
+	MFAChallengeID string    `db:"mfa_challenge_id"`
+	ExpiresAt      time.Time `db:"expires_at"`
+	CreatedAt      time.Time `db:"created_at"`
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"sso/internal/domain/models"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const bearerPrefix = "Bearer "
+
+// accessToken extracts the bearer token from the "authorization" metadata.
+func accessToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], bearerPrefix) {
+		return "", status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(values[0], bearerPrefix))
+	if token == "" {
+		return "", status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	return token, nil
+}
+
+// clientInfo describes the caller for the session registry: the address of
+// the gRPC peer and the "user-agent" metadata.
+func clientInfo(ctx context.Context) models.ClientInfo {
+	var client models.ClientInfo
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			client.UserAgent = values[0]
+		}
+	}
+
+	return client
+}
@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"sso/internal/domain/models"
 	"sso/internal/lib/jwt"
 	"sso/internal/services/auth"
 	"sso/internal/storage"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	ssov1 "github.com/nikitauty/protos/gen/go/sso"
@@ -16,7 +20,42 @@ import (
 )
 
 type Auth interface {
-	Login(email string, password string, appID int32) (pair jwt.TokenPair, err error)
+	Login(email string, password string, appID int32, scopes []string, client models.ClientInfo) (pair jwt.TokenPair, mfaToken string, err error)
+	Refresh(refreshToken string, appID int32) (pair jwt.TokenPair, err error)
+	Logout(refreshToken string, appID int32) error
+	LogoutAll(accessToken string) error
+	JWKS() jwt.JWKS
+	Introspect(req models.IntrospectionRequest) (models.Introspection, error)
+	ListSessions(accessToken string) ([]models.Session, error)
+	RevokeSession(accessToken string, sessionID string) error
+	AdminListSessions(accessToken string, userID int64) ([]models.Session, error)
+	AdminRevokeSession(accessToken string, sessionID string) error
+	RegisterClient(accessToken string, appID int32, scopes []string) (clientID string, clientSecret string, err error)
+	ClientCredentials(clientID string, clientSecret string, scopes []string) (jwt.TokenPair, error)
+	ApproveDevice(accessToken string, userCode string, approve bool) error
+	ExchangeToken(req models.TokenExchangeRequest) (jwt.TokenPair, error)
+	ListConsents(accessToken string) ([]models.Consent, error)
+	RevokeConsent(accessToken string, appID int32) error
+	VerifyEmail(token string) error
+	ResendVerification(email string) error
+	RequestPasswordReset(email string, client models.ClientInfo) error
+	ResetPassword(token string, newPassword string) error
+	ChangePassword(accessToken string, currentPassword string, newPassword string) error
+	ChangeEmail(accessToken string, password string, newEmail string) error
+	EnrollTOTP(accessToken string) (models.TOTPEnrollment, error)
+	ConfirmTOTP(accessToken string, code string) ([]string, error)
+	RegenerateRecoveryCodes(accessToken string, password string) ([]string, error)
+	VerifyMFA(mfaToken string, code string, client models.ClientInfo) (jwt.TokenPair, error)
+	BeginPasskeyRegistration(accessToken string) (optionsJSON string, err error)
+	FinishPasskeyRegistration(accessToken string, credentialJSON string, name string) error
+	BeginPasskeyLogin(appID int32, scopes []string, mfaToken string) (optionsJSON string, err error)
+	FinishPasskeyLogin(credentialJSON string, client models.ClientInfo) (jwt.TokenPair, error)
+	StartPasswordlessLogin(req models.PasswordlessRequest) (loginToken string, err error)
+	CompletePasswordlessLogin(loginToken string, code string, client models.ClientInfo) (pair jwt.TokenPair, mfaToken string, err error)
+	CreateRole(accessToken string, name string, description string, permissions []string) (roleID int32, err error)
+	GrantRole(accessToken string, userID int64, appID int32, role string) error
+	RevokeRole(accessToken string, userID int64, appID int32, role string) error
+	UserRoles(accessToken string, userID int64, appID int32) ([]models.Role, error)
 	RegisterNewUser(email string, password string) (userID int64, err error)
 	IsAdmin(userID int64) (bool, error)
 }
@@ -56,7 +95,7 @@ func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("email is not valid %s", validationErrors))
 	}
 
-	pair, err := s.auth.Login(data.Email, data.Password, data.AppId)
+	pair, mfaToken, err := s.auth.Login(data.Email, data.Password, data.AppId, req.GetScopes(), clientInfo(ctx))
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidEmailOrPassword) {
 			return nil, status.Error(codes.InvalidArgument, "invalid email or password")
@@ -64,14 +103,448 @@ func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		if errors.Is(err, auth.ErrInvalidAppID) {
 			return nil, status.Error(codes.InvalidArgument, "invalid app id")
 		}
+		if errors.Is(err, auth.ErrInvalidScope) {
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email is not verified")
+		}
 		return nil, status.Error(codes.Internal, "internal error")
 	}
 
+	if mfaToken != "" {
+		return &ssov1.LoginResponse{MfaToken: mfaToken}, nil
+	}
+
 	return &ssov1.LoginResponse{
-		Token: pair.AccessToken,
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		Scopes:       strings.Fields(pair.Scope),
+	}, nil
+}
+
+func (s *serverAPI) Refresh(ctx context.Context, req *ssov1.RefreshRequest) (*ssov1.RefreshResponse, error) {
+	data := RefreshReq{
+		RefreshToken: req.GetRefreshToken(),
+		AppId:        req.GetAppId(),
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := validate.Struct(data); err != nil {
+		if data.RefreshToken == "" {
+			return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+		}
+
+		return nil, status.Error(codes.InvalidArgument, "wrong app id")
+	}
+
+	pair, err := s.auth.Refresh(data.RefreshToken, data.AppId)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.RefreshResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) Logout(ctx context.Context, req *ssov1.LogoutRequest) (*ssov1.LogoutResponse, error) {
+	data := LogoutReq{
+		RefreshToken: req.GetRefreshToken(),
+		AppId:        req.GetAppId(),
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := validate.Struct(data); err != nil {
+		if data.RefreshToken == "" {
+			return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+		}
+
+		return nil, status.Error(codes.InvalidArgument, "wrong app id")
+	}
+
+	if err := s.auth.Logout(data.RefreshToken, data.AppId); err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.LogoutResponse{}, nil
+}
+
+func (s *serverAPI) LogoutAll(ctx context.Context, req *ssov1.LogoutAllRequest) (*ssov1.LogoutAllResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.LogoutAll(token); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.LogoutAllResponse{}, nil
+}
+
+func (s *serverAPI) JWKS(ctx context.Context, req *ssov1.JWKSRequest) (*ssov1.JWKSResponse, error) {
+	jwks := s.auth.JWKS()
+
+	keys := make([]*ssov1.JWK, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys = append(keys, &ssov1.JWK{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return &ssov1.JWKSResponse{
+		Keys: keys,
+	}, nil
+}
+
+func (s *serverAPI) Introspect(ctx context.Context, req *ssov1.IntrospectRequest) (*ssov1.IntrospectResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	introspection := models.IntrospectionRequest{
+		Token:        req.GetToken(),
+		ClientID:     req.GetClientId(),
+		ClientSecret: req.GetClientSecret(),
+	}
+	if introspection.ClientID == "" && introspection.ClientSecret == "" {
+		token, err := accessToken(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		introspection.AccessToken = token
+	}
+
+	info, err := s.auth.Introspect(introspection)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	if !info.Active {
+		return &ssov1.IntrospectResponse{Active: false}, nil
+	}
+
+	return &ssov1.IntrospectResponse{
+		Active:    true,
+		UserId:    info.UserID,
+		ClientId:  info.ClientID,
+		AppId:     info.AppID,
+		Exp:       info.ExpiresAt.Unix(),
+		Iat:       info.IssuedAt.Unix(),
+		Jti:       info.TokenID,
+		Scopes:    info.Scopes,
+		Roles:     info.Roles,
+		Actor:     info.Actor,
+		Sub:       info.Subject,
+		Aud:       info.Audience,
+		Iss:       info.Issuer,
+		TokenType: info.TokenType,
+	}, nil
+}
+
+func (s *serverAPI) ListSessions(ctx context.Context, req *ssov1.ListSessionsRequest) (*ssov1.ListSessionsResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.auth.ListSessions(token)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.ListSessionsResponse{
+		Sessions: toSessions(sessions),
+	}, nil
+}
+
+func (s *serverAPI) RevokeSession(ctx context.Context, req *ssov1.RevokeSessionRequest) (*ssov1.RevokeSessionResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	if err := s.auth.RevokeSession(token, req.GetSessionId()); err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.RevokeSessionResponse{}, nil
+}
+
+func (s *serverAPI) AdminListSessions(ctx context.Context, req *ssov1.AdminListSessionsRequest) (*ssov1.ListSessionsResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	sessions, err := s.auth.AdminListSessions(token, req.GetUserId())
+	if err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.ListSessionsResponse{
+		Sessions: toSessions(sessions),
+	}, nil
+}
+
+func (s *serverAPI) AdminRevokeSession(ctx context.Context, req *ssov1.RevokeSessionRequest) (*ssov1.RevokeSessionResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	if err := s.auth.AdminRevokeSession(token, req.GetSessionId()); err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.RevokeSessionResponse{}, nil
+}
+
+func (s *serverAPI) RegisterClient(ctx context.Context, req *ssov1.RegisterClientRequest) (*ssov1.RegisterClientResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "wrong app id")
+	}
+
+	clientID, clientSecret, err := s.auth.RegisterClient(token, req.GetAppId(), req.GetScopes())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAccessToken):
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		case errors.Is(err, auth.ErrPermissionDenied):
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		case errors.Is(err, auth.ErrInvalidAppID):
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		default:
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return &ssov1.RegisterClientResponse{
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+func (s *serverAPI) ClientCredentials(ctx context.Context, req *ssov1.ClientCredentialsRequest) (*ssov1.ClientCredentialsResponse, error) {
+	if req.GetClientId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if req.GetClientSecret() == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_secret is required")
+	}
+
+	pair, err := s.auth.ClientCredentials(req.GetClientId(), req.GetClientSecret(), req.GetScopes())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		default:
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return &ssov1.ClientCredentialsResponse{
+		Token:     pair.AccessToken,
+		ExpiresIn: int64(time.Until(pair.AccessExpiresAt).Seconds()),
+		Scopes:    strings.Fields(pair.Scope),
+	}, nil
+}
+
+func (s *serverAPI) ExchangeToken(ctx context.Context, req *ssov1.ExchangeTokenRequest) (*ssov1.ExchangeTokenResponse, error) {
+	if req.GetSubjectToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "subject_token is required")
+	}
+
+	pair, err := s.auth.ExchangeToken(models.TokenExchangeRequest{
+		ClientID:     req.GetClientId(),
+		ClientSecret: req.GetClientSecret(),
+		SubjectToken: req.GetSubjectToken(),
+		ActorToken:   req.GetActorToken(),
+		Audience:     req.GetAudience(),
+		Scopes:       req.GetScopes(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		case errors.Is(err, auth.ErrInvalidSubjectToken):
+			return nil, status.Error(codes.InvalidArgument, "invalid subject token")
+		case errors.Is(err, auth.ErrInvalidActorToken):
+			return nil, status.Error(codes.Unauthenticated, "invalid actor token")
+		case errors.Is(err, auth.ErrPermissionDenied):
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		case errors.Is(err, auth.ErrInvalidTarget):
+			return nil, status.Error(codes.InvalidArgument, "invalid audience")
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		case errors.Is(err, auth.ErrScopeRequired):
+			return nil, status.Error(codes.InvalidArgument, "scopes are required for another audience")
+		default:
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return &ssov1.ExchangeTokenResponse{
+		Token:     pair.AccessToken,
+		ExpiresIn: int64(time.Until(pair.AccessExpiresAt).Seconds()),
+		Scopes:    strings.Fields(pair.Scope),
 	}, nil
 }
 
+func (s *serverAPI) ApproveDevice(ctx context.Context, req *ssov1.ApproveDeviceRequest) (*ssov1.ApproveDeviceResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetUserCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_code is required")
+	}
+
+	if err := s.auth.ApproveDevice(token, req.GetUserCode(), req.GetApprove()); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAccessToken):
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		case errors.Is(err, auth.ErrInvalidUserCode):
+			return nil, status.Error(codes.NotFound, "user code not found or expired")
+		case errors.Is(err, auth.ErrPermissionDenied):
+			return nil, status.Error(codes.PermissionDenied, "the access token can't approve the device code")
+		default:
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return &ssov1.ApproveDeviceResponse{}, nil
+}
+
+func (s *serverAPI) ListConsents(ctx context.Context, req *ssov1.ListConsentsRequest) (*ssov1.ListConsentsResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := s.auth.ListConsents(token)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+
+	resp := make([]*ssov1.Consent, 0, len(consents))
+	for _, consent := range consents {
+		resp = append(resp, &ssov1.Consent{
+			AppId:     consent.AppID,
+			AppName:   consent.AppName,
+			Scopes:    consent.Scopes,
+			GrantedAt: consent.GrantedAt.Unix(),
+			UpdatedAt: consent.UpdatedAt.Unix(),
+		})
+	}
+
+	return &ssov1.ListConsentsResponse{Consents: resp}, nil
+}
+
+func (s *serverAPI) RevokeConsent(ctx context.Context, req *ssov1.RevokeConsentRequest) (*ssov1.RevokeConsentResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if err := s.auth.RevokeConsent(token, req.GetAppId()); err != nil {
+		if errors.Is(err, auth.ErrConsentNotFound) {
+			return nil, status.Error(codes.NotFound, "consent not found")
+		}
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.RevokeConsentResponse{}, nil
+}
+
+func sessionError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccessToken):
+		return status.Error(codes.Unauthenticated, "invalid access token")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.Is(err, auth.ErrSessionNotFound):
+		return status.Error(codes.NotFound, "session not found")
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func toSessions(sessions []models.Session) []*ssov1.Session {
+	resp := make([]*ssov1.Session, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, &ssov1.Session{
+			Id:         session.ID,
+			UserId:     session.UserID,
+			AppId:      session.AppID,
+			Ip:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			ExpiresAt:  session.ExpiresAt.Unix(),
+			Current:    session.Current,
+		})
+	}
+
+	return resp
+}
+
 func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*ssov1.RegisterResponse, error) {
 	data := RegisterReq{
 		Email:    req.GetEmail(),
@@ -107,6 +580,386 @@ func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 	}, nil
 }
 
+func (s *serverAPI) VerifyEmail(ctx context.Context, req *ssov1.VerifyEmailRequest) (*ssov1.VerifyEmailResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	if err := s.auth.VerifyEmail(req.GetToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.VerifyEmailResponse{}, nil
+}
+
+func (s *serverAPI) ResendVerification(ctx context.Context, req *ssov1.ResendVerificationRequest) (*ssov1.ResendVerificationResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if err := s.auth.ResendVerification(req.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.ResendVerificationResponse{}, nil
+}
+
+func (s *serverAPI) RequestPasswordReset(ctx context.Context, req *ssov1.RequestPasswordResetRequest) (*ssov1.RequestPasswordResetResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if err := s.auth.RequestPasswordReset(req.GetEmail(), clientInfo(ctx)); err != nil {
+		if errors.Is(err, auth.ErrTooManyRequests) {
+			return nil, status.Error(codes.ResourceExhausted, "too many password resets requested, try again later")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.RequestPasswordResetResponse{}, nil
+}
+
+func (s *serverAPI) ResetPassword(ctx context.Context, req *ssov1.ResetPasswordRequest) (*ssov1.ResetPasswordResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	if req.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_password is required")
+	}
+
+	if err := s.auth.ResetPassword(req.GetToken(), req.GetNewPassword()); err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.ResetPasswordResponse{}, nil
+}
+
+func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*ssov1.ChangePasswordResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetCurrentPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "current_password is required")
+	}
+
+	if req.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_password is required")
+	}
+
+	if err := s.auth.ChangePassword(token, req.GetCurrentPassword(), req.GetNewPassword()); err != nil {
+		if errors.Is(err, auth.ErrInvalidPassword) {
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		}
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.ChangePasswordResponse{}, nil
+}
+
+func (s *serverAPI) ChangeEmail(ctx context.Context, req *ssov1.ChangeEmailRequest) (*ssov1.ChangeEmailResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+
+	if req.GetNewEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_email is required")
+	}
+
+	if err := s.auth.ChangeEmail(token, req.GetPassword(), req.GetNewEmail()); err != nil {
+		if errors.Is(err, auth.ErrInvalidPassword) {
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		}
+		return nil, sessionError(err)
+	}
+
+	return &ssov1.ChangeEmailResponse{}, nil
+}
+
+func (s *serverAPI) EnrollTOTP(ctx context.Context, req *ssov1.EnrollTOTPRequest) (*ssov1.EnrollTOTPResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := s.auth.EnrollTOTP(token)
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.EnrollTOTPResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.URI,
+	}, nil
+}
+
+func (s *serverAPI) ConfirmTOTP(ctx context.Context, req *ssov1.ConfirmTOTPRequest) (*ssov1.ConfirmTOTPResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	recoveryCodes, err := s.auth.ConfirmTOTP(token, req.GetCode())
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *serverAPI) RegenerateRecoveryCodes(ctx context.Context, req *ssov1.RegenerateRecoveryCodesRequest) (*ssov1.RegenerateRecoveryCodesResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+
+	recoveryCodes, err := s.auth.RegenerateRecoveryCodes(token, req.GetPassword())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPassword) {
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		}
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.RegenerateRecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *serverAPI) VerifyMFA(ctx context.Context, req *ssov1.VerifyMFARequest) (*ssov1.VerifyMFAResponse, error) {
+	if req.GetMfaToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "mfa_token is required")
+	}
+
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	pair, err := s.auth.VerifyMFA(req.GetMfaToken(), req.GetCode(), clientInfo(ctx))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired mfa token")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email is not verified")
+		}
+		return nil, mfaError(err)
+	}
+
+	return &ssov1.VerifyMFAResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		Scopes:       strings.Fields(pair.Scope),
+	}, nil
+}
+
+func mfaError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		return status.Error(codes.InvalidArgument, "invalid mfa code")
+	case errors.Is(err, auth.ErrMFALocked):
+		return status.Error(codes.ResourceExhausted, "too many wrong mfa codes, try again later")
+	case errors.Is(err, auth.ErrMFAEnabled):
+		return status.Error(codes.AlreadyExists, "mfa already enabled")
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		return status.Error(codes.FailedPrecondition, "mfa not enrolled")
+	case errors.Is(err, auth.ErrMFAUnavailable):
+		return status.Error(codes.FailedPrecondition, "mfa is not available")
+	default:
+		return sessionError(err)
+	}
+}
+
+func (s *serverAPI) BeginPasskeyRegistration(ctx context.Context, req *ssov1.BeginPasskeyRegistrationRequest) (*ssov1.BeginPasskeyRegistrationResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := s.auth.BeginPasskeyRegistration(token)
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	return &ssov1.BeginPasskeyRegistrationResponse{OptionsJson: options}, nil
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(ctx context.Context, req *ssov1.FinishPasskeyRegistrationRequest) (*ssov1.FinishPasskeyRegistrationResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetCredentialJson() == "" {
+		return nil, status.Error(codes.InvalidArgument, "credential_json is required")
+	}
+
+	if err := s.auth.FinishPasskeyRegistration(token, req.GetCredentialJson(), req.GetName()); err != nil {
+		return nil, passkeyError(err)
+	}
+
+	return &ssov1.FinishPasskeyRegistrationResponse{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(ctx context.Context, req *ssov1.BeginPasskeyLoginRequest) (*ssov1.BeginPasskeyLoginResponse, error) {
+	if req.GetAppId() == 0 && req.GetMfaToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "wrong app id")
+	}
+
+	options, err := s.auth.BeginPasskeyLogin(req.GetAppId(), req.GetScopes(), req.GetMfaToken())
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	return &ssov1.BeginPasskeyLoginResponse{OptionsJson: options}, nil
+}
+
+func (s *serverAPI) FinishPasskeyLogin(ctx context.Context, req *ssov1.FinishPasskeyLoginRequest) (*ssov1.FinishPasskeyLoginResponse, error) {
+	if req.GetCredentialJson() == "" {
+		return nil, status.Error(codes.InvalidArgument, "credential_json is required")
+	}
+
+	pair, err := s.auth.FinishPasskeyLogin(req.GetCredentialJson(), clientInfo(ctx))
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	return &ssov1.FinishPasskeyLoginResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		Scopes:       strings.Fields(pair.Scope),
+	}, nil
+}
+
+func passkeyError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidPasskey):
+		return status.Error(codes.Unauthenticated, "invalid passkey")
+	case errors.Is(err, auth.ErrPasskeyExists):
+		return status.Error(codes.AlreadyExists, "passkey already registered")
+	case errors.Is(err, auth.ErrPasskeysUnavailable):
+		return status.Error(codes.FailedPrecondition, "passkeys are not available for the app")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.InvalidArgument, "invalid app id")
+	case errors.Is(err, auth.ErrInvalidScope):
+		return status.Error(codes.InvalidArgument, "invalid scope")
+	case errors.Is(err, auth.ErrInvalidMFAToken):
+		return status.Error(codes.Unauthenticated, "invalid or expired mfa token")
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		return status.Error(codes.FailedPrecondition, "email is not verified")
+	default:
+		return mfaError(err)
+	}
+}
+
+func (s *serverAPI) StartPasswordlessLogin(ctx context.Context, req *ssov1.StartPasswordlessLoginRequest) (*ssov1.StartPasswordlessLoginResponse, error) {
+	data := PasswordlessLoginReq{
+		Email:  req.GetEmail(),
+		AppId:  req.GetAppId(),
+		Method: cmp.Or(req.GetMethod(), models.PasswordlessCode),
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := validate.Struct(data); err != nil {
+		if data.Email == "" {
+			return nil, status.Error(codes.InvalidArgument, "email is required")
+		}
+		if data.AppId == 0 {
+			return nil, status.Error(codes.InvalidArgument, "wrong app id")
+		}
+		if data.Method != models.PasswordlessCode && data.Method != models.PasswordlessLink {
+			return nil, status.Error(codes.InvalidArgument, "method must be code or link")
+		}
+
+		var validationErrors validator.ValidationErrors
+		errors.As(err, &validationErrors)
+
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("email is not valid %s", validationErrors))
+	}
+
+	loginToken, err := s.auth.StartPasswordlessLogin(models.PasswordlessRequest{
+		Email:       data.Email,
+		AppID:       data.AppId,
+		Scopes:      req.GetScopes(),
+		Method:      data.Method,
+		RedirectURI: req.GetRedirectUri(),
+		Client:      clientInfo(ctx),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrTooManyRequests):
+			return nil, status.Error(codes.ResourceExhausted, "too many login codes requested, try again later")
+		case errors.Is(err, auth.ErrInvalidAppID):
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		case errors.Is(err, auth.ErrInvalidRedirectURI):
+			return nil, status.Error(codes.InvalidArgument, "invalid redirect uri")
+		case errors.Is(err, auth.ErrInvalidLoginMethod):
+			return nil, status.Error(codes.InvalidArgument, "method must be code or link")
+		default:
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return &ssov1.StartPasswordlessLoginResponse{LoginToken: loginToken}, nil
+}
+
+func (s *serverAPI) CompletePasswordlessLogin(ctx context.Context, req *ssov1.CompletePasswordlessLoginRequest) (*ssov1.CompletePasswordlessLoginResponse, error) {
+	if req.GetLoginToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "login_token is required")
+	}
+
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	pair, mfaToken, err := s.auth.CompletePasswordlessLogin(req.GetLoginToken(), req.GetCode(), clientInfo(ctx))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidLoginCode) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired login code")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email is not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	if mfaToken != "" {
+		return &ssov1.CompletePasswordlessLoginResponse{MfaToken: mfaToken}, nil
+	}
+
+	return &ssov1.CompletePasswordlessLoginResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		Scopes:       strings.Fields(pair.Scope),
+	}, nil
+}
+
 func (s *serverAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ssov1.IsAdminResponse, error) {
 	data := IsAdminReq{
 		UserID: req.GetUserId(),
@@ -128,3 +981,123 @@ func (s *serverAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ss
 		IsAdmin: isAdmin,
 	}, nil
 }
+
+func (s *serverAPI) CreateRole(ctx context.Context, req *ssov1.CreateRoleRequest) (*ssov1.CreateRoleResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	roleID, err := s.auth.CreateRole(token, req.GetName(), req.GetDescription(), req.GetPermissions())
+	if err != nil {
+		return nil, roleError(err)
+	}
+
+	return &ssov1.CreateRoleResponse{RoleId: roleID}, nil
+}
+
+func (s *serverAPI) GrantRole(ctx context.Context, req *ssov1.GrantRoleRequest) (*ssov1.GrantRoleResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateRoleGrant(req.GetUserId(), req.GetAppId(), req.GetRole()); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.GrantRole(token, req.GetUserId(), req.GetAppId(), req.GetRole()); err != nil {
+		return nil, roleError(err)
+	}
+
+	return &ssov1.GrantRoleResponse{}, nil
+}
+
+func (s *serverAPI) RevokeRole(ctx context.Context, req *ssov1.RevokeRoleRequest) (*ssov1.RevokeRoleResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateRoleGrant(req.GetUserId(), req.GetAppId(), req.GetRole()); err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.RevokeRole(token, req.GetUserId(), req.GetAppId(), req.GetRole()); err != nil {
+		return nil, roleError(err)
+	}
+
+	return &ssov1.RevokeRoleResponse{}, nil
+}
+
+func (s *serverAPI) UserRoles(ctx context.Context, req *ssov1.UserRolesRequest) (*ssov1.UserRolesResponse, error) {
+	token, err := accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	if req.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "wrong app id")
+	}
+
+	roles, err := s.auth.UserRoles(token, req.GetUserId(), req.GetAppId())
+	if err != nil {
+		return nil, roleError(err)
+	}
+
+	resp := make([]*ssov1.Role, 0, len(roles))
+	for _, role := range roles {
+		resp = append(resp, &ssov1.Role{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return &ssov1.UserRolesResponse{Roles: resp}, nil
+}
+
+func validateRoleGrant(userID int64, appID int32, role string) error {
+	if userID == 0 {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if appID == 0 {
+		return status.Error(codes.InvalidArgument, "wrong app id")
+	}
+	if role == "" {
+		return status.Error(codes.InvalidArgument, "role is required")
+	}
+
+	return nil
+}
+
+func roleError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccessToken):
+		return status.Error(codes.Unauthenticated, "invalid access token")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.Is(err, auth.ErrInvalidRole):
+		return status.Error(codes.InvalidArgument, "invalid role name")
+	case errors.Is(err, auth.ErrInvalidPermission):
+		return status.Error(codes.InvalidArgument, "invalid permission")
+	case errors.Is(err, auth.ErrRoleExists):
+		return status.Error(codes.AlreadyExists, "role already exists")
+	case errors.Is(err, auth.ErrRoleNotFound):
+		return status.Error(codes.NotFound, "role not found")
+	case errors.Is(err, auth.ErrRoleNotGranted):
+		return status.Error(codes.NotFound, "role not granted")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
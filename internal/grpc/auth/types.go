@@ -11,6 +11,22 @@ type RegisterReq struct {
 	Password string `validate:"required"`
 }
 
+type PasswordlessLoginReq struct {
+	Email  string `validate:"required,email"`
+	AppId  int32  `validate:"required"`
+	Method string `validate:"oneof=code link"`
+}
+
 type IsAdminReq struct {
 	UserID int64
 }
+
+type RefreshReq struct {
+	RefreshToken string `validate:"required"`
+	AppId        int32  `validate:"required"`
+}
+
+type LogoutReq struct {
+	RefreshToken string `validate:"required"`
+	AppId        int32  `validate:"required"`
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/services/auth"
+)
+
+type accountPage struct {
+	Title string
+	Error string
+	// Token is posted back by the form, it is only redeemed on submit so
+	// that mail scanners opening the link don't use it up.
+	Token string
+	// Password adds a new password field to the form.
+	Password bool
+	// Submit labels the button of the form.
+	Submit string
+	// Done is the outcome shown once the form was submitted.
+	Done string
+}
+
+var accountTemplate = template.Must(template.New("account").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Done}}<p>{{.Done}}</p>
+{{else}}{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}{{if .Token}}<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+{{if .Password}}<label>New password <input type="password" name="new_password" autocomplete="new-password" required></label>
+{{end}}<button type="submit">{{.Submit}}</button>
+</form>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// verifyEmail shows the page the verification link points to.
+func (h *handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	page := accountPage{
+		Title:  "Verify your email",
+		Token:  r.FormValue("token"),
+		Submit: "Verify email",
+	}
+	if page.Token == "" {
+		page.Error = "The link is incomplete, open the link from the email again."
+	}
+
+	h.renderAccount(w, http.StatusOK, page)
+}
+
+func (h *handler) verifyEmailSubmit(w http.ResponseWriter, r *http.Request) {
+	page := accountPage{Title: "Verify your email"}
+
+	if err := h.auth.VerifyEmail(r.PostFormValue("token")); err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			page.Error = "The link is invalid or has expired, request a new one."
+			h.renderAccount(w, http.StatusBadRequest, page)
+			return
+		}
+		page.Error = "Something went wrong, try again later."
+		h.renderAccount(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	page.Done = "Your email is verified."
+	h.renderAccount(w, http.StatusOK, page)
+}
+
+// resetPassword shows the page the password reset link points to.
+func (h *handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	page := accountPage{
+		Title:    "Reset your password",
+		Token:    r.FormValue("token"),
+		Password: true,
+		Submit:   "Set password",
+	}
+	if page.Token == "" {
+		page.Error = "The link is incomplete, open the link from the email again."
+	}
+
+	h.renderAccount(w, http.StatusOK, page)
+}
+
+func (h *handler) resetPasswordSubmit(w http.ResponseWriter, r *http.Request) {
+	page := accountPage{
+		Title:    "Reset your password",
+		Token:    r.PostFormValue("token"),
+		Password: true,
+		Submit:   "Set password",
+	}
+
+	password := r.PostFormValue("new_password")
+	if password == "" {
+		page.Error = "Enter a new password."
+		h.renderAccount(w, http.StatusBadRequest, page)
+		return
+	}
+
+	if err := h.auth.ResetPassword(page.Token, password); err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			page.Token = ""
+			page.Error = "The link is invalid or has expired, request a new one."
+			h.renderAccount(w, http.StatusBadRequest, page)
+			return
+		}
+		page.Error = "Something went wrong, try again later."
+		h.renderAccount(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	page.Done = "Your password is changed. Sign in with the new password."
+	h.renderAccount(w, http.StatusOK, page)
+}
+
+func (h *handler) renderAccount(w http.ResponseWriter, status int, page accountPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := accountTemplate.Execute(w, page); err != nil {
+		h.log.Error("failed to render account page", sl.Err(err))
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/services/auth"
+	"time"
+)
+
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type devicePage struct {
+	AppName  string
+	UserCode string
+	Email    string
+	Error    string
+	// MFA asks for the code of the authenticator app along with the
+	// password.
+	MFA bool
+	// Done is the outcome shown once the user decided on the code.
+	Done string
+}
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Connect a device</title>
+</head>
+<body>
+<h1>{{if .AppName}}Connect {{.AppName}}{{else}}Connect a device{{end}}</h1>
+{{if .Done}}<p>{{.Done}}</p>
+{{else}}{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}<form method="post" action="/device">
+<label>Code <input type="text" name="user_code" value="{{.UserCode}}" required autocomplete="off"></label>
+<label>Email <input type="email" name="email" value="{{.Email}}" required></label>
+<label>Password <input type="password" name="password" required></label>
+{{if .MFA}}<label>Authentication or recovery code <input type="text" name="mfa_code" autocomplete="one-time-code" required></label>
+{{end}}<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// deviceAuthorization implements the device authorization endpoint (RFC 8628
+// section 3.1) for public clients identified by client_id.
+func (h *handler) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	appID, ok := parseClientID(r.PostFormValue("client_id"))
+	if !ok {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "client_id is invalid",
+		})
+		return
+	}
+
+	da, err := h.auth.DeviceAuthorization(appID, r.PostFormValue("scope"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAppID):
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_client"})
+		case errors.Is(err, auth.ErrInvalidScope):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope"})
+		default:
+			h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	verificationURI := h.issuer + "/device"
+
+	h.writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              da.DeviceCode,
+		UserCode:                da.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {da.UserCode}}.Encode(),
+		ExpiresIn:               int64(time.Until(da.ExpiresAt).Seconds()),
+		Interval:                int64(da.Interval.Seconds()),
+	})
+}
+
+// device shows the verification page where the user enters the user code
+// displayed by the device, possibly prefilled from the complete URI.
+func (h *handler) device(w http.ResponseWriter, r *http.Request) {
+	page := devicePage{UserCode: r.FormValue("user_code")}
+
+	if page.UserCode != "" {
+		app, err := h.auth.DeviceApp(page.UserCode)
+		switch {
+		case err == nil:
+			page.AppName = app.Name
+		case errors.Is(err, auth.ErrInvalidUserCode):
+			page.Error = "The code is invalid or has expired."
+		default:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.renderDevice(w, http.StatusOK, page)
+}
+
+// deviceSubmit authenticates the user and approves or denies the user code.
+func (h *handler) deviceSubmit(w http.ResponseWriter, r *http.Request) {
+	page := devicePage{
+		UserCode: r.PostFormValue("user_code"),
+		Email:    r.PostFormValue("email"),
+	}
+	approve := r.PostFormValue("action") == "approve"
+	mfaCode := r.PostFormValue("mfa_code")
+
+	err := h.auth.VerifyDevice(page.UserCode, page.Email, r.PostFormValue("password"), mfaCode, approve, clientInfo(r))
+	if err != nil {
+		page.MFA = mfaCode != ""
+		switch {
+		case errors.Is(err, auth.ErrInvalidEmailOrPassword):
+			page.Error = "Invalid email or password."
+			h.renderDevice(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrMFARequired):
+			page.Error = "Enter the code from your authenticator app or a recovery code."
+			page.MFA = true
+			h.renderDevice(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			page.Error = "Invalid authentication code."
+			page.MFA = true
+			h.renderDevice(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrMFALocked):
+			page.Error = "Too many wrong codes, try again later."
+			page.MFA = true
+			h.renderDevice(w, http.StatusTooManyRequests, page)
+		case errors.Is(err, auth.ErrInvalidUserCode):
+			page.Error = "The code is invalid or has expired."
+			h.renderDevice(w, http.StatusBadRequest, page)
+		default:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page = devicePage{Done: "Access denied. You can close this page."}
+	if approve {
+		page.Done = "Device connected. You can return to your device."
+	}
+
+	h.renderDevice(w, http.StatusOK, page)
+}
+
+func (h *handler) renderDevice(w http.ResponseWriter, status int, page devicePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := deviceTemplate.Execute(w, page); err != nil {
+		h.log.Error("failed to render device page", sl.Err(err))
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"strings"
+	"time"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchange implements the token exchange grant (RFC 8693). Only access
+// tokens are accepted and issued. The actor is the admin of the actor token
+// or the authenticated client.
+func (h *handler) tokenExchange(w http.ResponseWriter, r *http.Request, clientID string, clientSecret string, basic bool) {
+	req := models.TokenExchangeRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		SubjectToken: r.PostFormValue("subject_token"),
+		ActorToken:   r.PostFormValue("actor_token"),
+		Audience:     r.PostFormValue("audience"),
+		Scopes:       strings.Fields(r.PostFormValue("scope")),
+	}
+
+	switch {
+	case req.SubjectToken == "" || r.PostFormValue("subject_token_type") != tokenTypeAccessToken:
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "subject_token with the access_token type is required",
+		})
+		return
+	case req.ActorToken != "" && r.PostFormValue("actor_token_type") != tokenTypeAccessToken:
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "actor_token must be an access_token",
+		})
+		return
+	case r.PostFormValue("requested_token_type") != "" && r.PostFormValue("requested_token_type") != tokenTypeAccessToken:
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "only access tokens can be requested",
+		})
+		return
+	}
+
+	// Public clients identify themselves without a secret, the actor token
+	// authenticates the request then.
+	if clientSecret == "" {
+		req.ClientID = ""
+	}
+
+	pair, err := h.auth.ExchangeToken(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			h.invalidClient(w, basic)
+		case errors.Is(err, auth.ErrInvalidSubjectToken):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_request", ErrorDescription: "invalid subject_token"})
+		case errors.Is(err, auth.ErrInvalidActorToken):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_request", ErrorDescription: "invalid actor_token"})
+		case errors.Is(err, auth.ErrPermissionDenied):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unauthorized_client"})
+		case errors.Is(err, auth.ErrInvalidTarget):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_target"})
+		case errors.Is(err, auth.ErrInvalidScope):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope"})
+		case errors.Is(err, auth.ErrScopeRequired):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope", ErrorDescription: "scope is required for another audience"})
+		default:
+			h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:     pair.AccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(pair.AccessExpiresAt).Seconds()),
+		Scope:           pair.Scope,
+		IssuedTokenType: tokenTypeAccessToken,
+	})
+}
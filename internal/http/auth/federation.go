@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+)
+
+// federatedLogin starts signing in with an identity provider for the
+// authorization request passed along from the login page.
+func (h *handler) federatedLogin(w http.ResponseWriter, r *http.Request) {
+	_, req, ok := h.authorizationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	authURL, err := h.auth.StartFederatedLogin(r.Context(), r.PathValue("provider"), req, r.FormValue("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnknownProvider):
+			redirectError(w, r, req.RedirectURI, "invalid_request", "unknown identity provider")
+		case errors.Is(err, auth.ErrFederationFailed):
+			redirectError(w, r, req.RedirectURI, "temporarily_unavailable", "identity provider is unavailable")
+		default:
+			redirectError(w, r, req.RedirectURI, "server_error", "")
+		}
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// federatedCallback receives the response of the identity provider and
+// completes the authorization request of the client with a code.
+func (h *handler) federatedCallback(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	if r.FormValue("error") != "" {
+		code = ""
+	}
+
+	state, authCode, err := h.auth.CompleteFederatedLogin(r.Context(), r.PathValue("provider"), r.FormValue("state"), code, clientInfo(r))
+	if err != nil {
+		if state.RedirectURI == "" {
+			if errors.Is(err, auth.ErrInvalidState) {
+				http.Error(w, "invalid or expired login, please start again", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		switch {
+		case errors.Is(err, auth.ErrAccessDenied):
+			federationError(w, r, state, "access_denied", "")
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			federationError(w, r, state, "access_denied", "email is not verified")
+		case errors.Is(err, auth.ErrUnverifiedEmail):
+			federationError(w, r, state, "access_denied", "the identity provider did not verify the email address")
+		case errors.Is(err, auth.ErrAccountNotVerified):
+			federationError(w, r, state, "access_denied", "verify the email of your account before signing in with the identity provider")
+		case errors.Is(err, auth.ErrMFARequired):
+			federationError(w, r, state, "access_denied", "the account requires a second factor, sign in with your password and authenticator code")
+		case errors.Is(err, auth.ErrFederationFailed), errors.Is(err, auth.ErrUnknownProvider):
+			federationError(w, r, state, "temporarily_unavailable", "identity provider login failed")
+		default:
+			federationError(w, r, state, "server_error", "")
+		}
+		return
+	}
+
+	redirectWithState(w, r, state.RedirectURI, state.ClientState, url.Values{"code": {authCode}})
+}
+
+// providerLinks link the login page to the identity providers, carrying
+// the authorization request along.
+func (h *handler) providerLinks(r *http.Request) []providerLink {
+	providers := h.auth.Providers()
+	if len(providers) == 0 {
+		return nil
+	}
+
+	query := url.Values{}
+	for name, value := range formParams(r) {
+		query.Set(name, value)
+	}
+
+	links := make([]providerLink, 0, len(providers))
+	for _, name := range providers {
+		links = append(links, providerLink{
+			Name: name,
+			URL:  "/login/" + url.PathEscape(name) + "?" + query.Encode(),
+		})
+	}
+
+	return links
+}
+
+// federationError reports an error to the client the login was started for.
+func federationError(w http.ResponseWriter, r *http.Request, state models.FederationState, code string, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+
+	redirectWithState(w, r, state.RedirectURI, state.ClientState, params)
+}
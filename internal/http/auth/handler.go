@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/services/auth"
+	"strings"
+)
+
+type Auth interface {
+	JWKS() jwt.JWKS
+	Introspect(req models.IntrospectionRequest) (models.Introspection, error)
+	AuthorizationApp(appID int32, redirectURI string) (models.App, error)
+	RequestedScopes(app models.App, scope string) ([]string, error)
+	Authorize(req models.AuthorizationRequest, email string, password string, mfaCode string, client models.ClientInfo) (string, error)
+	ExchangeCode(appID int32, code string, redirectURI string, codeVerifier string) (jwt.TokenPair, error)
+	Refresh(refreshToken string, appID int32) (jwt.TokenPair, error)
+	UserInfo(accessToken string) (models.User, error)
+	ClientCredentials(clientID string, clientSecret string, scopes []string) (jwt.TokenPair, error)
+	DeviceAuthorization(appID int32, scope string) (models.DeviceAuthorization, error)
+	DeviceApp(userCode string) (models.App, error)
+	VerifyDevice(userCode string, email string, password string, mfaCode string, approve bool, client models.ClientInfo) error
+	ExchangeDeviceCode(appID int32, deviceCode string, client models.ClientInfo) (jwt.TokenPair, error)
+	ExchangeToken(req models.TokenExchangeRequest) (jwt.TokenPair, error)
+	VerifyEmail(token string) error
+	ResetPassword(token string, newPassword string) error
+	BeginPasskeyRegistration(accessToken string) (string, error)
+	FinishPasskeyRegistration(accessToken string, credentialJSON string, name string) error
+	BeginPasskeyLogin(appID int32, scopes []string, mfaToken string) (string, error)
+	FinishPasskeyLogin(credentialJSON string, client models.ClientInfo) (jwt.TokenPair, error)
+	Providers() []string
+	StartFederatedLogin(ctx context.Context, provider string, req models.AuthorizationRequest, clientState string) (string, error)
+	CompleteFederatedLogin(ctx context.Context, provider string, state string, code string, client models.ClientInfo) (models.FederationState, string, error)
+}
+
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	UserID    int64    `json:"user_id,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	AppID     int32    `json:"app_id,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Act       *actor   `json:"act,omitempty"`
+}
+
+type actor struct {
+	Sub string `json:"sub"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type handler struct {
+	log    *slog.Logger
+	auth   Auth
+	issuer string
+}
+
+// Register adds the endpoints to the mux. The issuer is the public base URL
+// of the endpoints announced in the OpenID Connect discovery document.
+func Register(mux *http.ServeMux, log *slog.Logger, auth Auth, issuer string) {
+	h := &handler{log: log, auth: auth, issuer: strings.TrimSuffix(issuer, "/")}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+	mux.HandleFunc("POST /introspect", h.introspect)
+	mux.HandleFunc("GET /authorize", h.authorize)
+	mux.HandleFunc("POST /authorize", h.authorizeSubmit)
+	mux.HandleFunc("POST /token", h.token)
+	mux.HandleFunc("POST /device_authorization", h.deviceAuthorization)
+	mux.HandleFunc("GET /device", h.device)
+	mux.HandleFunc("POST /device", h.deviceSubmit)
+	mux.HandleFunc("GET /login/{provider}", h.federatedLogin)
+	mux.HandleFunc("GET /login/{provider}/callback", h.federatedCallback)
+	mux.HandleFunc("GET /verify_email", h.verifyEmail)
+	mux.HandleFunc("POST /verify_email", h.verifyEmailSubmit)
+	mux.HandleFunc("GET /reset_password", h.resetPassword)
+	mux.HandleFunc("POST /reset_password", h.resetPasswordSubmit)
+	mux.HandleFunc("POST /webauthn/register/begin", h.passkeyRegistrationBegin)
+	mux.HandleFunc("POST /webauthn/register/finish", h.passkeyRegistrationFinish)
+	mux.HandleFunc("POST /webauthn/login/begin", h.passkeyLoginBegin)
+	mux.HandleFunc("POST /webauthn/login/finish", h.passkeyLoginFinish)
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.openIDConfiguration)
+	mux.HandleFunc("GET /userinfo", h.userInfo)
+	mux.HandleFunc("POST /userinfo", h.userInfo)
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	h.writeJSON(w, http.StatusOK, h.auth.JWKS())
+}
+
+// introspect implements the RFC 7662 endpoint, the token is passed as the
+// "token" form parameter. Resource servers authenticate with their client
+// credentials or with a bearer token they got for themselves.
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	if token == "" {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "token is required",
+		})
+		return
+	}
+
+	req := models.IntrospectionRequest{Token: token}
+
+	basic := false
+	if accessToken, ok := bearerToken(r); ok {
+		req.AccessToken = accessToken
+	} else {
+		req.ClientID, req.ClientSecret, basic = clientCredentials(r)
+	}
+
+	info, err := h.auth.Introspect(req)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			h.invalidClient(w, basic)
+			return
+		}
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		return
+	}
+
+	if !info.Active {
+		h.writeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+		return
+	}
+
+	var act *actor
+	if info.Actor != "" {
+		act = &actor{Sub: info.Actor}
+	}
+
+	h.writeJSON(w, http.StatusOK, introspectionResponse{
+		Active:    true,
+		Sub:       info.Subject,
+		Aud:       info.Audience,
+		Iss:       info.Issuer,
+		TokenType: info.TokenType,
+		UserID:    info.UserID,
+		ClientID:  info.ClientID,
+		AppID:     info.AppID,
+		Exp:       info.ExpiresAt.Unix(),
+		Iat:       info.IssuedAt.Unix(),
+		Jti:       info.TokenID,
+		Scope:     strings.Join(info.Scopes, " "),
+		Roles:     info.Roles,
+		Act:       act,
+	})
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.log.Error("failed to write response", sl.Err(err))
+	}
+}
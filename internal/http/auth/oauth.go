@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/services/auth"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is only set for the token exchange grant.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+type loginPage struct {
+	AppName string
+	Email   string
+	Error   string
+	// MFA asks for the code of the authenticator app along with the
+	// password.
+	MFA bool
+	// Scopes are the scopes the user consents to by signing in.
+	Scopes []string
+	// Params are the authorization request parameters the form posts back.
+	Params map[string]string
+	// Providers link to the identity providers the user can sign in with
+	// instead.
+	Providers []providerLink
+}
+
+type providerLink struct {
+	Name string
+	URL  string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in to {{.AppName}}</title>
+</head>
+<body>
+<h1>Sign in to {{.AppName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}{{if .Scopes}}<p>{{.AppName}} requests access to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}<form method="post" action="/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+<label>Password <input type="password" name="password" required></label>
+{{if .MFA}}<label>Authentication or recovery code <input type="text" name="mfa_code" autocomplete="one-time-code" required></label>
+{{end}}<button type="submit">Sign in</button>
+{{if .Scopes}}<button type="submit" name="deny" value="1" formnovalidate>Deny</button>
+{{end}}</form>
+{{range .Providers}}<p><a href="{{.URL}}">Sign in with {{.Name}}</a></p>
+{{end}}</body>
+</html>
+`))
+
+// authorizationParams are forwarded from the authorization request to the
+// login form.
+var authorizationParams = []string{
+	"response_type",
+	"client_id",
+	"redirect_uri",
+	"code_challenge",
+	"code_challenge_method",
+	"scope",
+	"nonce",
+	"state",
+}
+
+// authorize shows the login form of the authorization code flow (RFC 6749
+// section 4.1, with PKCE required).
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	app, req, ok := h.authorizationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	h.renderLogin(w, http.StatusOK, loginPage{
+		AppName:   app.Name,
+		Scopes:    consentScopes(req.Scope),
+		Params:    formParams(r),
+		Providers: h.providerLinks(r),
+	})
+}
+
+// authorizeSubmit authenticates the user and redirects back to the client
+// with an authorization code. Signing in grants the requested scopes.
+func (h *handler) authorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	app, req, ok := h.authorizationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if r.PostFormValue("deny") != "" {
+		redirectError(w, r, req.RedirectURI, "access_denied", "the user denied the request")
+		return
+	}
+
+	email := r.PostFormValue("email")
+
+	mfaCode := r.PostFormValue("mfa_code")
+
+	code, err := h.auth.Authorize(req, email, r.PostFormValue("password"), mfaCode, clientInfo(r))
+	if err != nil {
+		page := loginPage{
+			AppName:   app.Name,
+			Email:     email,
+			MFA:       mfaCode != "",
+			Scopes:    consentScopes(req.Scope),
+			Params:    formParams(r),
+			Providers: h.providerLinks(r),
+		}
+		switch {
+		case errors.Is(err, auth.ErrInvalidEmailOrPassword):
+			page.Error = "Invalid email or password."
+			h.renderLogin(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			page.Error = "Verify your email address before signing in, the link was sent to you when you registered."
+			h.renderLogin(w, http.StatusForbidden, page)
+		case errors.Is(err, auth.ErrMFARequired):
+			page.Error = "Enter the code from your authenticator app or a recovery code."
+			page.MFA = true
+			h.renderLogin(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			page.Error = "Invalid authentication code."
+			page.MFA = true
+			h.renderLogin(w, http.StatusUnauthorized, page)
+		case errors.Is(err, auth.ErrMFALocked):
+			page.Error = "Too many wrong codes, try again later."
+			page.MFA = true
+			h.renderLogin(w, http.StatusTooManyRequests, page)
+		default:
+			redirectError(w, r, req.RedirectURI, "server_error", "")
+		}
+		return
+	}
+
+	redirect(w, r, req.RedirectURI, url.Values{"code": {code}})
+}
+
+// authorizationRequest validates the authorization request parameters.
+// Until the client and redirect URI are known to be valid, errors are shown
+// to the user instead of being redirected to a possibly malicious URI.
+func (h *handler) authorizationRequest(w http.ResponseWriter, r *http.Request) (models.App, models.AuthorizationRequest, bool) {
+	appID, ok := parseClientID(r.FormValue("client_id"))
+	if !ok {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return models.App{}, models.AuthorizationRequest{}, false
+	}
+
+	redirectURI := r.FormValue("redirect_uri")
+
+	app, err := h.auth.AuthorizationApp(appID, redirectURI)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAppID):
+			http.Error(w, "invalid client_id", http.StatusBadRequest)
+		case errors.Is(err, auth.ErrInvalidRedirectURI):
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		default:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return models.App{}, models.AuthorizationRequest{}, false
+	}
+
+	if r.FormValue("response_type") != "code" {
+		redirectError(w, r, redirectURI, "unsupported_response_type", "only the code response type is supported")
+		return models.App{}, models.AuthorizationRequest{}, false
+	}
+
+	req := models.AuthorizationRequest{
+		AppID:               appID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Scope:               r.FormValue("scope"),
+		Nonce:               r.FormValue("nonce"),
+	}
+	if !oauth.ValidCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod) {
+		redirectError(w, r, redirectURI, "invalid_request", "code_challenge with the S256 method is required")
+		return models.App{}, models.AuthorizationRequest{}, false
+	}
+
+	scopes, err := h.auth.RequestedScopes(app, req.Scope)
+	if err != nil {
+		redirectError(w, r, redirectURI, "invalid_scope", "")
+		return models.App{}, models.AuthorizationRequest{}, false
+	}
+	req.Scope = strings.Join(scopes, " ")
+
+	return app, req, true
+}
+
+// token implements the token endpoint for the authorization_code,
+// refresh_token, client_credentials, device_code and token exchange grants.
+// Public clients are identified by client_id, confidential ones authenticate
+// with HTTP Basic or the client_secret form parameter.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	clientID, clientSecret, basic := clientCredentials(r)
+
+	if r.PostFormValue("grant_type") == "client_credentials" {
+		if clientID == "" || clientSecret == "" {
+			h.invalidClient(w, basic)
+			return
+		}
+
+		pair, err := h.auth.ClientCredentials(clientID, clientSecret, strings.Fields(r.PostFormValue("scope")))
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidClient):
+				h.invalidClient(w, basic)
+			case errors.Is(err, auth.ErrInvalidScope):
+				h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope"})
+			default:
+				h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+			}
+			return
+		}
+
+		h.writeToken(w, pair)
+		return
+	}
+
+	if r.PostFormValue("grant_type") == grantTypeTokenExchange {
+		h.tokenExchange(w, r, clientID, clientSecret, basic)
+		return
+	}
+
+	appID, ok := parseClientID(clientID)
+	if !ok {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "client_id is invalid",
+		})
+		return
+	}
+
+	var (
+		pair jwt.TokenPair
+		err  error
+	)
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		code := r.PostFormValue("code")
+		redirectURI := r.PostFormValue("redirect_uri")
+		verifier := r.PostFormValue("code_verifier")
+		if code == "" || redirectURI == "" || verifier == "" {
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "code, redirect_uri and code_verifier are required",
+			})
+			return
+		}
+
+		pair, err = h.auth.ExchangeCode(appID, code, redirectURI, verifier)
+	case "refresh_token":
+		refreshToken := r.PostFormValue("refresh_token")
+		if refreshToken == "" {
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "refresh_token is required",
+			})
+			return
+		}
+
+		pair, err = h.auth.Refresh(refreshToken, appID)
+	case grantTypeDeviceCode:
+		deviceCode := r.PostFormValue("device_code")
+		if deviceCode == "" {
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "device_code is required",
+			})
+			return
+		}
+
+		pair, err = h.auth.ExchangeDeviceCode(appID, deviceCode, clientInfo(r))
+	case "":
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "grant_type is required",
+		})
+		return
+	default:
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unsupported_grant_type"})
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidGrant),
+			errors.Is(err, auth.ErrInvalidRefreshToken),
+			errors.Is(err, auth.ErrRefreshTokenReused):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_grant"})
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_grant", ErrorDescription: "email is not verified"})
+		case errors.Is(err, auth.ErrAuthorizationPending):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "authorization_pending"})
+		case errors.Is(err, auth.ErrSlowDown):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "slow_down"})
+		case errors.Is(err, auth.ErrAccessDenied):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "access_denied"})
+		case errors.Is(err, auth.ErrExpiredToken):
+			h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expired_token"})
+		case errors.Is(err, auth.ErrInvalidAppID):
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_client"})
+		default:
+			h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		}
+		return
+	}
+
+	h.writeToken(w, pair)
+}
+
+func (h *handler) writeToken(w http.ResponseWriter, pair jwt.TokenPair) {
+	h.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(pair.AccessExpiresAt).Seconds()),
+		RefreshToken: pair.RefreshToken,
+		IDToken:      pair.IDToken,
+		Scope:        pair.Scope,
+	})
+}
+
+func (h *handler) invalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+
+	h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_client"})
+}
+
+// clientCredentials reads the client credentials from the Authorization
+// header, where both parts are form encoded (RFC 6749 section 2.3.1), or
+// from the form. It also reports whether HTTP Basic was used.
+func clientCredentials(r *http.Request) (string, string, bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+
+		return id, secret, true
+	}
+
+	return r.PostFormValue("client_id"), r.PostFormValue("client_secret"), false
+}
+
+func (h *handler) renderLogin(w http.ResponseWriter, status int, page loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := loginTemplate.Execute(w, page); err != nil {
+		h.log.Error("failed to render login page", sl.Err(err))
+	}
+}
+
+// parseClientID maps the OAuth client_id to the app id.
+func parseClientID(clientID string) (int32, bool) {
+	id, err := strconv.ParseInt(clientID, 10, 32)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+
+	return int32(id), true
+}
+
+// consentScopes are the scopes listed on the login page. Signing in itself
+// is implied, so openid isn't listed.
+func consentScopes(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+func formParams(r *http.Request) map[string]string {
+	params := make(map[string]string, len(authorizationParams))
+	for _, name := range authorizationParams {
+		if value := r.FormValue(name); value != "" {
+			params[name] = value
+		}
+	}
+
+	return params
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI string, code string, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+
+	redirect(w, r, redirectURI, params)
+}
+
+// redirect sends the authorization response to the client, echoing the
+// state of the request.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	redirectWithState(w, r, redirectURI, r.FormValue("state"), params)
+}
+
+func redirectWithState(w http.ResponseWriter, r *http.Request, redirectURI string, state string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func clientInfo(r *http.Request) models.ClientInfo {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	return models.ClientInfo{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+}
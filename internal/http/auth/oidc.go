@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/oauth"
+	"sso/internal/services/auth"
+	"strconv"
+	"strings"
+)
+
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	IntrospectionEndpointAuthMethods  []string `json:"introspection_endpoint_auth_methods_supported"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type userInfoResponse struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email,omitempty"`
+}
+
+// openIDConfiguration serves the OpenID Connect discovery document.
+func (h *handler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	h.writeJSON(w, http.StatusOK, openIDConfiguration{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + "/authorize",
+		TokenEndpoint:                     h.issuer + "/token",
+		UserInfoEndpoint:                  h.issuer + "/userinfo",
+		JWKSURI:                           h.issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             h.issuer + "/introspect",
+		IntrospectionEndpointAuthMethods:  []string{"client_secret_basic", "client_secret_post"},
+		DeviceAuthorizationEndpoint:       h.issuer + "/device_authorization",
+		ScopesSupported:                   []string{"openid"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", grantTypeDeviceCode, grantTypeTokenExchange},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.AlgRS256, jwt.AlgES256, jwt.AlgEdDSA, "HS256"},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{oauth.MethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email"},
+	})
+}
+
+// userInfo implements the OpenID Connect userinfo endpoint, authenticated
+// with the access token as described in RFC 6750.
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_request"})
+		return
+	}
+
+	user, err := h.auth.UserInfo(token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_token"})
+			return
+		}
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, userInfoResponse{
+		Sub:   strconv.FormatInt(user.ID, 10),
+		Email: user.Email,
+	})
+}
+
+// bearerToken extracts the access token from the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+
+	return token, token != ""
+}
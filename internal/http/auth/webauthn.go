@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/services/auth"
+	"strings"
+)
+
+// maxCredentialSize limits the PublicKeyCredential JSON read from requests.
+const maxCredentialSize = 64 << 10
+
+// passkeyRegistrationBegin returns the options for
+// navigator.credentials.create(), authenticated with the access token like
+// userinfo.
+func (h *handler) passkeyRegistrationBegin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_request"})
+		return
+	}
+
+	options, err := h.auth.BeginPasskeyRegistration(token)
+	if err != nil {
+		h.passkeyError(w, err)
+		return
+	}
+
+	h.writeOptions(w, options)
+}
+
+// passkeyRegistrationFinish stores the passkey. The body is the
+// PublicKeyCredential serialized with toJSON(), the name query parameter
+// labels the passkey.
+func (h *handler) passkeyRegistrationFinish(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_request"})
+		return
+	}
+
+	credential, ok := h.readCredential(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.FinishPasskeyRegistration(token, credential, r.URL.Query().Get("name")); err != nil {
+		h.passkeyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passkeyLoginBegin returns the options for navigator.credentials.get().
+// Without an mfa_token it starts a passwordless login to the client_id app
+// with the scope.
+func (h *handler) passkeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	mfaToken := r.PostFormValue("mfa_token")
+
+	var appID int32
+	if mfaToken == "" {
+		id, ok := parseClientID(r.PostFormValue("client_id"))
+		if !ok {
+			h.writeJSON(w, http.StatusUnauthorized, errorResponse{
+				Error:            "invalid_client",
+				ErrorDescription: "client_id is invalid",
+			})
+			return
+		}
+		appID = id
+	}
+
+	options, err := h.auth.BeginPasskeyLogin(appID, strings.Fields(r.PostFormValue("scope")), mfaToken)
+	if err != nil {
+		h.passkeyError(w, err)
+		return
+	}
+
+	h.writeOptions(w, options)
+}
+
+// passkeyLoginFinish verifies the assertion, the body is the
+// PublicKeyCredential serialized with toJSON(), and responds like the token
+// endpoint.
+func (h *handler) passkeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	credential, ok := h.readCredential(w, r)
+	if !ok {
+		return
+	}
+
+	pair, err := h.auth.FinishPasskeyLogin(credential, clientInfo(r))
+	if err != nil {
+		h.passkeyError(w, err)
+		return
+	}
+
+	h.writeToken(w, pair)
+}
+
+func (h *handler) readCredential(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCredentialSize))
+	if err != nil || len(body) == 0 {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "credential is required",
+		})
+		return "", false
+	}
+
+	return string(body), true
+}
+
+func (h *handler) writeOptions(w http.ResponseWriter, options string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.WriteString(w, options); err != nil {
+		h.log.Error("failed to write response", sl.Err(err))
+	}
+}
+
+func (h *handler) passkeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccessToken):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_token"})
+	case errors.Is(err, auth.ErrInvalidAppID):
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_client"})
+	case errors.Is(err, auth.ErrInvalidScope):
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_scope"})
+	case errors.Is(err, auth.ErrInvalidPasskey):
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_grant", ErrorDescription: "invalid passkey"})
+	case errors.Is(err, auth.ErrInvalidMFAToken):
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_grant", ErrorDescription: "invalid or expired mfa token"})
+	case errors.Is(err, auth.ErrPasskeyExists):
+		h.writeJSON(w, http.StatusConflict, errorResponse{Error: "invalid_request", ErrorDescription: "passkey already registered"})
+	case errors.Is(err, auth.ErrPasskeysUnavailable):
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_request", ErrorDescription: "passkeys are not available for the app"})
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid_request", ErrorDescription: "no passkeys registered"})
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		h.writeJSON(w, http.StatusForbidden, errorResponse{Error: "access_denied", ErrorDescription: "email is not verified"})
+	default:
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+	}
+}
@@ -0,0 +1,335 @@
+package federation
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// requestTimeout bounds every call to a provider.
+const requestTimeout = 10 * time.Second
+
+// maxResponseSize bounds the provider responses read into memory.
+const maxResponseSize = 1 << 20
+
+var (
+	ErrDiscovery     = errors.New("provider discovery failed")
+	ErrExchange      = errors.New("code exchange failed")
+	ErrInvalidToken  = errors.New("invalid id token")
+	ErrNoSubject     = errors.New("provider returned no subject")
+	ErrNoUserInfoURL = errors.New("provider returned no id token and has no userinfo endpoint")
+)
+
+// Endpoints of a provider. Empty endpoints are discovered from the OpenID
+// Connect configuration of the issuer.
+type Endpoints struct {
+	Authorization string
+	Token         string
+	UserInfo      string
+}
+
+// Provider is an upstream OpenID Connect or OAuth 2.0 identity provider
+// users can sign in with. Plain OAuth 2.0 providers must configure their
+// endpoints and serve OpenID Connect claims from the userinfo endpoint.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RedirectURL is the callback registered at the provider.
+	RedirectURL string
+	Endpoints   Endpoints
+	// TrustEmail treats the emails of the provider as verified even without
+	// the email_verified claim, e.g. for a corporate identity provider.
+	TrustEmail bool
+
+	mu         sync.Mutex
+	discovered *Endpoints
+}
+
+// Identity is the account of a user at a provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+type userInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+}
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// AuthCodeURL returns the authorization endpoint URL the user is redirected
+// to. The state and nonce are checked on the way back, the code challenge
+// binds the code to the verifier passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(endpoints.Authorization)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns
+// the identity of the user, taken from the ID token or, if there is none
+// or it has no email, from the userinfo endpoint.
+//
+// The ID token is received directly from the token endpoint over TLS, so
+// its signature isn't checked (OpenID Connect Core section 3.1.3.7), but the
+// issuer, audience, expiry and nonce are.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (Identity, error) {
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	tokens, err := p.exchange(ctx, endpoints.Token, code, codeVerifier)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var identity Identity
+	if tokens.IDToken != "" {
+		identity, err = p.parseIDToken(tokens.IDToken, nonce)
+		if err != nil {
+			return Identity{}, err
+		}
+	}
+
+	if identity.Email == "" {
+		if endpoints.UserInfo == "" {
+			if identity.Subject == "" {
+				return Identity{}, ErrNoUserInfoURL
+			}
+			return identity, nil
+		}
+
+		info, err := p.userInfo(ctx, endpoints.UserInfo, tokens.AccessToken)
+		if err != nil {
+			return Identity{}, err
+		}
+
+		// The userinfo response must be about the user of the ID token.
+		if identity.Subject != "" && info.Subject != identity.Subject {
+			return Identity{}, fmt.Errorf("%w: userinfo subject mismatch", ErrExchange)
+		}
+		identity = info
+	}
+
+	if identity.Subject == "" {
+		return Identity{}, ErrNoSubject
+	}
+
+	if p.TrustEmail && identity.Email != "" {
+		identity.EmailVerified = true
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) exchange(ctx context.Context, tokenURL string, code string, codeVerifier string) (tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// Both parts are form encoded first (RFC 6749 section 2.3.1).
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	var tokens tokenResponse
+	status, err := doJSON(req, &tokens)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	if status != http.StatusOK || tokens.Error != "" {
+		return tokenResponse{}, fmt.Errorf("%w: status %d: %s %s", ErrExchange, status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	return tokens, nil
+}
+
+func (p *Provider) parseIDToken(idToken string, nonce string) (Identity, error) {
+	var claims idTokenClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, &claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	switch {
+	case p.Issuer != "" && claims.Issuer != p.Issuer:
+		return Identity{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return Identity{}, fmt.Errorf("%w: not issued for the client", ErrInvalidToken)
+	case claims.ExpiresAt == nil || !time.Now().Before(claims.ExpiresAt.Time):
+		return Identity{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	return Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified(claims.EmailVerified),
+	}, nil
+}
+
+func (p *Provider) userInfo(ctx context.Context, userInfoURL string, accessToken string) (Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userInfoURL, nil)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var info userInfo
+	status, err := doJSON(req, &info)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	if status != http.StatusOK {
+		return Identity{}, fmt.Errorf("%w: userinfo status %d", ErrExchange, status)
+	}
+
+	return Identity{
+		Subject:       info.Subject,
+		Email:         info.Email,
+		EmailVerified: verified(info.EmailVerified),
+	}, nil
+}
+
+// endpoints returns the configured endpoints, completed from the discovery
+// document of the issuer. A successful discovery is cached.
+func (p *Provider) endpoints(ctx context.Context) (Endpoints, error) {
+	if p.Endpoints.Authorization != "" && p.Endpoints.Token != "" {
+		return p.Endpoints, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered != nil {
+		return *p.discovered, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return Endpoints{}, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+
+	var doc discoveryDocument
+	status, err := doJSON(req, &doc)
+	if err != nil {
+		return Endpoints{}, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	if status != http.StatusOK {
+		return Endpoints{}, fmt.Errorf("%w: status %d", ErrDiscovery, status)
+	}
+	if doc.Issuer != p.Issuer {
+		return Endpoints{}, fmt.Errorf("%w: unexpected issuer %q", ErrDiscovery, doc.Issuer)
+	}
+
+	endpoints := Endpoints{
+		Authorization: cmp.Or(p.Endpoints.Authorization, doc.AuthorizationEndpoint),
+		Token:         cmp.Or(p.Endpoints.Token, doc.TokenEndpoint),
+		UserInfo:      cmp.Or(p.Endpoints.UserInfo, doc.UserInfoEndpoint),
+	}
+	if endpoints.Authorization == "" || endpoints.Token == "" {
+		return Endpoints{}, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+
+	p.discovered = &endpoints
+
+	return endpoints, nil
+}
+
+func doJSON(req *http.Request, v any) (int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// verified reads the email_verified claim, which some providers send as a
+// string.
+func verified(claim any) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,23 @@
+package jwt
+
+import "encoding/json"
+
+// MarshalJSON adds the custom claims of the app next to the standard ones.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	type claims Claims
+
+	encoded, err := json.Marshal(claims(c))
+	if err != nil || len(c.Custom) == 0 {
+		return encoded, err
+	}
+
+	merged := make(map[string]any, len(c.Custom))
+	for name, value := range c.Custom {
+		merged[name] = value
+	}
+	if err := json.Unmarshal(encoded, &merged); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merged)
+}
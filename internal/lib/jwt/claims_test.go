@@ -0,0 +1,80 @@
+package jwt_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+)
+
+func TestNewTokenPair_MergesClaimsTemplate(t *testing.T) {
+	issuer := jwt.Issuer{Name: issuerName, Keys: jwt.NewKeySet()}
+
+	templated := app
+	templated.ClaimsTemplate = models.ClaimsTemplate{
+		User:   map[string]string{"email": "email", "admin": "is_admin", "roles": "roles"},
+		Static: map[string]any{"tenant": "acme", "tier": float64(2)},
+	}
+
+	pair, err := jwt.NewTokenPair(issuer, user, []string{"admin", "editor"}, templated, time.Minute, time.Hour, jwt.Session{AuthTime: time.Now()})
+	require.NoError(t, err)
+
+	claims := gojwt.MapClaims{}
+	_, _, err = gojwt.NewParser().ParseUnverified(pair.AccessToken, claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, user.Email, claims["email"])
+	assert.Equal(t, true, claims["admin"])
+	assert.Equal(t, []any{"admin", "editor"}, claims["roles"])
+	assert.Equal(t, "acme", claims["tenant"])
+	assert.Equal(t, float64(2), claims["tier"])
+
+	// The standard claims are kept next to the custom ones.
+	assert.Equal(t, issuerName, claims["iss"])
+	assert.Equal(t, "42", claims["sub"])
+	assert.Equal(t, float64(user.ID), claims["user_id"])
+
+	// Refresh tokens don't carry the custom claims.
+	refreshClaims := gojwt.MapClaims{}
+	_, _, err = gojwt.NewParser().ParseUnverified(pair.RefreshToken, refreshClaims)
+	require.NoError(t, err)
+	assert.NotContains(t, refreshClaims, "tenant")
+
+	_, err = jwt.ValidateToken(issuer, templated, pair.AccessToken, false, nil)
+	require.NoError(t, err)
+
+	// Without roles in the app the user isn't an admin of it.
+	pair, err = jwt.NewTokenPair(issuer, user, nil, templated, time.Minute, time.Hour, jwt.Session{AuthTime: time.Now()})
+	require.NoError(t, err)
+
+	claims = gojwt.MapClaims{}
+	_, _, err = gojwt.NewParser().ParseUnverified(pair.AccessToken, claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, false, claims["admin"])
+	assert.Equal(t, []any{}, claims["roles"])
+}
+
+func TestNewTokenPair_RefusesOversizedClaims(t *testing.T) {
+	issuer := jwt.Issuer{Name: issuerName, Keys: jwt.NewKeySet()}
+
+	templated := app
+	templated.ClaimsTemplate = models.ClaimsTemplate{
+		User:   map[string]string{"email": "email"},
+		Static: map[string]any{"blob": strings.Repeat("a", models.MaxCustomClaimsSize-40)},
+	}
+	require.NoError(t, templated.ClaimsTemplate.Validate())
+
+	// The size depends on the user attributes, so a long email can push the
+	// rendered claims over the limit.
+	long := user
+	long.Email = strings.Repeat("a", 64) + "@sso.test"
+
+	_, err := jwt.NewTokenPair(issuer, long, nil, templated, time.Minute, time.Hour, jwt.Session{AuthTime: time.Now()})
+	assert.ErrorIs(t, err, models.ErrInvalidClaimsTemplate)
+}
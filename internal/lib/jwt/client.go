@@ -0,0 +1,64 @@
+package jwt
+
+import (
+	"sso/internal/domain/models"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clientSubjectPrefix keeps client subjects apart from user ids.
+const clientSubjectPrefix = "client:"
+
+// ClientID is the OAuth client_id of the app.
+func ClientID(app models.App) string {
+	return Audience(app)
+}
+
+// ClientSubject is the sub claim of tokens the app got for itself rather
+// than for one of its users.
+func ClientSubject(app models.App) string {
+	return clientSubjectPrefix + ClientID(app)
+}
+
+// NewClientToken issues an access token for the app acting on its own
+// behalf, as in the client credentials grant. The token has no user and
+// no refresh token is issued.
+func NewClientToken(issuer Issuer, app models.App, ttl time.Duration, scopes []string) (TokenPair, error) {
+	now := time.Now()
+
+	id, err := NewTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	expiresAt := now.Add(ttl)
+	scope := strings.Join(scopes, " ")
+
+	claims := &Claims{
+		AppID:    app.ID,
+		ClientID: ClientID(app),
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer.Name,
+			Subject:   ClientSubject(app),
+			Audience:  jwt.ClaimStrings{Audience(app)},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        id,
+		},
+	}
+
+	accessToken, err := signAccessToken(issuer.Keys, app, claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:     accessToken,
+		AccessExpiresAt: expiresAt,
+		Scope:           scope,
+	}, nil
+}
@@ -0,0 +1,92 @@
+package jwt
+
+import (
+	"sso/internal/domain/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Actor is the act claim of RFC 8693. A token exchanged again keeps the
+// previous actors nested in Act.
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
+// UserActor is the actor of a user acting on behalf of someone else.
+func UserActor(userID int64) Actor {
+	return Actor{Subject: strconv.FormatInt(userID, 10)}
+}
+
+// ClientActor is the actor of an app acting on behalf of someone else.
+func ClientActor(app models.App) Actor {
+	return Actor{Subject: ClientSubject(app)}
+}
+
+// NewExchangedToken issues an access token for the app with the subject,
+// session and client of the subject token and the actor added on top of
+// the actors of the subject token. The user is the subject of user tokens
+// and fills the claims template of the app together with the roles the user
+// holds in it. No refresh token is issued.
+func NewExchangedToken(
+	issuer Issuer,
+	app models.App,
+	subject *Claims,
+	user models.User,
+	roles []string,
+	actor Actor,
+	scopes []string,
+	expiresAt time.Time,
+) (TokenPair, error) {
+	now := time.Now()
+
+	id, err := NewTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	var custom map[string]any
+	if subject.UserID != 0 {
+		custom, err = app.ClaimsTemplate.Render(user, roles)
+		if err != nil {
+			return TokenPair{}, err
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	actor.Act = subject.Act
+
+	claims := &Claims{
+		UserID:   subject.UserID,
+		AppID:    app.ID,
+		FamilyID: subject.FamilyID,
+		AuthTime: subject.AuthTime,
+		ClientID: subject.ClientID,
+		Scope:    scope,
+		Act:      &actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer.Name,
+			Subject:   subject.subject(),
+			Audience:  jwt.ClaimStrings{Audience(app)},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        id,
+		},
+		Custom: custom,
+	}
+
+	accessToken, err := signAccessToken(issuer.Keys, app, claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:     accessToken,
+		AccessExpiresAt: expiresAt,
+		Scope:           scope,
+	}, nil
+}
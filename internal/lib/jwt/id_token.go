@@ -0,0 +1,61 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"sso/internal/domain/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims are the claims of an OpenID Connect ID token.
+type IDTokenClaims struct {
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	Nonce    string           `json:"nonce,omitempty"`
+	AtHash   string           `json:"at_hash,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewIDToken issues an OpenID Connect ID token for the session. It is signed
+// like access tokens, so clients verify it with the published JWKS, and it
+// is bound to the access token issued along with it by at_hash.
+func NewIDToken(issuer Issuer, user models.User, app models.App, ttl time.Duration, session Session, nonce string, accessToken string) (string, error) {
+	now := time.Now()
+
+	id, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	method, kid, key := appSigner(issuer.Keys, app)
+
+	claims := &IDTokenClaims{
+		AuthTime:         jwt.NewNumericDate(session.AuthTime),
+		Nonce:            nonce,
+		AtHash:           tokenHash(method.Alg(), accessToken),
+		RegisteredClaims: registeredClaims(issuer, user, app, id, now, session.expiry(now.Add(ttl))),
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	return token.SignedString(key)
+}
+
+// tokenHash is the at_hash value: the left half of the hash of the token,
+// using the hash function of the signing algorithm (SHA-512 for Ed25519).
+func tokenHash(alg string, token string) string {
+	var h hash.Hash
+	if alg == AlgEdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -1,70 +1,319 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"sso/internal/domain/models"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	ErrTokenRevoked          = errors.New("token is revoked")
+	ErrRevocationCheckFailed = errors.New("failed to check token revocation")
+)
+
+// RevocationChecker reports whether a token was revoked before it expired,
+// either directly by one of its ids (jti, token family) or because all
+// tokens of the user issued before some moment were revoked.
+type RevocationChecker interface {
+	IsRevoked(ids []string, userID int64, issuedAt time.Time) (bool, error)
+}
+
+// Issuer is this service as seen in tokens: Name goes to the iss claim and
+// Keys are the asymmetric keys access tokens are signed with.
+type Issuer struct {
+	Name string
+	Keys *KeySet
+}
+
 type TokenPair struct {
 	AccessToken  string
 	RefreshToken string
+
+	// IDToken is only issued when the client requested the openid scope.
+	IDToken string
+	// Scope is the space separated list of scopes granted to the access
+	// token.
+	Scope string
+
+	// AccessExpiresAt, RefreshID and RefreshExpiresAt describe the issued
+	// tokens so that they can be tracked in storage.
+	AccessExpiresAt  time.Time
+	RefreshID        string
+	RefreshExpiresAt time.Time
+}
+
+// Session describes the login a token pair belongs to.
+type Session struct {
+	// FamilyID groups the refresh tokens rotated from the same login.
+	FamilyID string
+	// AuthTime is the moment the user authenticated.
+	AuthTime time.Time
+	// ExpiresAt caps the expiry of every token of the session, the zero
+	// value means there is no cap.
+	ExpiresAt time.Time
+	// Scope is the space separated list of scopes the user granted at
+	// login. Tokens without scopes grant full access to the app.
+	Scope string
 }
 
 type Claims struct {
-	UserID int64 `json:"user_id"`
-	AppID  int32 `json:"app_id"`
+	UserID   int64            `json:"user_id"`
+	AppID    int32            `json:"app_id"`
+	FamilyID string           `json:"fid,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// ClientID is set instead of UserID on tokens an app got for itself.
+	ClientID string `json:"client_id,omitempty"`
+	// Scope is the space separated list of scopes granted to the token.
+	Scope string `json:"scope,omitempty"`
+	// Act identifies who acts on behalf of the subject of an exchanged
+	// token.
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
+
+	// Custom holds the claims rendered from the app claims template.
+	Custom map[string]any `json:"-"`
 }
 
-func NewTokenPair(user models.User, app models.App, accessTTL, refreshTTL time.Duration) (TokenPair, error) {
+// NewTokenPair signs the access token with the app's asymmetric key from
+// issuer keys, falling back to HS256 with the app secret. Refresh tokens are
+// only ever verified by this service and are always signed with the app's
+// refresh secret. A zero refreshTTL issues no refresh token. Roles are the
+// names of the roles the user holds in the app, used by its claims template.
+func NewTokenPair(issuer Issuer, user models.User, roles []string, app models.App, accessTTL, refreshTTL time.Duration, session Session) (TokenPair, error) {
 	now := time.Now()
 
+	accessID, err := NewTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	custom, err := app.ClaimsTemplate.Render(user, roles)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	accessExpiresAt := session.expiry(now.Add(accessTTL))
+
 	accessClaims := &Claims{
-		UserID: user.ID,
-		AppID:  app.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
-		},
+		UserID:           user.ID,
+		AppID:            app.ID,
+		FamilyID:         session.FamilyID,
+		AuthTime:         jwt.NewNumericDate(session.AuthTime),
+		Scope:            session.Scope,
+		RegisteredClaims: registeredClaims(issuer, user, app, accessID, now, accessExpiresAt),
+		Custom:           custom,
 	}
-	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims).SignedString(app.Secret)
+	accessToken, err := signAccessToken(issuer.Keys, app, accessClaims)
 	if err != nil {
 		return TokenPair{}, err
 	}
 
+	if refreshTTL <= 0 {
+		return TokenPair{AccessToken: accessToken, Scope: session.Scope, AccessExpiresAt: accessExpiresAt}, nil
+	}
+
+	refreshID, err := NewTokenID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshExpiresAt := session.expiry(now.Add(refreshTTL))
+
 	refreshClaims := &Claims{
-		UserID: user.ID,
-		AppID:  app.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(refreshTTL)),
-		},
+		UserID:           user.ID,
+		AppID:            app.ID,
+		FamilyID:         session.FamilyID,
+		AuthTime:         jwt.NewNumericDate(session.AuthTime),
+		Scope:            session.Scope,
+		RegisteredClaims: registeredClaims(issuer, user, app, refreshID, now, refreshExpiresAt),
 	}
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString(app.RefreshSecret)
+	refresh := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
+	refresh.Header["kid"] = refreshKeyID(app)
+	refreshToken, err := refresh.SignedString([]byte(app.RefreshSecret))
 	if err != nil {
 		return TokenPair{}, err
 	}
 
-	return TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+	return TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		Scope:            session.Scope,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshID:        refreshID,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
 }
 
-func ValidateToken(app models.App, tokenStr string, isRefresh bool) (*Claims, error) {
-	key := app.Secret
-	if isRefresh {
-		key = app.RefreshSecret
+// ValidateToken verifies the token signature, expiry, issuer and audience.
+// The verification key is picked by the kid header. If revocations is not
+// nil, revoked tokens are rejected with ErrTokenRevoked.
+func ValidateToken(issuer Issuer, app models.App, tokenStr string, isRefresh bool, revocations RevocationChecker) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithAudience(Audience(app)),
+		jwt.WithIssuedAt(),
+	}
+	if issuer.Name != "" {
+		opts = append(opts, jwt.WithIssuer(issuer.Name))
 	}
 
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return key, nil
-	})
+		return verificationKey(issuer.Keys, app, token, isRefresh)
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
+	if !ok || !token.Valid || claims.Subject != claims.subject() {
 		return nil, jwt.ErrTokenMalformed
 	}
 
+	if revocations != nil {
+		var ids []string
+		for _, id := range []string{claims.ID, claims.FamilyID} {
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+
+		revoked, err := revocations.IsRevoked(ids, claims.UserID, issuedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRevocationCheckFailed, err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
 	return claims, nil
 }
+
+// subject is the sub claim the token must carry, so that a token can't pass
+// for a user token and a client token at the same time.
+func (c *Claims) subject() string {
+	if c.UserID == 0 && c.ClientID != "" {
+		return clientSubjectPrefix + c.ClientID
+	}
+
+	return strconv.FormatInt(c.UserID, 10)
+}
+
+func (s Session) expiry(expiresAt time.Time) time.Time {
+	if !s.ExpiresAt.IsZero() && s.ExpiresAt.Before(expiresAt) {
+		return s.ExpiresAt
+	}
+
+	return expiresAt
+}
+
+// Audience is the aud claim of tokens issued for the app.
+func Audience(app models.App) string {
+	return strconv.Itoa(int(app.ID))
+}
+
+func registeredClaims(issuer Issuer, user models.User, app models.App, id string, now time.Time, expiresAt time.Time) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    issuer.Name,
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Audience:  jwt.ClaimStrings{Audience(app)},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        id,
+	}
+}
+
+func signAccessToken(keys *KeySet, app models.App, claims jwt.Claims) (string, error) {
+	method, kid, key := appSigner(keys, app)
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	return token.SignedString(key)
+}
+
+// appSigner picks the method, kid and key the tokens of the app that are
+// verified by others are signed with.
+func appSigner(keys *KeySet, app models.App) (jwt.SigningMethod, string, any) {
+	if key, ok := keys.SigningKey(app.ID); ok {
+		return key.method(), key.ID, key.Private
+	}
+
+	return jwt.SigningMethodHS256, accessKeyID(app), []byte(app.Secret)
+}
+
+// verificationKey resolves the key for the token making sure that the
+// algorithm in the header matches the key, so that a public key can never be
+// used as an HMAC secret. Apps with an asymmetric key don't accept HS256
+// access tokens at all, the app secret may be known to more parties than
+// the private key.
+func verificationKey(keys *KeySet, app models.App, token *jwt.Token, isRefresh bool) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+
+	if isRefresh {
+		if alg != jwt.SigningMethodHS256.Alg() || (kid != "" && kid != refreshKeyID(app)) {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return []byte(app.RefreshSecret), nil
+	}
+
+	if key, ok := keys.VerificationKey(kid); ok {
+		if alg != key.Algorithm || (key.AppID != 0 && key.AppID != app.ID) {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return key.Public(), nil
+	}
+
+	if _, ok := keys.SigningKey(app.ID); ok {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+
+	if alg != jwt.SigningMethodHS256.Alg() || (kid != "" && kid != accessKeyID(app)) {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+
+	return []byte(app.Secret), nil
+}
+
+func accessKeyID(app models.App) string {
+	return fmt.Sprintf("app-%d", app.ID)
+}
+
+func refreshKeyID(app models.App) string {
+	return fmt.Sprintf("app-%d-refresh", app.ID)
+}
+
+// UnverifiedAppID reads the app_id claim without verifying the signature.
+// It is only meant to pick the app whose keys the token must be verified with.
+func UnverifiedAppID(tokenStr string) (int32, error) {
+	var claims Claims
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenStr, &claims); err != nil {
+		return 0, err
+	}
+
+	return claims.AppID, nil
+}
+
+// NewTokenID returns a random identifier suitable for the jti claim and
+// for grouping refresh tokens into families.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
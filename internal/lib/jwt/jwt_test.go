@@ -0,0 +1,94 @@
+package jwt_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+)
+
+const issuerName = "http://localhost:5446"
+
+var (
+	user = models.User{ID: 42, Email: "user@sso.test"}
+	app  = models.App{ID: 1, Secret: "sso_secret", RefreshSecret: "sso_refresh_secret"}
+)
+
+func TestValidateToken_HS256WithoutKey(t *testing.T) {
+	issuer := jwt.Issuer{Name: issuerName, Keys: jwt.NewKeySet()}
+
+	claims, err := jwt.ValidateToken(issuer, app, hs256Token(t, app), false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+}
+
+func TestValidateToken_HS256RefusedWithAppKey(t *testing.T) {
+	key := newKey(t, "app-key", app.ID)
+	issuer := jwt.Issuer{Name: issuerName, Keys: jwt.NewKeySet(key)}
+
+	_, err := jwt.ValidateToken(issuer, app, hs256Token(t, app), false, nil)
+	require.ErrorIs(t, err, gojwt.ErrTokenUnverifiable)
+
+	// Tokens signed with the key of the app still pass.
+	pair, err := jwt.NewTokenPair(issuer, user, nil, app, time.Minute, time.Hour, jwt.Session{AuthTime: time.Now()})
+	require.NoError(t, err)
+
+	claims, err := jwt.ValidateToken(issuer, app, pair.AccessToken, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+
+	// Refresh tokens are always HS256 with the refresh secret.
+	_, err = jwt.ValidateToken(issuer, app, pair.RefreshToken, true, nil)
+	require.NoError(t, err)
+}
+
+func TestValidateToken_HS256RefusedWithGlobalKey(t *testing.T) {
+	key := newKey(t, "global-key", 0)
+	issuer := jwt.Issuer{Name: issuerName, Keys: jwt.NewKeySet(key)}
+
+	_, err := jwt.ValidateToken(issuer, app, hs256Token(t, app), false, nil)
+	require.ErrorIs(t, err, gojwt.ErrTokenUnverifiable)
+}
+
+// hs256Token signs an access token of the app with its secret, the way
+// apps without an asymmetric key get them.
+func hs256Token(t *testing.T, app models.App) string {
+	t.Helper()
+
+	now := time.Now()
+
+	token := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{
+		"user_id": user.ID,
+		"app_id":  app.ID,
+		"iss":     issuerName,
+		"sub":     strconv.FormatInt(user.ID, 10),
+		"aud":     jwt.Audience(app),
+		"iat":     now.Unix(),
+		"nbf":     now.Unix(),
+		"exp":     now.Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "app-" + strconv.Itoa(int(app.ID))
+
+	signed, err := token.SignedString([]byte(app.Secret))
+	require.NoError(t, err)
+
+	return signed
+}
+
+func newKey(t *testing.T, id string, appID int32) jwt.SigningKey {
+	t.Helper()
+
+	private, err := jwt.GenerateKey(jwt.AlgES256)
+	require.NoError(t, err)
+
+	key, err := jwt.NewSigningKey(id, jwt.AlgES256, appID, private)
+	require.NoError(t, err)
+	key.Active = true
+
+	return key
+}
@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrKeyAlgorithmMismatch = errors.New("key does not match signing algorithm")
+)
+
+// SigningKey is an asymmetric key access tokens are signed with. Its public
+// part is published in the JWKS document.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	// AppID limits the key to a single app, 0 means the key is global.
+	AppID   int32
+	Private crypto.Signer
+	// Active keys sign new tokens. Inactive keys are only used to verify
+	// tokens, e.g. a retired key until the tokens it signed expire or a
+	// pending key published ahead of its activation.
+	Active bool
+}
+
+func (k SigningKey) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+func (k SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// NewSigningKey checks that the private key can be used with the algorithm.
+func NewSigningKey(id string, algorithm string, appID int32, private crypto.Signer) (SigningKey, error) {
+	var ok bool
+	switch algorithm {
+	case AlgRS256:
+		_, ok = private.(*rsa.PrivateKey)
+	case AlgES256:
+		var key *ecdsa.PrivateKey
+		key, ok = private.(*ecdsa.PrivateKey)
+		ok = ok && key.Curve == elliptic.P256()
+	case AlgEdDSA:
+		_, ok = private.(ed25519.PrivateKey)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	if !ok {
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrKeyAlgorithmMismatch, algorithm)
+	}
+
+	return SigningKey{
+		ID:        id,
+		Algorithm: algorithm,
+		AppID:     appID,
+		Private:   private,
+	}, nil
+}
+
+// GenerateKey creates a new private key for the algorithm.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unsupported private key format")
+}
+
+// KeySet holds the asymmetric signing keys. When an app has no key of its
+// own and there is no global key, tokens fall back to HS256 with the app
+// secret. It is safe for concurrent use.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []SigningKey
+}
+
+func NewKeySet(keys ...SigningKey) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// Replace swaps the keys of the set. When several active keys fit an app,
+// the one that comes first is used for signing.
+func (s *KeySet) Replace(keys ...SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = keys
+}
+
+// SigningKey returns the active key tokens of the app are signed with. Keys
+// bound to the app take precedence over global ones.
+func (s *KeySet) SigningKey(appID int32) (SigningKey, bool) {
+	if s == nil {
+		return SigningKey{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var global *SigningKey
+	for i, key := range s.keys {
+		if !key.Active {
+			continue
+		}
+		if key.AppID == appID {
+			return key, true
+		}
+		if key.AppID == 0 && global == nil {
+			global = &s.keys[i]
+		}
+	}
+	if global != nil {
+		return *global, true
+	}
+
+	return SigningKey{}, false
+}
+
+// VerificationKey looks up a key by its kid.
+func (s *KeySet) VerificationKey(kid string) (SigningKey, bool) {
+	if s == nil {
+		return SigningKey{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the RFC 7517 format.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	if s == nil {
+		return jwks
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, publicJWK(key))
+	}
+
+	return jwks
+}
+
+func publicJWK(key SigningKey) JWK {
+	jwk := JWK{
+		Kid: key.ID,
+		Use: "sig",
+		Alg: key.Algorithm,
+	}
+
+	enc := base64.RawURLEncoding
+
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = enc.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = enc.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	}
+
+	return jwk
+}
@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecipient = errors.New("invalid recipient")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages to users.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// LogSender writes messages to the log instead of sending them, for local
+// development.
+type LogSender struct {
+	log *slog.Logger
+}
+
+func NewLogSender(log *slog.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+func (s *LogSender) Send(msg Message) error {
+	s.log.Info("mail",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
+
+// FileSender stores every message in its own file in Dir, named after the
+// recipient and the time it was sent, for local development and tests.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func NewFileSender(dir string, from string) *FileSender {
+	return &FileSender{Dir: dir, From: from}
+}
+
+func (s *FileSender) Send(msg Message) error {
+	if err := checkRecipient(msg.To); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := msg.To + "-" + strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+
+	return os.WriteFile(filepath.Join(s.Dir, name), format(s.From, msg), 0o644)
+}
+
+// SMTPSender sends messages through an SMTP server, authenticating with
+// PLAIN auth if a username is set.
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPSender(addr string, username string, password string, from string) *SMTPSender {
+	return &SMTPSender{Addr: addr, Username: username, Password: password, From: from}
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	if err := checkRecipient(msg.To); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, format(s.From, msg))
+}
+
+// checkRecipient rejects addresses that could inject headers or escape the
+// mail directory.
+func checkRecipient(to string) error {
+	if to == "" || strings.ContainsAny(to, "\r\n/\\") {
+		return ErrInvalidRecipient
+	}
+
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + from + "\r\n")
+	}
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + strings.NewReplacer("\r", "", "\n", "").Replace(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// MethodS256 is the only PKCE code challenge method accepted, "plain" gives
+// no protection against intercepted authorization codes.
+const MethodS256 = "S256"
+
+const (
+	minVerifierLength = 43
+	maxVerifierLength = 128
+)
+
+// NewCode generates an opaque value for authorization codes and similar
+// one-time secrets.
+func NewCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashCode is the form one-time secrets are stored in, so that a leaked
+// database doesn't reveal usable values.
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidCodeChallenge reports whether the challenge looks like an S256 one:
+// an unpadded base64url encoded SHA-256 hash.
+func ValidCodeChallenge(challenge string, method string) bool {
+	if method != MethodS256 {
+		return false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// VerifyCodeChallenge checks the code verifier against the S256 challenge
+// as described in RFC 7636.
+func VerifyCodeChallenge(challenge string, method string, verifier string) bool {
+	if method != MethodS256 || !validCodeVerifier(verifier) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// CodeChallenge derives the S256 code challenge from the code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func validCodeVerifier(verifier string) bool {
+	if len(verifier) < minVerifierLength || len(verifier) > maxVerifierLength {
+		return false
+	}
+
+	for _, c := range verifier {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidScope reports whether the scope token is well formed as defined in
+// RFC 6749 section 3.3.
+func ValidScope(scope string) bool {
+	if scope == "" {
+		return false
+	}
+
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// userCodeAlphabet has no vowels, so user codes don't spell words, and no
+// characters that are easily confused with each other.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const userCodeLength = 8
+
+// NewUserCode generates a device flow user code, formatted as XXXX-XXXX so
+// that it is easy to type.
+func NewUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, 0, userCodeLength+1)
+	for i, v := range b {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		// 256 is not a multiple of the alphabet size, the small bias is
+		// irrelevant for codes that expire within minutes.
+		code = append(code, userCodeAlphabet[int(v)%len(userCodeAlphabet)])
+	}
+
+	return string(code), nil
+}
+
+// NormalizeUserCode brings a user code typed by a user to the form it was
+// issued in: upper case, with the separator at its place and everything
+// else dropped.
+func NormalizeUserCode(code string) string {
+	chars := make([]byte, 0, userCodeLength)
+	for _, c := range strings.ToUpper(code) {
+		if strings.ContainsRune(userCodeAlphabet, c) {
+			chars = append(chars, byte(c))
+		}
+	}
+
+	if len(chars) != userCodeLength {
+		return ""
+	}
+
+	return string(chars[:userCodeLength/2]) + "-" + string(chars[userCodeLength/2:])
+}
@@ -0,0 +1,125 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidCBOR is returned for malformed or unsupported CBOR. Only the
+// subset of CBOR (RFC 8949) used by authenticators is decoded: integers,
+// byte and text strings, arrays, maps and simple values, all of definite
+// length.
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first item of b and returns it with the number of
+// bytes it took. Integers are returned as int64, byte strings as []byte,
+// maps as map[any]any keyed by int64 or string.
+func decodeCBOR(b []byte) (any, int, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, int, error) {
+	if depth > maxCBORDepth || len(b) == 0 {
+		return nil, 0, ErrInvalidCBOR
+	}
+
+	major := b[0] >> 5
+	arg, n, err := cborArgument(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, ErrInvalidCBOR
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, ErrInvalidCBOR
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(b)-n) {
+			return nil, 0, ErrInvalidCBOR
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(b[n:end]), end, nil
+		}
+		return append([]byte(nil), b[n:end]...), end, nil
+	case 4:
+		// Every item takes at least a byte.
+		if arg > uint64(len(b)-n) {
+			return nil, 0, ErrInvalidCBOR
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			item, size, err := decodeCBORItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += size
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(b)-n)/2 {
+			return nil, 0, ErrInvalidCBOR
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			key, size, err := decodeCBORItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += size
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, ErrInvalidCBOR
+			}
+			value, size, err := decodeCBORItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += size
+			m[key] = value
+		}
+		return m, n, nil
+	case 7:
+		switch b[0] & 0x1f {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22:
+			return nil, 1, nil
+		}
+	}
+
+	return nil, 0, ErrInvalidCBOR
+}
+
+// cborArgument reads the argument of the item head and returns it with the
+// size of the head.
+func cborArgument(b []byte) (uint64, int, error) {
+	info := b[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(b) >= 2:
+		return uint64(b[1]), 2, nil
+	case info == 25 && len(b) >= 3:
+		return uint64(binary.BigEndian.Uint16(b[1:])), 3, nil
+	case info == 26 && len(b) >= 5:
+		return uint64(binary.BigEndian.Uint32(b[1:])), 5, nil
+	case info == 27 && len(b) >= 9:
+		return binary.BigEndian.Uint64(b[1:]), 9, nil
+	}
+
+	return 0, 0, ErrInvalidCBOR
+}
@@ -0,0 +1,403 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+const challengeSize = 32
+
+var (
+	ErrInvalidCredential = errors.New("invalid credential")
+	ErrInvalidClientData = errors.New("invalid client data")
+	ErrInvalidSignature  = errors.New("invalid signature")
+)
+
+// RelyingParty is the site credentials are scoped to. Origins are the exact
+// origins ceremonies may run at.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// User is the account a credential is created for. ID is an opaque handle
+// authenticators store with discoverable credentials.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// NewChallenge generates the random challenge of a ceremony, base64url
+// encoded the way it appears in options and client data.
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreationOptions are the options of a registration ceremony, serialized the
+// way PublicKeyCredential.parseCreationOptionsFromJSON expects them.
+type CreationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of an authentication ceremony, serialized
+// the way PublicKeyCredential.parseRequestOptionsFromJSON expects them.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// NewCreationOptions asks for a discoverable, user verifying credential, so
+// that it can be used for passwordless login. Attestation isn't requested,
+// the authenticator model is not checked.
+func NewCreationOptions(rp RelyingParty, user User, challenge string, exclude []string, timeout time.Duration) CreationOptions {
+	return CreationOptions{
+		RP: rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(user.ID),
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// NewRequestOptions asks for an assertion of one of the allowed credentials,
+// or of any discoverable credential of the relying party if none are given.
+// Credential IDs are base64url encoded.
+func NewRequestOptions(rp RelyingParty, challenge string, allow []string, requireUserVerification bool, timeout time.Duration) RequestOptions {
+	userVerification := "preferred"
+	if requireUserVerification {
+		userVerification = "required"
+	}
+
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+func descriptors(ids []string) []credentialDescriptor {
+	list := make([]credentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, credentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return list
+}
+
+// base64URL is a binary value of a PublicKeyCredential serialized with
+// toJSON().
+type base64URL []byte
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+
+	return nil
+}
+
+// Credential is the PublicKeyCredential returned by the browser for either
+// ceremony, serialized with toJSON().
+type Credential struct {
+	ID       base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    base64URL `json:"clientDataJSON"`
+		AttestationObject base64URL `json:"attestationObject"`
+		AuthenticatorData base64URL `json:"authenticatorData"`
+		Signature         base64URL `json:"signature"`
+		UserHandle        base64URL `json:"userHandle"`
+	} `json:"response"`
+
+	clientData clientData
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseCredential decodes the credential and its client data, so that the
+// ceremony can be looked up by the challenge before it is verified.
+func ParseCredential(data []byte) (*Credential, error) {
+	var cred Credential
+	if err := json.Unmarshal(data, &cred); err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if cred.Type != "public-key" || len(cred.ID) == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	if err := json.Unmarshal(cred.Response.ClientDataJSON, &cred.clientData); err != nil {
+		return nil, ErrInvalidClientData
+	}
+
+	return &cred, nil
+}
+
+// CredentialID is the base64url encoded ID of the credential.
+func (c *Credential) CredentialID() string {
+	return base64.RawURLEncoding.EncodeToString(c.ID)
+}
+
+// Challenge is the challenge the client signed, as returned by
+// NewChallenge.
+func (c *Credential) Challenge() string {
+	return c.clientData.Challenge
+}
+
+// UserHandle is the user ID stored with a discoverable credential, empty
+// for other credentials.
+func (c *Credential) UserHandle() []byte {
+	return c.Response.UserHandle
+}
+
+// Registration is a verified new credential.
+type Registration struct {
+	// CredentialID is base64url encoded.
+	CredentialID string
+	// PublicKey is the COSE encoded credential public key.
+	PublicKey    []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyRegistration checks the response of a registration ceremony (WebAuthn
+// Level 2, section 7.1) with "none" attestation.
+func (c *Credential) VerifyRegistration(rp RelyingParty, challenge string) (Registration, error) {
+	if err := c.verifyClientData(rp, challenge, "webauthn.create"); err != nil {
+		return Registration{}, err
+	}
+
+	item, _, err := decodeCBOR(c.Response.AttestationObject)
+	if err != nil {
+		return Registration{}, ErrInvalidCredential
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return Registration{}, ErrInvalidCredential
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Registration{}, ErrInvalidCredential
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Registration{}, err
+	}
+	if err := authData.verify(rp, true); err != nil {
+		return Registration{}, err
+	}
+	if authData.flags&flagAttested == 0 || !bytes.Equal(authData.credentialID, c.ID) {
+		return Registration{}, ErrInvalidCredential
+	}
+
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return Registration{}, err
+	}
+
+	return Registration{
+		CredentialID: base64.RawURLEncoding.EncodeToString(authData.credentialID),
+		PublicKey:    authData.publicKey,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// Assertion is a verified authentication with a stored credential.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyAssertion checks the response of an authentication ceremony
+// (WebAuthn Level 2, section 7.2) against the stored COSE public key.
+func (c *Credential) VerifyAssertion(rp RelyingParty, challenge string, cosePublicKey []byte, requireUserVerification bool) (Assertion, error) {
+	if err := c.verifyClientData(rp, challenge, "webauthn.get"); err != nil {
+		return Assertion{}, err
+	}
+
+	authData, err := parseAuthenticatorData(c.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if err := authData.verify(rp, requireUserVerification); err != nil {
+		return Assertion{}, err
+	}
+
+	key, err := parsePublicKey(cosePublicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	clientDataHash := sha256.Sum256(c.Response.ClientDataJSON)
+	signed := append(slices.Clip(c.Response.AuthenticatorData), clientDataHash[:]...)
+	if !key.verify(signed, c.Response.Signature) {
+		return Assertion{}, ErrInvalidSignature
+	}
+
+	return Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+func (c *Credential) verifyClientData(rp RelyingParty, challenge string, ceremony string) error {
+	data := c.clientData
+	if data.Type != ceremony || data.CrossOrigin {
+		return ErrInvalidClientData
+	}
+	if data.Challenge != challenge {
+		return ErrInvalidClientData
+	}
+	if !slices.Contains(rp.Origins, data.Origin) {
+		return ErrInvalidClientData
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData decodes the authenticator data (WebAuthn Level 2,
+// section 6.1). Extensions are ignored.
+func parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	if len(b) < 37 {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+
+	data := authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	if data.flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	// AAGUID, credential ID length, credential ID and public key.
+	rest := b[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || len(rest) < idLen {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+	data.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, n, err := decodeCBOR(rest)
+	if err != nil {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+	data.publicKey = rest[:n]
+
+	return data, nil
+}
+
+func (d authenticatorData) verify(rp RelyingParty, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(d.rpIDHash, rpIDHash[:]) {
+		return ErrInvalidCredential
+	}
+	if d.flags&flagUserPresent == 0 {
+		return ErrInvalidCredential
+	}
+	if requireUserVerification && d.flags&flagUserVerified == 0 {
+		return ErrInvalidCredential
+	}
+
+	return nil
+}
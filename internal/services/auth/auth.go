@@ -5,25 +5,69 @@ import (
 	"fmt"
 	"log/slog"
 	"sso/internal/domain/models"
+	"sso/internal/lib/federation"
 	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/secret"
 	"sso/internal/storage"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Auth struct {
-	log          *slog.Logger
-	userSaver    UserSaver
-	userProvider UserProvider
-	appProvider  AppProvider
-	tokenTTL     time.Duration
-	refreshTTL   time.Duration
+	log             *slog.Logger
+	userSaver       UserSaver
+	userProvider    UserProvider
+	appProvider     AppProvider
+	tokenSaver      TokenSaver
+	tokenProvider   TokenProvider
+	revocations     RevocationStore
+	sessions        SessionStore
+	codes           AuthorizationCodeStore
+	clients         ClientSaver
+	devices         DeviceCodeStore
+	identities      IdentityStore
+	consents        ConsentStore
+	emailTokens     EmailTokenStore
+	mfa             MFAStore
+	audit           AuditStore
+	webAuthn        WebAuthnStore
+	passwordless    PasswordlessStore
+	roles           RoleStore
+	rateLimits      RateLimitStore
+	providers       map[string]*federation.Provider
+	mailer          mail.Sender
+	secrets         *secret.Box
+	mfaIssuer       string
+	issuer          jwt.Issuer
+	tokenTTL        time.Duration
+	refreshTTL      time.Duration
+	sessionLifetime time.Duration
+	idleTimeout     time.Duration
+	codeTTL         time.Duration
+	deviceCodeTTL   time.Duration
+	deviceInterval  time.Duration
+	verificationTTL time.Duration
+	resetTTL        time.Duration
+	passwordlessTTL time.Duration
+	mfaChallengeTTL time.Duration
+	webAuthnTimeout time.Duration
+
+	passwordlessEmailLimit  RateLimit
+	passwordlessIPLimit     RateLimit
+	passwordResetEmailLimit RateLimit
+	passwordResetIPLimit    RateLimit
 }
 
 type UserSaver interface {
 	SaveUser(email string, passHash []byte) (int64, error)
+	SetEmailVerified(id int64, email string) error
+	UpdatePassword(id int64, passHash []byte) error
+	UpdateEmail(id int64, email string) error
+	ResetCredentials(id int64, passHash []byte) error
 }
 
 type UserProvider interface {
@@ -36,36 +80,286 @@ type AppProvider interface {
 	App(appID int32) (models.App, error)
 }
 
+type TokenSaver interface {
+	SaveRefreshToken(token models.RefreshToken) error
+	MarkRefreshTokenUsed(id string) error
+	RevokeTokenFamily(familyID string) error
+}
+
+type TokenProvider interface {
+	RefreshToken(id string) (models.RefreshToken, error)
+}
+
+type RevocationStore interface {
+	jwt.RevocationChecker
+	RevokeToken(id string, expiresAt time.Time) error
+	RevokeUserTokens(userID int64, revokedAt time.Time, expiresAt time.Time) error
+	PruneRevocations(now time.Time) (int64, error)
+}
+
+type SessionStore interface {
+	SaveSession(session models.Session) error
+	TouchSession(id string, lastUsedAt time.Time, expiresAt time.Time) error
+	Session(id string) (models.Session, error)
+	UserSessions(userID int64, now time.Time) ([]models.Session, error)
+}
+
+type ClientSaver interface {
+	SaveClientCredentials(appID int32, secretHash string, scopes []string) error
+}
+
+type AuthorizationCodeStore interface {
+	SaveAuthorizationCode(code models.AuthorizationCode) error
+	UseAuthorizationCode(id string, usedAt time.Time) (models.AuthorizationCode, error)
+}
+
+type DeviceCodeStore interface {
+	SaveDeviceCode(code models.DeviceCode) error
+	DeviceCode(id string) (models.DeviceCode, error)
+	DeviceCodeByUserCode(userCode string) (models.DeviceCode, error)
+	PollDeviceCode(id string, polledAt time.Time, interval models.Seconds) error
+	DecideDeviceCode(userCode string, state string, userID int64, decidedAt time.Time) error
+	RedeemDeviceCode(id string) error
+}
+
+type IdentityStore interface {
+	UserIdentity(provider string, subject string) (models.UserIdentity, error)
+	SaveUserIdentity(identity models.UserIdentity) error
+	SaveFederationState(state models.FederationState) error
+	UseFederationState(id string) (models.FederationState, error)
+}
+
+type ConsentStore interface {
+	SaveConsent(userID int64, appID int32, scopes []string, grantedAt time.Time) error
+	UserConsents(userID int64) ([]models.Consent, error)
+	DeleteConsent(userID int64, appID int32) error
+}
+
+type EmailTokenStore interface {
+	SaveEmailToken(token models.EmailToken) error
+	UseEmailToken(id string, purpose string) (models.EmailToken, error)
+	DeleteEmailTokens(userID int64, purpose string) error
+}
+
+type MFAStore interface {
+	SaveTOTP(totp models.TOTP) error
+	TOTP(userID int64) (models.TOTP, error)
+	ConfirmTOTP(userID int64, step int64, confirmedAt time.Time) error
+	UseTOTPStep(userID int64, step int64) error
+	FailTOTP(userID int64, failedAt time.Time) error
+	SaveMFAChallenge(challenge models.MFAChallenge) error
+	MFAChallenge(id string) (models.MFAChallenge, error)
+	DeleteMFAChallenge(id string) error
+	ReplaceRecoveryCodes(userID int64, codeHashes []string) error
+	UseRecoveryCode(userID int64, codeHash string) error
+}
+
+type AuditStore interface {
+	SaveAuditEvent(event models.AuditEvent) error
+}
+
+type WebAuthnStore interface {
+	SaveWebAuthnCredential(credential models.WebAuthnCredential) error
+	WebAuthnCredential(id string) (models.WebAuthnCredential, error)
+	UserWebAuthnCredentials(userID int64, rpID string) ([]models.WebAuthnCredential, error)
+	UseWebAuthnCredential(id string, signCount int64, usedAt time.Time) error
+	SaveWebAuthnCeremony(ceremony models.WebAuthnCeremony) error
+	UseWebAuthnCeremony(id string) (models.WebAuthnCeremony, error)
+}
+
+type PasswordlessStore interface {
+	SavePasswordlessLogin(login models.PasswordlessLogin) error
+	AttemptPasswordlessLogin(id string, maxAttempts int) (models.PasswordlessLogin, error)
+	DeletePasswordlessLogin(id string) error
+}
+
+type RoleStore interface {
+	SaveRole(role models.Role) (int32, error)
+	Role(name string, appID int32) (models.Role, error)
+	GrantRole(userID int64, appID int32, roleID int32, grantedAt time.Time) error
+	RevokeRole(userID int64, appID int32, roleID int32) error
+	UserRoles(userID int64, appID int32) ([]models.Role, error)
+	HasPermission(userID int64, appID int32, permission string) (bool, error)
+}
+
+type RateLimitStore interface {
+	HitRateLimit(key string, window time.Duration, now time.Time) (int, error)
+}
+
 var (
 	ErrInvalidCredentials     = errors.New("invalid credentials")
 	ErrInvalidAppID           = errors.New("invalid app id")
 	ErrUserExists             = errors.New("user already exists")
 	ErrInvalidEmailOrPassword = errors.New("invalid email or password")
+	ErrInvalidRefreshToken    = errors.New("invalid refresh token")
+	ErrRefreshTokenReused     = errors.New("refresh token reused")
+	ErrInvalidAccessToken     = errors.New("invalid access token")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrPermissionDenied       = errors.New("permission denied")
+	ErrInvalidRedirectURI     = errors.New("invalid redirect uri")
+	ErrInvalidCodeChallenge   = errors.New("invalid code challenge")
+	ErrInvalidGrant           = errors.New("invalid grant")
+	ErrInvalidClient          = errors.New("invalid client")
+	ErrInvalidScope           = errors.New("invalid scope")
+	ErrInvalidUserCode        = errors.New("invalid user code")
+	ErrAuthorizationPending   = errors.New("authorization pending")
+	ErrSlowDown               = errors.New("slow down")
+	ErrAccessDenied           = errors.New("access denied")
+	ErrExpiredToken           = errors.New("expired token")
+	ErrUnknownProvider        = errors.New("unknown identity provider")
+	ErrInvalidState           = errors.New("invalid state")
+	ErrFederationFailed       = errors.New("federated login failed")
+	ErrUnverifiedEmail        = errors.New("email is not verified by the identity provider")
+	ErrAccountNotVerified     = errors.New("email of the existing account is not verified")
+	ErrInvalidSubjectToken    = errors.New("invalid subject token")
+	ErrInvalidActorToken      = errors.New("invalid actor token")
+	ErrInvalidTarget          = errors.New("invalid target")
+	ErrConsentNotFound        = errors.New("consent not found")
+	ErrEmailNotVerified       = errors.New("email is not verified")
+	ErrInvalidEmailToken      = errors.New("invalid or expired email token")
+	ErrInvalidPassword        = errors.New("invalid password")
+	ErrMFARequired            = errors.New("mfa required")
+	ErrInvalidMFACode         = errors.New("invalid mfa code")
+	ErrInvalidMFAToken        = errors.New("invalid or expired mfa token")
+	ErrMFALocked              = errors.New("too many wrong mfa codes")
+	ErrMFAEnabled             = errors.New("mfa already enabled")
+	ErrMFANotEnrolled         = errors.New("mfa not enrolled")
+	ErrMFAUnavailable         = errors.New("mfa is not configured")
+	ErrPasskeysUnavailable    = errors.New("passkeys are not configured for the app")
+	ErrInvalidPasskey         = errors.New("invalid passkey")
+	ErrPasskeyExists          = errors.New("passkey already registered")
+	ErrInvalidLoginMethod     = errors.New("invalid passwordless login method")
+	ErrInvalidLoginCode       = errors.New("invalid or expired login code")
+	ErrInvalidRole            = errors.New("invalid role")
+	ErrInvalidPermission      = errors.New("invalid permission")
+	ErrRoleExists             = errors.New("role already exists")
+	ErrRoleNotFound           = errors.New("role not found")
+	ErrRoleNotGranted         = errors.New("role not granted")
+	ErrTooManyRequests        = errors.New("too many requests")
+	ErrScopeRequired          = errors.New("scope is required")
 )
 
+// Storage is everything the service keeps in the database.
+type Storage interface {
+	UserSaver
+	UserProvider
+	AppProvider
+	TokenSaver
+	TokenProvider
+	RevocationStore
+	SessionStore
+	AuthorizationCodeStore
+	ClientSaver
+	DeviceCodeStore
+	IdentityStore
+	ConsentStore
+	EmailTokenStore
+	MFAStore
+	AuditStore
+	WebAuthnStore
+	PasswordlessStore
+	RoleStore
+	RateLimitStore
+}
+
+// Options configure the service. Zero session lifetime and idle timeout
+// mean there is no limit, see tokenPolicy.
+type Options struct {
+	// Providers are the upstream identity providers users can sign in
+	// with, keyed by name.
+	Providers map[string]*federation.Provider
+	Mailer    mail.Sender
+	// Secrets encrypts the TOTP secrets, users can't turn on MFA without
+	// it.
+	Secrets *secret.Box
+	// MFAIssuer names the account in authenticator apps.
+	MFAIssuer string
+	Issuer    jwt.Issuer
+
+	TokenTTL        time.Duration
+	RefreshTTL      time.Duration
+	SessionLifetime time.Duration
+	IdleTimeout     time.Duration
+	CodeTTL         time.Duration
+	DeviceCodeTTL   time.Duration
+	DeviceInterval  time.Duration
+	VerificationTTL time.Duration
+	ResetTTL        time.Duration
+	PasswordlessTTL time.Duration
+	MFAChallengeTTL time.Duration
+	WebAuthnTimeout time.Duration
+
+	// PasswordlessEmailLimit and PasswordlessIPLimit limit how many login
+	// codes are mailed to an email and requested from an IP.
+	PasswordlessEmailLimit RateLimit
+	PasswordlessIPLimit    RateLimit
+	// PasswordResetEmailLimit and PasswordResetIPLimit do the same for
+	// password reset mails.
+	PasswordResetEmailLimit RateLimit
+	PasswordResetIPLimit    RateLimit
+}
+
 func New(
 	log *slog.Logger,
-	userSaver UserSaver,
-	userProvider UserProvider,
-	appProvider AppProvider,
-	tokenTTL time.Duration,
-	refreshTTL time.Duration,
+	storage Storage,
+	opts Options,
 ) *Auth {
 	return &Auth{
-		log,
-		userSaver,
-		userProvider,
-		appProvider,
-		tokenTTL,
-		refreshTTL,
+		log:             log,
+		userSaver:       storage,
+		userProvider:    storage,
+		appProvider:     storage,
+		tokenSaver:      storage,
+		tokenProvider:   storage,
+		revocations:     storage,
+		sessions:        storage,
+		codes:           storage,
+		clients:         storage,
+		devices:         storage,
+		identities:      storage,
+		consents:        storage,
+		emailTokens:     storage,
+		mfa:             storage,
+		audit:           storage,
+		webAuthn:        storage,
+		passwordless:    storage,
+		roles:           storage,
+		rateLimits:      storage,
+		providers:       opts.Providers,
+		mailer:          opts.Mailer,
+		secrets:         opts.Secrets,
+		mfaIssuer:       opts.MFAIssuer,
+		issuer:          opts.Issuer,
+		tokenTTL:        opts.TokenTTL,
+		refreshTTL:      opts.RefreshTTL,
+		sessionLifetime: opts.SessionLifetime,
+		idleTimeout:     opts.IdleTimeout,
+		codeTTL:         opts.CodeTTL,
+		deviceCodeTTL:   opts.DeviceCodeTTL,
+		deviceInterval:  opts.DeviceInterval,
+		verificationTTL: opts.VerificationTTL,
+		resetTTL:        opts.ResetTTL,
+		passwordlessTTL: opts.PasswordlessTTL,
+		mfaChallengeTTL: opts.MFAChallengeTTL,
+		webAuthnTimeout: opts.WebAuthnTimeout,
+
+		passwordlessEmailLimit:  opts.PasswordlessEmailLimit,
+		passwordlessIPLimit:     opts.PasswordlessIPLimit,
+		passwordResetEmailLimit: opts.PasswordResetEmailLimit,
+		passwordResetIPLimit:    opts.PasswordResetIPLimit,
 	}
 }
 
+// Login authenticates the user with the password. If the user turned on MFA
+// no tokens are issued yet, the returned challenge token has to be completed
+// with VerifyMFA or a passkey instead.
 func (a *Auth) Login(
 	email string,
 	password string,
 	appID int32,
-) (jwt.TokenPair, error) {
+	scopes []string,
+	client models.ClientInfo,
+) (jwt.TokenPair, string, error) {
 	const op = "auth.Login"
 
 	log := a.log.With(
@@ -75,35 +369,276 @@ func (a *Auth) Login(
 
 	log.Info("attempting to login user")
 
+	user, err := a.checkPassword(log, email, password)
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := requireVerifiedEmail(log, user, app); err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	scopes, err = requestedScopes(app, scopes)
+	if err != nil {
+		log.Info("invalid scope")
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfaToken, err := a.mfaChallenge(log, user, app, strings.Join(scopes, " "))
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if mfaToken != "" {
+		log.Info("mfa required", slog.Int64("user_id", user.ID))
+		return jwt.TokenPair{}, mfaToken, nil
+	}
+
+	if err := a.grantScopes(log, user.ID, app.ID, scopes); err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged successfully")
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.startSession(log, user, app, familyID, time.Now(), strings.Join(scopes, " "), client) // Access и Refresh токены
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, "", nil
+}
+
+func (a *Auth) checkPassword(log *slog.Logger, email string, password string) (models.User, error) {
 	user, err := a.userProvider.UserByEmail(email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
-			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			log.Warn("user not found", sl.Err(err))
+			return models.User{}, ErrInvalidCredentials
 		}
-		a.log.Error("failed to get user", sl.Err(err))
+		log.Error("failed to get user", sl.Err(err))
 
-		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
 	}
 
 	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", sl.Err(err))
-		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidEmailOrPassword)
+		log.Info("invalid credentials", sl.Err(err))
+		return models.User{}, ErrInvalidEmailOrPassword
+	}
+
+	return user, nil
+}
+
+// startSession issues the first token pair of a new session with the granted
+// scopes and records the session in the registry.
+func (a *Auth) startSession(
+	log *slog.Logger,
+	user models.User,
+	app models.App,
+	familyID string,
+	authTime time.Time,
+	scope string,
+	client models.ClientInfo,
+) (jwt.TokenPair, error) {
+	if err := requireVerifiedEmail(log, user, app); err != nil {
+		return jwt.TokenPair{}, err
+	}
+
+	session := a.policy(app).session(familyID, authTime)
+	session.Scope = scope
+
+	tokens, err := a.issueTokens(user, app, session)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+		return jwt.TokenPair{}, err
+	}
+
+	now := time.Now()
+
+	err = a.sessions.SaveSession(models.Session{
+		ID:         familyID,
+		UserID:     user.ID,
+		AppID:      app.ID,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  sessionExpiry(tokens),
+	})
+	if err != nil {
+		log.Error("failed to save session", sl.Err(err))
+		return jwt.TokenPair{}, err
 	}
 
+	return tokens, nil
+}
+
+func (a *Auth) Refresh(
+	refreshToken string,
+	appID int32,
+) (jwt.TokenPair, error) {
+	const op = "auth.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	log.Info("attempting to refresh tokens")
+
 	app, err := a.appProvider.App(appID)
 	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
 		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user logged successfully")
+	claims, err := jwt.ValidateToken(a.issuer, app, refreshToken, true, a.revocations)
+	if err != nil {
+		log.Info("invalid refresh token", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if claims.AppID != app.ID {
+		log.Warn("refresh token issued for another app", slog.Int("token_app_id", int(claims.AppID)))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if claims.AuthTime == nil {
+		log.Warn("refresh token has no auth time")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	session := a.policy(app).session(claims.FamilyID, claims.AuthTime.Time)
+	if !session.ExpiresAt.IsZero() && time.Now().After(session.ExpiresAt) {
+		log.Info("session lifetime exceeded", slog.Time("session_expires_at", session.ExpiresAt))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	stored, err := a.tokenProvider.RefreshToken(claims.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Warn("refresh token not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+		log.Error("failed to get refresh token", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.RevokedAt != nil {
+		log.Info("refresh token family is revoked", slog.String("family_id", stored.FamilyID))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if stored.UsedAt == nil {
+		err = a.tokenSaver.MarkRefreshTokenUsed(stored.ID)
+	} else {
+		err = storage.ErrTokenAlreadyUsed
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenAlreadyUsed) {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, a.handleRefreshTokenReuse(log, stored))
+		}
+		log.Error("failed to rotate refresh token", sl.Err(err))
 
-	tokens, err := jwt.NewTokenPair(user, app, a.tokenTTL, a.refreshTTL) // Access и Refresh токены
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	session.FamilyID = stored.FamilyID
+	session.Scope = claims.Scope
+
+	tokens, err := a.issueTokens(user, app, session)
 	if err != nil {
 		log.Error("failed to generate tokens", sl.Err(err))
 		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
 	}
 
+	// The tokens are already rotated, failing to record the activity must
+	// not cost the client its session.
+	if err := a.sessions.TouchSession(stored.FamilyID, time.Now(), sessionExpiry(tokens)); err != nil {
+		log.Error("failed to update session", sl.Err(err))
+	}
+
+	log.Info("tokens refreshed successfully", slog.Int64("user_id", user.ID))
+
+	return tokens, nil
+}
+
+// handleRefreshTokenReuse revokes the whole token family after an already
+// rotated refresh token was presented again, since either the legitimate
+// client or an attacker holds a leaked copy.
+func (a *Auth) handleRefreshTokenReuse(log *slog.Logger, token models.RefreshToken) error {
+	log.Warn("security event: refresh token reuse detected",
+		slog.String("event", "refresh_token_reuse"),
+		slog.String("family_id", token.FamilyID),
+		slog.Int64("user_id", token.UserID),
+		slog.Int("app_id", int(token.AppID)),
+	)
+
+	if err := a.tokenSaver.RevokeTokenFamily(token.FamilyID); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// issueTokens mints a new token pair within the session using the app token
+// policy and records the refresh token so it can be rotated later.
+func (a *Auth) issueTokens(user models.User, app models.App, session jwt.Session) (jwt.TokenPair, error) {
+	policy := a.policy(app)
+
+	roles, err := a.templateRoles(user, app)
+	if err != nil {
+		return jwt.TokenPair{}, err
+	}
+
+	tokens, err := jwt.NewTokenPair(a.issuer, user, roles, app, policy.accessTTL, policy.refreshLifetime(), session)
+	if err != nil {
+		return jwt.TokenPair{}, err
+	}
+
+	if tokens.RefreshToken == "" {
+		return tokens, nil
+	}
+
+	err = a.tokenSaver.SaveRefreshToken(models.RefreshToken{
+		ID:        tokens.RefreshID,
+		FamilyID:  session.FamilyID,
+		UserID:    user.ID,
+		AppID:     app.ID,
+		ExpiresAt: tokens.RefreshExpiresAt,
+	})
+	if err != nil {
+		return jwt.TokenPair{}, err
+	}
+
 	return tokens, nil
 }
 
@@ -140,9 +675,17 @@ func (a *Auth) RegisterNewUser(
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	// The user can ask for another verification mail, so registration
+	// doesn't fail when the mail can't be sent.
+	if err := a.sendVerification(log, models.User{ID: id, Email: email}); err != nil {
+		log.Error("failed to send verification mail", sl.Err(err))
+	}
+
 	return id, nil
 }
 
+// IsAdmin reports whether the user has the admin role in any app. It
+// predates roles and is kept for the services that still ask for it.
 func (a *Auth) IsAdmin(
 	userID int64,
 ) (bool, error) {
@@ -172,3 +715,72 @@ func (a *Auth) IsAdmin(
 
 	return isAdmin, nil
 }
+
+// JWKS returns the public keys resource servers verify access tokens with.
+func (a *Auth) JWKS() jwt.JWKS {
+	return a.issuer.Keys.JWKS()
+}
+
+// authenticate verifies the user access token against the app it was issued
+// for and returns its claims. Tokens an app got for itself are rejected, and
+// so are tokens obtained by token exchange: whoever acts on behalf of the
+// user must not manage the account, e.g. register a second factor or
+// approve a device.
+func (a *Auth) authenticate(accessToken string) (*jwt.Claims, error) {
+	claims, err := a.authenticateUser(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Act != nil {
+		a.log.Warn("delegated token used to manage the account", slog.Int64("user_id", claims.UserID))
+		return nil, ErrInvalidAccessToken
+	}
+
+	return claims, nil
+}
+
+// authenticateUser verifies the user access token like authenticate, but
+// also accepts tokens acting on behalf of the user. Only for calls that
+// read what the token already grants.
+func (a *Auth) authenticateUser(accessToken string) (*jwt.Claims, error) {
+	claims, err := a.validateAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.UserID == 0 {
+		a.log.Info("client token used in place of a user token", slog.String("client_id", claims.ClientID))
+		return nil, ErrInvalidAccessToken
+	}
+
+	return claims, nil
+}
+
+// validateAccessToken verifies any access token, either of a user or of a
+// client, against the app it was issued for.
+func (a *Auth) validateAccessToken(accessToken string) (*jwt.Claims, error) {
+	appID, err := jwt.UnverifiedAppID(accessToken)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, err
+	}
+
+	claims, err := jwt.ValidateToken(a.issuer, app, accessToken, false, a.revocations)
+	if err != nil {
+		if errors.Is(err, jwt.ErrRevocationCheckFailed) {
+			return nil, err
+		}
+		a.log.Info("invalid access token", sl.Err(err))
+		return nil, ErrInvalidAccessToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strconv"
+)
+
+// RegisterClient makes the app a confidential client allowed to request the
+// scopes for itself and returns its client id and a new client secret. The
+// secret is not stored and can't be shown again, registering the app again
+// rotates it. The access token must belong to a user allowed to manage
+// clients of the app.
+func (a *Auth) RegisterClient(
+	accessToken string,
+	appID int32,
+	scopes []string,
+) (string, string, error) {
+	const op = "auth.RegisterClient"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkPermission(log, claims.UserID, appID, models.PermissionManageClients); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, scope := range scopes {
+		if !oauth.ValidScope(scope) || scope == scopeOpenID {
+			log.Info("invalid client scope", slog.String("scope", scope))
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidScope)
+		}
+	}
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := oauth.NewCode()
+	if err != nil {
+		log.Error("failed to generate client secret", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The secret is random and long enough that a fast hash is sufficient.
+	err = a.clients.SaveClientCredentials(app.ID, oauth.HashCode(secret), slices.Compact(slices.Sorted(slices.Values(scopes))))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to save client credentials", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client registered", slog.Int64("admin_id", claims.UserID))
+
+	return jwt.ClientID(app), secret, nil
+}
+
+// ClientCredentials issues an access token to the app itself, as in the
+// client credentials grant (RFC 6749 section 4.4). Without requested scopes
+// every scope of the client is granted.
+func (a *Auth) ClientCredentials(
+	clientID string,
+	clientSecret string,
+	scopes []string,
+) (jwt.TokenPair, error) {
+	const op = "auth.ClientCredentials"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	app, err := a.authenticateClient(log, clientID, clientSecret)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	granted := []string(app.ClientScopes)
+	if len(scopes) > 0 {
+		for _, scope := range scopes {
+			if !slices.Contains(app.ClientScopes, scope) {
+				log.Info("scope is not allowed for the client", slog.String("scope", scope))
+				return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidScope)
+			}
+		}
+		granted = slices.Compact(slices.Sorted(slices.Values(scopes)))
+	}
+
+	tokens, err := jwt.NewClientToken(a.issuer, app, a.policy(app).accessTTL, granted)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client token issued")
+
+	return tokens, nil
+}
+
+// authenticateClient checks the credentials of a confidential client.
+func (a *Auth) authenticateClient(log *slog.Logger, clientID string, clientSecret string) (models.App, error) {
+	appID, err := strconv.ParseInt(clientID, 10, 32)
+	if err != nil {
+		log.Info("malformed client id")
+		return models.App{}, ErrInvalidClient
+	}
+
+	app, err := a.appProvider.App(int32(appID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("client not found")
+			return models.App{}, ErrInvalidClient
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return models.App{}, err
+	}
+
+	if app.ClientSecretHash == nil {
+		log.Info("app is not a confidential client")
+		return models.App{}, ErrInvalidClient
+	}
+
+	if subtle.ConstantTimeCompare([]byte(oauth.HashCode(clientSecret)), []byte(*app.ClientSecretHash)) != 1 {
+		log.Warn("invalid client secret")
+		return models.App{}, ErrInvalidClient
+	}
+
+	return app, nil
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// RequestedScopes validates the space separated scopes requested for the
+// app, so that the login page can show the user what they consent to.
+func (a *Auth) RequestedScopes(
+	app models.App,
+	scope string,
+) ([]string, error) {
+	const op = "auth.RequestedScopes"
+
+	scopes, err := requestedScopes(app, strings.Fields(scope))
+	if err != nil {
+		a.log.Info("invalid scope", slog.String("op", op), slog.Int("app_id", int(app.ID)))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return scopes, nil
+}
+
+// ListConsents returns the scopes the user the access token belongs to
+// granted to apps.
+func (a *Auth) ListConsents(
+	accessToken string,
+) ([]models.Consent, error) {
+	const op = "auth.ListConsents"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	consents, err := a.consents.UserConsents(claims.UserID)
+	if err != nil {
+		log.Error("failed to get consents", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return consents, nil
+}
+
+// RevokeConsent withdraws every scope the user the access token belongs to
+// granted to the app and ends the sessions of the user in the app, so that
+// no token carrying the scopes stays valid.
+func (a *Auth) RevokeConsent(
+	accessToken string,
+	appID int32,
+) error {
+	const op = "auth.RevokeConsent"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	if err := a.consents.DeleteConsent(claims.UserID, appID); err != nil {
+		if errors.Is(err, storage.ErrConsentNotFound) {
+			log.Info("consent not found")
+			return fmt.Errorf("%s: %w", op, ErrConsentNotFound)
+		}
+		log.Error("failed to delete consent", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.sessions.UserSessions(claims.UserID, time.Now())
+	if err != nil {
+		log.Error("failed to get sessions", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, session := range sessions {
+		if session.AppID != appID {
+			continue
+		}
+		if err := a.revokeSession(log, session); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("consent revoked")
+
+	return nil
+}
+
+// grantScopes records the consent of the user to the scopes of the app.
+func (a *Auth) grantScopes(log *slog.Logger, userID int64, appID int32, scopes []string) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	if err := a.consents.SaveConsent(userID, appID, scopes, time.Now()); err != nil {
+		log.Error("failed to save consent", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// grantsScopes reports whether a token with the granted scopes grants the
+// requested ones. Tokens without scopes grant full access, and requests
+// without scopes ask for it.
+func grantsScopes(granted string, requested string) bool {
+	if granted == "" {
+		return true
+	}
+	if requested == "" {
+		return false
+	}
+
+	for _, scope := range strings.Fields(requested) {
+		if !hasScope(granted, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestedScopes returns the scopes sorted and without duplicates if the
+// app accepts all of them. OpenID Connect scopes are accepted by every app.
+func requestedScopes(app models.App, scopes []string) ([]string, error) {
+	for _, scope := range scopes {
+		if !oauth.ValidScope(scope) || (scope != scopeOpenID && !slices.Contains(app.Scopes, scope)) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	return slices.Compact(slices.Sorted(slices.Values(scopes))), nil
+}
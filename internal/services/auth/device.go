@@ -0,0 +1,402 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// slowDownStep is added to the polling interval of a client that polls too
+// often (RFC 8628 section 3.5).
+const slowDownStep = 5 * time.Second
+
+// userCodeAttempts bounds the retries on user code collisions.
+const userCodeAttempts = 3
+
+// DeviceAuthorization starts the device authorization flow (RFC 8628) for
+// the app. The device code is returned to the client for polling, the user
+// code is entered by the user on another device.
+func (a *Auth) DeviceAuthorization(
+	appID int32,
+	scope string,
+) (models.DeviceAuthorization, error) {
+	const op = "auth.DeviceAuthorization"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	scopes, err := requestedScopes(app, strings.Fields(scope))
+	if err != nil {
+		log.Info("invalid scope")
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deviceCode, err := oauth.NewCode()
+	if err != nil {
+		log.Error("failed to generate device code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	interval := models.Seconds(max(a.deviceInterval/time.Second, 1))
+	expiresAt := time.Now().Add(a.deviceCodeTTL)
+
+	// User codes are short, so a collision with a pending code is unlikely
+	// but possible.
+	for attempt := 1; ; attempt++ {
+		userCode, err := oauth.NewUserCode()
+		if err != nil {
+			log.Error("failed to generate user code", sl.Err(err))
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		err = a.devices.SaveDeviceCode(models.DeviceCode{
+			ID:        oauth.HashCode(deviceCode),
+			UserCode:  userCode,
+			AppID:     app.ID,
+			Scope:     strings.Join(scopes, " "),
+			State:     models.DeviceCodePending,
+			Interval:  interval,
+			ExpiresAt: expiresAt,
+		})
+		if errors.Is(err, storage.ErrDeviceCodeExists) && attempt < userCodeAttempts {
+			log.Warn("user code collision, retrying")
+			continue
+		}
+		if err != nil {
+			log.Error("failed to save device code", sl.Err(err))
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("device authorization started")
+
+		return models.DeviceAuthorization{
+			DeviceCode: deviceCode,
+			UserCode:   userCode,
+			ExpiresAt:  expiresAt,
+			Interval:   interval.Duration(),
+		}, nil
+	}
+}
+
+// DeviceApp returns the app a pending user code was issued for, so the
+// verification page can show the user what they are about to approve.
+func (a *Auth) DeviceApp(
+	userCode string,
+) (models.App, error) {
+	const op = "auth.DeviceApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	code, err := a.pendingDeviceCode(log, userCode)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(code.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidUserCode)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// VerifyDevice authenticates the user on the verification page, with the
+// code of their authenticator app if they turned on MFA, and approves or
+// denies the user code on their behalf.
+func (a *Auth) VerifyDevice(
+	userCode string,
+	email string,
+	password string,
+	mfaCode string,
+	approve bool,
+	client models.ClientInfo,
+) error {
+	const op = "auth.VerifyDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.checkPassword(log, email, password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailOrPassword)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkSecondFactor(log, user.ID, mfaCode, client); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.decideDevice(log, userCode, user.ID, approve); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ApproveDevice approves or denies the user code on behalf of the user the
+// access token belongs to. The token must have been issued for the app of
+// the device code, and to approve it must grant the requested scopes, so
+// that a token of one app or with narrow scopes can't hand out more.
+func (a *Auth) ApproveDevice(
+	accessToken string,
+	userCode string,
+	approve bool,
+) error {
+	const op = "auth.ApproveDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.pendingDeviceCode(log, userCode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if code.AppID != claims.AppID {
+		log.Warn("access token issued for another app",
+			slog.Int("token_app_id", int(claims.AppID)),
+			slog.Int("code_app_id", int(code.AppID)),
+		)
+		return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	if approve && !grantsScopes(claims.Scope, code.Scope) {
+		log.Warn("access token doesn't grant the requested scopes", slog.String("scope", code.Scope))
+		return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	if err := a.decideDevice(log, userCode, claims.UserID, approve); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExchangeDeviceCode is polled by the client until the user decided on the
+// device code, then it issues a token pair once.
+func (a *Auth) ExchangeDeviceCode(
+	appID int32,
+	deviceCode string,
+	client models.ClientInfo,
+) (jwt.TokenPair, error) {
+	const op = "auth.ExchangeDeviceCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	stored, err := a.devices.DeviceCode(oauth.HashCode(deviceCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("device code not found")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to get device code", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.AppID != appID {
+		log.Warn("device code issued for another app", slog.Int("code_app_id", int(stored.AppID)))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	now := time.Now()
+
+	if !now.Before(stored.ExpiresAt) {
+		log.Info("device code expired")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	switch stored.State {
+	case models.DeviceCodePending:
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, a.pollDeviceCode(log, stored, now))
+	case models.DeviceCodeDenied:
+		log.Info("device code denied by the user")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrAccessDenied)
+	case models.DeviceCodeApproved:
+	default:
+		log.Warn("device code already redeemed")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	if err := a.devices.RedeemDeviceCode(stored.ID); err != nil {
+		if errors.Is(err, storage.ErrCodeAlreadyUsed) {
+			log.Warn("device code already redeemed")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to redeem device code", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.UserID == nil || stored.AuthTime == nil {
+		log.Error("approved device code has no user")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	app, err := a.appProvider.App(stored.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(*stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.startSession(log, user, app, familyID, *stored.AuthTime, stored.Scope, client)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if hasScope(stored.Scope, scopeOpenID) {
+		policy := a.policy(app)
+		session := policy.session(familyID, *stored.AuthTime)
+
+		tokens.IDToken, err = jwt.NewIDToken(a.issuer, user, app, policy.accessTTL, session, "", tokens.AccessToken)
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("device code exchanged", slog.Int64("user_id", user.ID))
+
+	return tokens, nil
+}
+
+// pollDeviceCode records a poll of a pending code. A client polling faster
+// than its interval has to slow down for the rest of the flow.
+func (a *Auth) pollDeviceCode(log *slog.Logger, code models.DeviceCode, now time.Time) error {
+	result := ErrAuthorizationPending
+	interval := code.Interval
+
+	if code.LastPolledAt != nil && now.Sub(*code.LastPolledAt) < interval.Duration() {
+		result = ErrSlowDown
+		interval += models.Seconds(slowDownStep / time.Second)
+		log.Info("client polls too often", slog.Int64("interval", int64(interval)))
+	}
+
+	if err := a.devices.PollDeviceCode(code.ID, now, interval); err != nil {
+		log.Error("failed to record poll", sl.Err(err))
+		return err
+	}
+
+	return result
+}
+
+func (a *Auth) pendingDeviceCode(log *slog.Logger, userCode string) (models.DeviceCode, error) {
+	normalized := oauth.NormalizeUserCode(userCode)
+	if normalized == "" {
+		log.Info("malformed user code")
+		return models.DeviceCode{}, ErrInvalidUserCode
+	}
+
+	code, err := a.devices.DeviceCodeByUserCode(normalized)
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("user code not found")
+			return models.DeviceCode{}, ErrInvalidUserCode
+		}
+		log.Error("failed to get device code", sl.Err(err))
+
+		return models.DeviceCode{}, err
+	}
+
+	if code.State != models.DeviceCodePending || !time.Now().Before(code.ExpiresAt) {
+		log.Info("user code is not pending")
+		return models.DeviceCode{}, ErrInvalidUserCode
+	}
+
+	return code, nil
+}
+
+// decideDevice approves or denies the user code. Approving it grants the
+// requested scopes to the app.
+func (a *Auth) decideDevice(log *slog.Logger, userCode string, userID int64, approve bool) error {
+	code, err := a.pendingDeviceCode(log, userCode)
+	if err != nil {
+		return err
+	}
+
+	state := models.DeviceCodeDenied
+	if approve {
+		state = models.DeviceCodeApproved
+
+		if err := a.grantScopes(log, userID, code.AppID, strings.Fields(code.Scope)); err != nil {
+			return err
+		}
+	}
+
+	if err := a.devices.DecideDeviceCode(code.UserCode, state, userID, time.Now()); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("user code not found or not pending")
+			return ErrInvalidUserCode
+		}
+		log.Error("failed to decide device code", sl.Err(err))
+
+		return err
+	}
+
+	log.Info("device code decided", slog.Int64("user_id", userID), slog.String("state", state))
+
+	return nil
+}
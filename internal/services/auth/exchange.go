@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scopeTokenExchange is the client scope that allows a confidential client
+// to exchange the tokens it received for tokens for other apps.
+const scopeTokenExchange = "token_exchange"
+
+// ExchangeToken issues an access token for another app on behalf of the
+// subject of a valid access token, as in the token exchange grant (RFC 8693).
+// The actor is recorded in the act claim and is either a user with the
+// users:impersonate permission in the target app presenting an actor token
+// (impersonation by support staff) or a confidential client with the
+// token_exchange scope, which may only exchange tokens issued to itself for
+// itself or the apps listed in its exchange audiences (delegation between
+// services). The scopes can only be narrowed to the ones the target app
+// declares.
+func (a *Auth) ExchangeToken(
+	req models.TokenExchangeRequest,
+) (jwt.TokenPair, error) {
+	const op = "auth.ExchangeToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", req.ClientID),
+		slog.String("audience", req.Audience),
+	)
+
+	var client *models.App
+	if req.ClientID != "" || req.ClientSecret != "" {
+		app, err := a.authenticateClient(log, req.ClientID, req.ClientSecret)
+		if err != nil {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+		client = &app
+	}
+
+	subject, err := a.validateAccessToken(req.SubjectToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAccessToken) {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidSubjectToken)
+		}
+		log.Error("failed to validate subject token", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	targetID := subject.AppID
+	if req.Audience != "" {
+		id, err := strconv.ParseInt(req.Audience, 10, 32)
+		if err != nil {
+			log.Info("malformed audience")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidTarget)
+		}
+		targetID = int32(id)
+	}
+
+	target, err := a.appProvider.App(targetID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("target app not found")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidTarget)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var actor jwt.Actor
+	switch {
+	case req.ActorToken != "":
+		claims, err := a.authenticate(req.ActorToken)
+		if err != nil {
+			if errors.Is(err, ErrInvalidAccessToken) {
+				return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidActorToken)
+			}
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := a.checkPermission(log, claims.UserID, target.ID, models.PermissionImpersonateUsers); err != nil {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+		actor = jwt.UserActor(claims.UserID)
+	case client != nil:
+		if !slices.Contains(client.ClientScopes, scopeTokenExchange) {
+			log.Warn("client is not allowed to exchange tokens")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+		}
+		if subject.AppID != client.ID {
+			log.Warn("subject token issued for another app", slog.Int("token_app_id", int(subject.AppID)))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidSubjectToken)
+		}
+		if target.ID != client.ID && !slices.Contains(client.ExchangeAudiences, target.ID) {
+			log.Warn("client is not allowed to exchange tokens for the audience")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidTarget)
+		}
+		actor = jwt.ClientActor(*client)
+	default:
+		log.Info("neither client credentials nor actor token")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	scopes, err := narrowScopes(target, subject.AppID, strings.Fields(subject.Scope), req.Scopes)
+	if err != nil {
+		log.Info("requested scopes exceed the subject token or the audience")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var user models.User
+	if subject.UserID != 0 {
+		user, err = a.userProvider.UserByID(subject.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("subject token belongs to a deleted user")
+				return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidSubjectToken)
+			}
+			log.Error("failed to get user", sl.Err(err))
+
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// The exchanged token can't outlive the token it was exchanged for.
+	expiresAt := time.Now().Add(a.policy(target).accessTTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	roles, err := a.templateRoles(user, target)
+	if err != nil {
+		log.Error("failed to get user roles", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := jwt.NewExchangedToken(a.issuer, target, subject, user, roles, actor, scopes, expiresAt)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token exchanged",
+		slog.String("subject", subject.Subject),
+		slog.String("actor", actor.Subject),
+		slog.Int("target_app_id", int(target.ID)),
+	)
+
+	return tokens, nil
+}
+
+// narrowScopes returns the requested scopes if the subject token grants
+// them and the target app declares them. Tokens without scopes grant full
+// access, so any declared scopes narrow them. Without requested scopes the
+// scopes of the subject token are kept within its app. Tokens for another
+// app need requested scopes, so that full access to one app never turns
+// into full access to another.
+func narrowScopes(target models.App, subjectAppID int32, granted []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if target.ID == subjectAppID {
+			return granted, nil
+		}
+		return nil, ErrScopeRequired
+	}
+
+	for _, scope := range requested {
+		if len(granted) > 0 && !slices.Contains(granted, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	return requestedScopes(target, requested)
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/federation"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// federationStateTTL is how long the user has to sign in at the identity
+// provider.
+const federationStateTTL = 10 * time.Minute
+
+// Providers returns the names of the identity providers users can sign in
+// with, in a stable order.
+func (a *Auth) Providers() []string {
+	names := make([]string, 0, len(a.providers))
+	for name := range a.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// StartFederatedLogin keeps the authorization request of the client while
+// the user signs in at the identity provider and returns the URL of the
+// provider to redirect the user to.
+func (a *Auth) StartFederatedLogin(
+	ctx context.Context,
+	providerName string,
+	req models.AuthorizationRequest,
+	clientState string,
+) (string, error) {
+	const op = "auth.StartFederatedLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("provider", providerName),
+		slog.Int("app_id", int(req.AppID)),
+	)
+
+	provider, ok := a.providers[providerName]
+	if !ok {
+		log.Info("unknown identity provider")
+		return "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	app, err := a.AuthorizationApp(req.AppID, req.RedirectURI)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !oauth.ValidCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod) {
+		log.Info("invalid code challenge")
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCodeChallenge)
+	}
+
+	scopes, err := requestedScopes(app, strings.Fields(req.Scope))
+	if err != nil {
+		log.Info("invalid scope")
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := oauth.NewCode()
+		if err != nil {
+			log.Error("failed to generate federation state", sl.Err(err))
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oauth.CodeChallenge(verifier))
+	if err != nil {
+		log.Error("failed to build provider url", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, ErrFederationFailed)
+	}
+
+	err = a.identities.SaveFederationState(models.FederationState{
+		ID:                  oauth.HashCode(state),
+		Provider:            provider.Name,
+		Nonce:               nonce,
+		CodeVerifier:        verifier,
+		AppID:               req.AppID,
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Scope:               strings.Join(scopes, " "),
+		ClientNonce:         req.Nonce,
+		ClientState:         clientState,
+		ExpiresAt:           time.Now().Add(federationStateTTL),
+	})
+	if err != nil {
+		log.Error("failed to save federation state", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("federated login started")
+
+	return authURL, nil
+}
+
+// CompleteFederatedLogin handles the response of the identity provider:
+// it exchanges the code, links the identity to a local user and returns an
+// authorization code for the request the login was started with. An empty
+// code means the user didn't authorize at the provider. Users who turned on
+// MFA are refused, the provider can't vouch for their second factor.
+//
+// Once the state is known it is returned even with an error, so that the
+// error can be reported to the client.
+func (a *Auth) CompleteFederatedLogin(
+	ctx context.Context,
+	providerName string,
+	state string,
+	code string,
+	client models.ClientInfo,
+) (models.FederationState, string, error) {
+	const op = "auth.CompleteFederatedLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("provider", providerName),
+	)
+
+	stored, err := a.identities.UseFederationState(oauth.HashCode(state))
+	if err != nil {
+		if errors.Is(err, storage.ErrFederationStateNotFound) {
+			log.Info("federation state not found")
+			return models.FederationState{}, "", fmt.Errorf("%s: %w", op, ErrInvalidState)
+		}
+		log.Error("failed to use federation state", sl.Err(err))
+
+		return models.FederationState{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.Provider != providerName || !time.Now().Before(stored.ExpiresAt) {
+		log.Info("federation state expired or issued for another provider")
+		return models.FederationState{}, "", fmt.Errorf("%s: %w", op, ErrInvalidState)
+	}
+
+	log = log.With(slog.Int("app_id", int(stored.AppID)))
+
+	if code == "" {
+		log.Info("user did not authorize at the provider")
+		return stored, "", fmt.Errorf("%s: %w", op, ErrAccessDenied)
+	}
+
+	provider, ok := a.providers[providerName]
+	if !ok {
+		log.Warn("identity provider is no longer configured")
+		return stored, "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	identity, err := provider.Exchange(ctx, code, stored.CodeVerifier, stored.Nonce)
+	if err != nil {
+		log.Warn("failed to exchange code at the provider", sl.Err(err))
+		return stored, "", fmt.Errorf("%s: %w", op, ErrFederationFailed)
+	}
+
+	user, err := a.federatedUser(log, provider.Name, identity)
+	if err != nil {
+		return stored, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkSecondFactor(log, user.ID, "", client); err != nil {
+		return stored, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	req := stored.AuthorizationRequest()
+
+	app, err := a.AuthorizationApp(req.AppID, req.RedirectURI)
+	if err != nil {
+		return stored, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authCode, err := a.issueAuthorizationCode(log, app, user, req, client)
+	if err != nil {
+		return stored, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user authorized with identity provider", slog.Int64("user_id", user.ID))
+
+	return stored, authCode, nil
+}
+
+// federatedUser returns the local user of an external identity. Identities
+// seen before map to the user they were linked to. A new identity is linked
+// to the user with the same email, or a new user is created for it, but only
+// if the provider verified the email, otherwise anyone could take over an
+// account by registering its email at a provider. Users whose own email
+// isn't verified aren't linked either: whoever registered the email may not
+// own it and would keep access to the account through the password.
+func (a *Auth) federatedUser(log *slog.Logger, provider string, identity federation.Identity) (models.User, error) {
+	linked, err := a.identities.UserIdentity(provider, identity.Subject)
+	if err == nil {
+		user, err := a.userProvider.UserByID(linked.UserID)
+		if err != nil {
+			log.Error("failed to get linked user", sl.Err(err))
+			return models.User{}, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		log.Error("failed to get identity", sl.Err(err))
+		return models.User{}, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		log.Warn("identity has no verified email", slog.String("email", identity.Email))
+		return models.User{}, ErrUnverifiedEmail
+	}
+
+	user, err := a.userProvider.UserByEmail(identity.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified {
+			log.Warn("refusing to link identity to user with unverified email", slog.Int64("user_id", user.ID))
+			return models.User{}, ErrAccountNotVerified
+		}
+		log.Info("linking identity to existing user", slog.Int64("user_id", user.ID))
+	case errors.Is(err, storage.ErrUserNotFound):
+		user, err = a.createUserWithoutPassword(identity.Email)
+		if err != nil {
+			log.Error("failed to create user", sl.Err(err))
+			return models.User{}, err
+		}
+		log.Info("created user for identity", slog.Int64("user_id", user.ID))
+	default:
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, err
+	}
+
+	err = a.identities.SaveUserIdentity(models.UserIdentity{
+		Provider: provider,
+		Subject:  identity.Subject,
+		UserID:   user.ID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		log.Error("failed to link identity", sl.Err(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// createUserWithoutPassword registers a user that signs in with an identity
+// provider or a mailed login code. The password is random and never
+// revealed, so the user can't sign in with a password. The email is verified
+// by the provider or the mail.
+func (a *Auth) createUserWithoutPassword(email string) (models.User, error) {
+	passHash, err := unusablePasswordHash()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	id, err := a.userSaver.SaveUser(email, passHash)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := a.userSaver.SetEmailVerified(id, email); err != nil {
+		return models.User{}, err
+	}
+
+	return a.userProvider.UserByID(id)
+}
+
+// unusablePasswordHash hashes a random password that is never revealed.
+func unusablePasswordHash() ([]byte, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+
+	return bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+}
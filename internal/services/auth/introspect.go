@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+)
+
+// Introspect reports whether the access token is currently active, taking
+// revocations into account, in the spirit of RFC 7662. Only resource
+// servers may ask, see models.IntrospectionRequest. Tokens that can't be
+// verified are reported as inactive rather than as an error.
+func (a *Auth) Introspect(
+	req models.IntrospectionRequest,
+) (models.Introspection, error) {
+	const op = "auth.Introspect"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", req.ClientID),
+	)
+
+	if err := a.authenticateResourceServer(log, req); err != nil {
+		return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims, err := a.validateAccessToken(req.Token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAccessToken) {
+			return models.Introspection{Active: false}, nil
+		}
+		log.Error("failed to validate token", sl.Err(err))
+
+		return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if claims.UserID == 0 {
+		return introspection(claims, []string{}), nil
+	}
+
+	userRoles, err := a.roles.UserRoles(claims.UserID, claims.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("token belongs to a deleted user", slog.Int64("user_id", claims.UserID))
+			return models.Introspection{Active: false}, nil
+		}
+		log.Error("failed to get user roles", sl.Err(err))
+
+		return models.Introspection{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	roles := make([]string, 0, len(userRoles))
+	for _, role := range userRoles {
+		roles = append(roles, role.Name)
+	}
+
+	return introspection(claims, roles), nil
+}
+
+// authenticateResourceServer checks the client credentials of the caller,
+// or the access token the caller got for itself with them.
+func (a *Auth) authenticateResourceServer(log *slog.Logger, req models.IntrospectionRequest) error {
+	if req.ClientID != "" || req.ClientSecret != "" {
+		_, err := a.authenticateClient(log, req.ClientID, req.ClientSecret)
+		return err
+	}
+
+	if req.AccessToken == "" {
+		log.Info("caller is not authenticated")
+		return ErrInvalidClient
+	}
+
+	claims, err := a.validateAccessToken(req.AccessToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAccessToken) {
+			return ErrInvalidClient
+		}
+		log.Error("failed to validate caller token", sl.Err(err))
+
+		return err
+	}
+
+	if claims.UserID != 0 || claims.ClientID == "" {
+		log.Info("caller token does not belong to a client", slog.Int64("user_id", claims.UserID))
+		return ErrInvalidClient
+	}
+
+	return nil
+}
+
+func introspection(claims *jwt.Claims, roles []string) models.Introspection {
+	info := models.Introspection{
+		Active:    true,
+		TokenID:   claims.ID,
+		Subject:   claims.Subject,
+		UserID:    claims.UserID,
+		ClientID:  claims.ClientID,
+		AppID:     claims.AppID,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		TokenType: "Bearer",
+		Scopes:    strings.Fields(claims.Scope),
+		Roles:     roles,
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.Act != nil {
+		info.Actor = claims.Act.Subject
+	}
+
+	return info
+}
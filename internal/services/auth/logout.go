@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// Logout revokes the token family the refresh token belongs to, so neither
+// the refresh token nor access tokens issued with it can be used anymore.
+func (a *Auth) Logout(
+	refreshToken string,
+	appID int32,
+) error {
+	const op = "auth.Logout"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	log.Info("attempting to logout user")
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims, err := jwt.ValidateToken(a.issuer, app, refreshToken, true, a.revocations)
+	if err != nil {
+		log.Info("invalid refresh token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+	if claims.FamilyID == "" {
+		log.Warn("refresh token has no family")
+		return fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if err := a.tokenSaver.RevokeTokenFamily(claims.FamilyID); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Every token of the family expires within the app token lifetimes from
+	// now, so the denylist entry is not needed after that.
+	policy := a.policy(app)
+	if err := a.revocations.RevokeToken(claims.FamilyID, time.Now().Add(max(policy.accessTTL, policy.refreshTTL))); err != nil {
+		log.Error("failed to revoke tokens", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged out", slog.Int64("user_id", claims.UserID))
+
+	return nil
+}
+
+// LogoutAll revokes every session of the user the access token belongs to.
+func (a *Auth) LogoutAll(
+	accessToken string,
+) error {
+	const op = "auth.LogoutAll"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("attempting to logout user from all sessions")
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeUserTokens(log, claims.UserID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged out from all sessions", slog.Int64("user_id", claims.UserID))
+
+	return nil
+}
+
+// revokeUserTokens ends every session of the user and denies the tokens
+// issued before now.
+func (a *Auth) revokeUserTokens(log *slog.Logger, userID int64) error {
+	// iat has a precision of one second, so tokens issued later within the
+	// same second as the revocation must stay valid.
+	revokedAt := time.Now().Truncate(time.Second)
+
+	// Refresh tokens of the user are revoked in storage for good, the entry
+	// only has to outlive access tokens of any app.
+	err := a.revocations.RevokeUserTokens(userID, revokedAt, revokedAt.Add(max(a.tokenTTL, MaxAccessTTL)))
+	if err != nil {
+		log.Error("failed to revoke user tokens", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// PruneRevocations drops revocation entries and refresh tokens that are past
+// their expiry.
+func (a *Auth) PruneRevocations() error {
+	const op = "auth.PruneRevocations"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	pruned, err := a.revocations.PruneRevocations(time.Now())
+	if err != nil {
+		log.Error("failed to prune revocations", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Debug("pruned expired revocations", slog.Int64("count", pruned))
+
+	return nil
+}
@@ -0,0 +1,418 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/totp"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// Users get maxMFAFailures wrong codes in a row, after that every further
+// attempt has to wait mfaLockout, so that six digit codes can't be guessed.
+const (
+	maxMFAFailures = 5
+	mfaLockout     = 15 * time.Minute
+)
+
+// EnrollTOTP generates a new authenticator app secret for the user the
+// access token belongs to. MFA is only turned on once ConfirmTOTP gets a
+// code of the secret, until then enrolling again replaces it.
+func (a *Auth) EnrollTOTP(
+	accessToken string,
+) (models.TOTPEnrollment, error) {
+	const op = "auth.EnrollTOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if a.secrets == nil {
+		log.Warn("mfa encryption key is not configured")
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, ErrMFAUnavailable)
+	}
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	user, err := a.userProvider.UserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Error("failed to generate totp secret", sl.Err(err))
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sealed, err := a.secrets.Seal([]byte(secret))
+	if err != nil {
+		log.Error("failed to encrypt totp secret", sl.Err(err))
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfa.SaveTOTP(models.TOTP{UserID: user.ID, Secret: sealed}); err != nil {
+		if errors.Is(err, storage.ErrTOTPExists) {
+			log.Info("mfa already enabled")
+			return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, ErrMFAEnabled)
+		}
+		log.Error("failed to save totp", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enrolled")
+
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(a.mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP turns on MFA for the user the access token belongs to once the
+// code shows the authenticator app was set up with the enrolled secret, and
+// returns the recovery codes that can stand in for the app.
+func (a *Auth) ConfirmTOTP(
+	accessToken string,
+	code string,
+) ([]string, error) {
+	const op = "auth.ConfirmTOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	enrollment, err := a.mfa.TOTP(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			log.Info("totp not enrolled")
+			return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+		}
+		log.Error("failed to get totp", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if enrollment.ConfirmedAt != nil {
+		log.Info("mfa already enabled")
+		return nil, fmt.Errorf("%s: %w", op, ErrMFAEnabled)
+	}
+
+	secret, err := a.openTOTPSecret(log, enrollment)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		log.Info("invalid mfa code")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+
+	if err := a.mfa.ConfirmTOTP(claims.UserID, step, now); err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			log.Info("mfa already enabled")
+			return nil, fmt.Errorf("%s: %w", op, ErrMFAEnabled)
+		}
+		log.Error("failed to confirm totp", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	recoveryCodes, err := a.newRecoveryCodes(log, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("mfa enabled")
+
+	return recoveryCodes, nil
+}
+
+// VerifyMFA completes a login that Login answered with an MFA challenge
+// token, with a code of the authenticator app or a recovery code. Wrong codes
+// leave the challenge in place until it expires. A passkey can complete the
+// challenge too, see BeginPasskeyLogin.
+func (a *Auth) VerifyMFA(
+	mfaToken string,
+	code string,
+	client models.ClientInfo,
+) (jwt.TokenPair, error) {
+	const op = "auth.VerifyMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if mfaToken == "" {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+	}
+
+	challenge, err := a.activeMFAChallenge(log, oauth.HashCode(mfaToken))
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", challenge.UserID), slog.Int("app_id", int(challenge.AppID)))
+
+	if err := a.verifySecondFactor(log, challenge.UserID, code, client); err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.completeMFAChallenge(log, challenge, client)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with mfa")
+
+	return tokens, nil
+}
+
+// activeMFAChallenge returns the challenge with the ID unless it expired.
+func (a *Auth) activeMFAChallenge(log *slog.Logger, id string) (models.MFAChallenge, error) {
+	challenge, err := a.mfa.MFAChallenge(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Info("mfa challenge not found")
+			return models.MFAChallenge{}, ErrInvalidMFAToken
+		}
+		log.Error("failed to get mfa challenge", sl.Err(err))
+
+		return models.MFAChallenge{}, err
+	}
+
+	if !time.Now().Before(challenge.ExpiresAt) {
+		log.Info("mfa challenge expired")
+		return models.MFAChallenge{}, ErrInvalidMFAToken
+	}
+
+	return challenge, nil
+}
+
+// completeMFAChallenge ends the challenge once the second factor is
+// verified and starts the session of the login.
+func (a *Auth) completeMFAChallenge(log *slog.Logger, challenge models.MFAChallenge, client models.ClientInfo) (jwt.TokenPair, error) {
+	if err := a.mfa.DeleteMFAChallenge(challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Warn("mfa challenge already completed")
+			return jwt.TokenPair{}, ErrInvalidMFAToken
+		}
+		log.Error("failed to delete mfa challenge", sl.Err(err))
+
+		return jwt.TokenPair{}, err
+	}
+
+	user, err := a.userProvider.UserByID(challenge.UserID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+		return jwt.TokenPair{}, err
+	}
+
+	app, err := a.appProvider.App(challenge.AppID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return jwt.TokenPair{}, err
+	}
+
+	if err := a.grantScopes(log, user.ID, app.ID, strings.Fields(challenge.Scope)); err != nil {
+		return jwt.TokenPair{}, err
+	}
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return jwt.TokenPair{}, err
+	}
+
+	return a.startSession(log, user, app, familyID, challenge.AuthTime, challenge.Scope, client)
+}
+
+// mfaChallenge returns a challenge token to complete the login with
+// VerifyMFA if the user turned on MFA, and an empty one otherwise.
+func (a *Auth) mfaChallenge(log *slog.Logger, user models.User, app models.App, scope string) (string, error) {
+	enabled, err := a.mfaEnabled(log, user.ID)
+	if err != nil || !enabled {
+		return "", err
+	}
+
+	token, err := oauth.NewCode()
+	if err != nil {
+		log.Error("failed to generate mfa token", sl.Err(err))
+		return "", err
+	}
+
+	now := time.Now()
+
+	err = a.mfa.SaveMFAChallenge(models.MFAChallenge{
+		ID:        oauth.HashCode(token),
+		UserID:    user.ID,
+		AppID:     app.ID,
+		Scope:     scope,
+		AuthTime:  now,
+		ExpiresAt: now.Add(a.mfaChallengeTTL),
+	})
+	if err != nil {
+		log.Error("failed to save mfa challenge", sl.Err(err))
+		return "", err
+	}
+
+	return token, nil
+}
+
+// checkSecondFactor verifies the code entered together with the password
+// on the HTML pages, if the user turned on MFA.
+func (a *Auth) checkSecondFactor(log *slog.Logger, userID int64, code string, client models.ClientInfo) error {
+	enabled, err := a.mfaEnabled(log, userID)
+	if err != nil || !enabled {
+		return err
+	}
+
+	if code == "" {
+		log.Info("mfa required", slog.Int64("user_id", userID))
+		return ErrMFARequired
+	}
+
+	return a.verifySecondFactor(log, userID, code, client)
+}
+
+func (a *Auth) mfaEnabled(log *slog.Logger, userID int64) (bool, error) {
+	enrollment, err := a.mfa.TOTP(userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+		log.Error("failed to get totp", sl.Err(err))
+
+		return false, err
+	}
+
+	return enrollment.ConfirmedAt != nil, nil
+}
+
+// verifySecondFactor checks a code of the authenticator app or a recovery
+// code of the user. Every code can be used once.
+func (a *Auth) verifySecondFactor(log *slog.Logger, userID int64, code string, client models.ClientInfo) error {
+	enrollment, err := a.mfa.TOTP(userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return ErrMFANotEnrolled
+		}
+		log.Error("failed to get totp", sl.Err(err))
+
+		return err
+	}
+
+	if enrollment.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	now := time.Now()
+
+	if enrollment.FailedAttempts >= maxMFAFailures && enrollment.LastFailedAt != nil && now.Sub(*enrollment.LastFailedAt) < mfaLockout {
+		log.Warn("too many wrong mfa codes")
+		return ErrMFALocked
+	}
+
+	var ok bool
+	if isTOTPCode(code) {
+		ok, err = a.useTOTPCode(log, enrollment, code, now)
+	} else {
+		ok, err = a.useRecoveryCode(log, userID, code, client)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if err := a.mfa.FailTOTP(userID, now); err != nil {
+			log.Error("failed to count wrong mfa code", sl.Err(err))
+			return err
+		}
+		log.Info("invalid mfa code")
+
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// useTOTPCode redeems a code of the authenticator app. It reports false if
+// the code is wrong or was used before.
+func (a *Auth) useTOTPCode(log *slog.Logger, enrollment models.TOTP, code string, now time.Time) (bool, error) {
+	secret, err := a.openTOTPSecret(log, enrollment)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return false, nil
+	}
+
+	if err := a.mfa.UseTOTPStep(enrollment.UserID, step); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			log.Warn("mfa code reused")
+			return false, nil
+		}
+		log.Error("failed to use totp step", sl.Err(err))
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *Auth) openTOTPSecret(log *slog.Logger, enrollment models.TOTP) (string, error) {
+	if a.secrets == nil {
+		log.Error("mfa encryption key is not configured")
+		return "", ErrMFAUnavailable
+	}
+
+	secret, err := a.secrets.Open(enrollment.Secret)
+	if err != nil {
+		log.Error("failed to decrypt totp secret", sl.Err(err))
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// isTOTPCode tells codes of the authenticator app from recovery codes.
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
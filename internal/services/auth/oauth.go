@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// AuthorizationApp returns the app an OAuth authorization request is made
+// for. The redirect URI must be registered for the app, otherwise errors
+// can't be reported back to the client.
+func (a *Auth) AuthorizationApp(
+	appID int32,
+	redirectURI string,
+) (models.App, error) {
+	const op = "auth.AuthorizationApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	app, err := a.appProvider.App(appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !app.HasRedirectURI(redirectURI) {
+		log.Warn("redirect uri is not registered", slog.String("redirect_uri", redirectURI))
+		return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+	}
+
+	return app, nil
+}
+
+// Authorize authenticates the user for an OAuth authorization request and
+// returns a single-use authorization code bound to the request. Users who
+// turned on MFA enter the code of their authenticator app as well.
+func (a *Auth) Authorize(
+	req models.AuthorizationRequest,
+	email string,
+	password string,
+	mfaCode string,
+	client models.ClientInfo,
+) (string, error) {
+	const op = "auth.Authorize"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(req.AppID)),
+		slog.String("email", email),
+	)
+
+	log.Info("attempting to authorize user")
+
+	app, err := a.AuthorizationApp(req.AppID, req.RedirectURI)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !oauth.ValidCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod) {
+		log.Info("invalid code challenge")
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCodeChallenge)
+	}
+
+	scopes, err := requestedScopes(app, strings.Fields(req.Scope))
+	if err != nil {
+		log.Info("invalid scope")
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	req.Scope = strings.Join(scopes, " ")
+
+	user, err := a.checkPassword(log, email, password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidEmailOrPassword)
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkSecondFactor(log, user.ID, mfaCode, client); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.issueAuthorizationCode(log, app, user, req, client)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user authorized", slog.Int64("user_id", user.ID))
+
+	return code, nil
+}
+
+// issueAuthorizationCode records the consent of the authenticated user to
+// the requested scopes and returns a single-use code bound to the
+// authorization request.
+func (a *Auth) issueAuthorizationCode(
+	log *slog.Logger,
+	app models.App,
+	user models.User,
+	req models.AuthorizationRequest,
+	client models.ClientInfo,
+) (string, error) {
+	if err := requireVerifiedEmail(log, user, app); err != nil {
+		return "", err
+	}
+
+	if err := a.grantScopes(log, user.ID, app.ID, strings.Fields(req.Scope)); err != nil {
+		return "", err
+	}
+
+	code, err := oauth.NewCode()
+	if err != nil {
+		log.Error("failed to generate code", sl.Err(err))
+		return "", err
+	}
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return "", err
+	}
+
+	now := time.Now()
+
+	err = a.codes.SaveAuthorizationCode(models.AuthorizationCode{
+		ID:                  oauth.HashCode(code),
+		AppID:               app.ID,
+		UserID:              user.ID,
+		FamilyID:            familyID,
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		IP:                  client.IP,
+		UserAgent:           client.UserAgent,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(a.codeTTL),
+	})
+	if err != nil {
+		log.Error("failed to save authorization code", sl.Err(err))
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeCode redeems an authorization code for a token pair. The code
+// verifier must match the code challenge of the authorization request.
+func (a *Auth) ExchangeCode(
+	appID int32,
+	code string,
+	redirectURI string,
+	codeVerifier string,
+) (jwt.TokenPair, error) {
+	const op = "auth.ExchangeCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	stored, err := a.codes.UseAuthorizationCode(oauth.HashCode(code), time.Now())
+	if err != nil {
+		if errors.Is(err, storage.ErrCodeNotFound) {
+			log.Info("authorization code not found")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		if errors.Is(err, storage.ErrCodeAlreadyUsed) {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, a.handleCodeReuse(log, stored))
+		}
+		log.Error("failed to use authorization code", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch {
+	case !time.Now().Before(stored.ExpiresAt):
+		log.Info("authorization code expired")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	case stored.AppID != appID:
+		log.Warn("authorization code issued for another app", slog.Int("code_app_id", int(stored.AppID)))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	case stored.RedirectURI != redirectURI:
+		log.Warn("redirect uri does not match the authorization request")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	case !oauth.VerifyCodeChallenge(stored.CodeChallenge, stored.CodeChallengeMethod, codeVerifier):
+		log.Warn("code verifier does not match the code challenge")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	app, err := a.appProvider.App(stored.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	client := models.ClientInfo{
+		IP:        stored.IP,
+		UserAgent: stored.UserAgent,
+	}
+
+	tokens, err := a.startSession(log, user, app, stored.FamilyID, stored.AuthTime, stored.Scope, client)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if hasScope(stored.Scope, scopeOpenID) {
+		policy := a.policy(app)
+		session := policy.session(stored.FamilyID, stored.AuthTime)
+
+		tokens.IDToken, err = jwt.NewIDToken(a.issuer, user, app, policy.accessTTL, session, stored.Nonce, tokens.AccessToken)
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged", slog.Int64("user_id", user.ID))
+
+	return tokens, nil
+}
+
+// handleCodeReuse revokes the tokens issued for an authorization code that
+// was presented again, as recommended by RFC 6749 section 4.1.2.
+func (a *Auth) handleCodeReuse(log *slog.Logger, code models.AuthorizationCode) error {
+	log.Warn("security event: authorization code reuse detected",
+		slog.String("event", "authorization_code_reuse"),
+		slog.String("family_id", code.FamilyID),
+		slog.Int64("user_id", code.UserID),
+		slog.Int("app_id", int(code.AppID)),
+	)
+
+	if err := a.tokenSaver.RevokeTokenFamily(code.FamilyID); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+		return err
+	}
+
+	// Access tokens of the family can't outlive any app's access tokens.
+	if err := a.revocations.RevokeToken(code.FamilyID, time.Now().Add(max(a.tokenTTL, MaxAccessTTL))); err != nil {
+		log.Error("failed to revoke tokens", sl.Err(err))
+		return err
+	}
+
+	return ErrInvalidGrant
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strings"
+)
+
+// scopeOpenID marks an OpenID Connect authentication request.
+const scopeOpenID = "openid"
+
+// UserInfo returns the user the access token belongs to for the OpenID
+// Connect userinfo endpoint.
+func (a *Auth) UserInfo(
+	accessToken string,
+) (models.User, error) {
+	const op = "auth.UserInfo"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticateUser(accessToken)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("token belongs to a deleted user", slog.Int64("user_id", claims.UserID))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// hasScope reports whether the space separated scope list contains scope.
+func hasScope(scopes string, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,463 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/webauthn"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BeginPasskeyRegistration starts the registration of a passkey for the user
+// the access token belongs to, scoped to the relying party of the app the
+// token was issued for. It returns the options to pass to
+// navigator.credentials.create() as JSON.
+func (a *Auth) BeginPasskeyRegistration(
+	accessToken string,
+) (string, error) {
+	const op = "auth.BeginPasskeyRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID), slog.Int("app_id", int(claims.AppID)))
+
+	app, err := a.appProvider.App(claims.AppID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	rp, err := relyingParty(log, app)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Registered passkeys are excluded, so that the same authenticator isn't
+	// registered twice.
+	registered, err := a.passkeyIDs(log, user.ID, rp.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	challenge, err := a.startCeremony(log, models.WebAuthnCeremony{
+		Purpose: models.WebAuthnRegister,
+		UserID:  &user.ID,
+		AppID:   app.ID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	options := webauthn.NewCreationOptions(rp, webauthn.User{
+		ID:          []byte(userHandle(user.ID)),
+		Name:        user.Email,
+		DisplayName: user.Email,
+	}, challenge, registered, a.webAuthnTimeout)
+
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registration started")
+
+	return string(optionsJSON), nil
+}
+
+// FinishPasskeyRegistration stores the passkey created with the options of
+// BeginPasskeyRegistration. The credential is the PublicKeyCredential
+// serialized with toJSON().
+func (a *Auth) FinishPasskeyRegistration(
+	accessToken string,
+	credentialJSON string,
+	name string,
+) error {
+	const op = "auth.FinishPasskeyRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID), slog.Int("app_id", int(claims.AppID)))
+
+	credential, ceremony, err := a.useCeremony(log, credentialJSON)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ceremony.Purpose != models.WebAuthnRegister || ceremony.UserID == nil ||
+		*ceremony.UserID != claims.UserID || ceremony.AppID != claims.AppID {
+		log.Warn("passkey registration of another ceremony")
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	app, err := a.appProvider.App(ceremony.AppID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	rp, err := relyingParty(log, app)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	registration, err := credential.VerifyRegistration(rp, credential.Challenge())
+	if err != nil {
+		log.Info("invalid passkey registration", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	err = a.webAuthn.SaveWebAuthnCredential(models.WebAuthnCredential{
+		ID:        registration.CredentialID,
+		UserID:    claims.UserID,
+		RPID:      rp.ID,
+		PublicKey: registration.PublicKey,
+		SignCount: int64(registration.SignCount),
+		Name:      name,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCredentialExists) {
+			log.Info("passkey already registered")
+			return fmt.Errorf("%s: %w", op, ErrPasskeyExists)
+		}
+		log.Error("failed to save passkey", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registered")
+
+	return nil
+}
+
+// BeginPasskeyLogin starts a login with a passkey and returns the options to
+// pass to navigator.credentials.get() as JSON. Without an MFA token it is a
+// passwordless login to the app with any discoverable passkey, which has to
+// verify the user. With the MFA token of a password login, a passkey of that
+// user completes the MFA challenge instead of a code.
+func (a *Auth) BeginPasskeyLogin(
+	appID int32,
+	scopes []string,
+	mfaToken string,
+) (string, error) {
+	const op = "auth.BeginPasskeyLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	ceremony := models.WebAuthnCeremony{
+		Purpose: models.WebAuthnLogin,
+		AppID:   appID,
+	}
+
+	if mfaToken != "" {
+		challenge, err := a.activeMFAChallenge(log, oauth.HashCode(mfaToken))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		ceremony = models.WebAuthnCeremony{
+			Purpose:        models.WebAuthnMFA,
+			UserID:         &challenge.UserID,
+			AppID:          challenge.AppID,
+			Scope:          challenge.Scope,
+			MFAChallengeID: challenge.ID,
+		}
+	}
+
+	log = log.With(slog.Int("app_id", int(ceremony.AppID)))
+
+	app, err := a.appProvider.App(ceremony.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	rp, err := relyingParty(log, app)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var allowed []string
+	if ceremony.Purpose == models.WebAuthnMFA {
+		allowed, err = a.passkeyIDs(log, *ceremony.UserID, rp.ID)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		if len(allowed) == 0 {
+			log.Info("user has no passkeys", slog.Int64("user_id", *ceremony.UserID))
+			return "", fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+		}
+	} else {
+		scopes, err = requestedScopes(app, scopes)
+		if err != nil {
+			log.Info("invalid scope")
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		ceremony.Scope = strings.Join(scopes, " ")
+	}
+
+	challenge, err := a.startCeremony(log, ceremony)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	options := webauthn.NewRequestOptions(rp, challenge, allowed, ceremony.Purpose == models.WebAuthnLogin, a.webAuthnTimeout)
+
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey login started", slog.String("purpose", ceremony.Purpose))
+
+	return string(optionsJSON), nil
+}
+
+// FinishPasskeyLogin verifies the assertion of a passkey for a ceremony
+// started with BeginPasskeyLogin and starts the session. The credential is
+// the PublicKeyCredential serialized with toJSON().
+func (a *Auth) FinishPasskeyLogin(
+	credentialJSON string,
+	client models.ClientInfo,
+) (jwt.TokenPair, error) {
+	const op = "auth.FinishPasskeyLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	credential, ceremony, err := a.useCeremony(log, credentialJSON)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ceremony.Purpose != models.WebAuthnLogin && ceremony.Purpose != models.WebAuthnMFA {
+		log.Warn("passkey login of another ceremony")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	log = log.With(slog.Int("app_id", int(ceremony.AppID)))
+
+	app, err := a.appProvider.App(ceremony.AppID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rp, err := relyingParty(log, app)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stored, err := a.webAuthn.WebAuthnCredential(credential.CredentialID())
+	if err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+			log.Info("passkey not found")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+		log.Error("failed to get passkey", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", stored.UserID))
+
+	switch {
+	case stored.RPID != rp.ID:
+		log.Info("passkey of another relying party")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	case ceremony.UserID != nil && *ceremony.UserID != stored.UserID:
+		log.Warn("passkey of another user")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	case len(credential.UserHandle()) > 0 && string(credential.UserHandle()) != userHandle(stored.UserID):
+		log.Warn("user handle doesn't match the passkey")
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	// A passwordless login is only multi-factor if the authenticator
+	// verified the user.
+	requireUserVerification := ceremony.Purpose == models.WebAuthnLogin
+
+	assertion, err := credential.VerifyAssertion(rp, credential.Challenge(), stored.PublicKey, requireUserVerification)
+	if err != nil {
+		log.Info("invalid passkey assertion", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	now := time.Now()
+
+	if err := a.webAuthn.UseWebAuthnCredential(stored.ID, int64(assertion.SignCount), now); err != nil {
+		if errors.Is(err, storage.ErrWebAuthnSignCount) {
+			log.Warn("passkey sign count did not increase, the authenticator may be cloned")
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+		log.Error("failed to update passkey", sl.Err(err))
+
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ceremony.Purpose == models.WebAuthnMFA {
+		challenge, err := a.activeMFAChallenge(log, ceremony.MFAChallengeID)
+		if err != nil {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		tokens, err := a.completeMFAChallenge(log, challenge, client)
+		if err != nil {
+			return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("user logged in with passkey as second factor")
+
+		return tokens, nil
+	}
+
+	user, err := a.userProvider.UserByID(stored.UserID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.grantScopes(log, user.ID, app.ID, strings.Fields(ceremony.Scope)); err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.startSession(log, user, app, familyID, now, ceremony.Scope, client)
+	if err != nil {
+		return jwt.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with passkey")
+
+	return tokens, nil
+}
+
+// startCeremony stores the ceremony under the hash of a new challenge and
+// returns the challenge.
+func (a *Auth) startCeremony(log *slog.Logger, ceremony models.WebAuthnCeremony) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		log.Error("failed to generate webauthn challenge", sl.Err(err))
+		return "", err
+	}
+
+	ceremony.ID = oauth.HashCode(challenge)
+	ceremony.ExpiresAt = time.Now().Add(a.webAuthnTimeout)
+
+	if err := a.webAuthn.SaveWebAuthnCeremony(ceremony); err != nil {
+		log.Error("failed to save webauthn ceremony", sl.Err(err))
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// useCeremony parses the credential and redeems the ceremony of the
+// challenge it signed.
+func (a *Auth) useCeremony(log *slog.Logger, credentialJSON string) (*webauthn.Credential, models.WebAuthnCeremony, error) {
+	credential, err := webauthn.ParseCredential([]byte(credentialJSON))
+	if err != nil || credential.Challenge() == "" {
+		log.Info("invalid passkey credential")
+		return nil, models.WebAuthnCeremony{}, ErrInvalidPasskey
+	}
+
+	ceremony, err := a.webAuthn.UseWebAuthnCeremony(oauth.HashCode(credential.Challenge()))
+	if err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCeremonyNotFound) {
+			log.Info("webauthn ceremony not found")
+			return nil, models.WebAuthnCeremony{}, ErrInvalidPasskey
+		}
+		log.Error("failed to use webauthn ceremony", sl.Err(err))
+
+		return nil, models.WebAuthnCeremony{}, err
+	}
+
+	if !time.Now().Before(ceremony.ExpiresAt) {
+		log.Info("webauthn ceremony expired")
+		return nil, models.WebAuthnCeremony{}, ErrInvalidPasskey
+	}
+
+	return credential, ceremony, nil
+}
+
+// passkeyIDs returns the IDs of the passkeys of the user for the relying
+// party.
+func (a *Auth) passkeyIDs(log *slog.Logger, userID int64, rpID string) ([]string, error) {
+	credentials, err := a.webAuthn.UserWebAuthnCredentials(userID, rpID)
+	if err != nil {
+		log.Error("failed to get passkeys", sl.Err(err))
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(credentials))
+	for _, credential := range credentials {
+		ids = append(ids, credential.ID)
+	}
+
+	return ids, nil
+}
+
+// relyingParty returns the WebAuthn relying party of the app, named after
+// the app.
+func relyingParty(log *slog.Logger, app models.App) (webauthn.RelyingParty, error) {
+	if app.WebAuthnRPID == nil || *app.WebAuthnRPID == "" || len(app.WebAuthnOrigins) == 0 {
+		log.Info("passkeys are not configured for the app")
+		return webauthn.RelyingParty{}, ErrPasskeysUnavailable
+	}
+
+	return webauthn.RelyingParty{
+		ID:      *app.WebAuthnRPID,
+		Name:    app.Name,
+		Origins: app.WebAuthnOrigins,
+	}, nil
+}
+
+// userHandle is the user ID authenticators store with discoverable
+// passkeys.
+func userHandle(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/storage"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RequestPasswordReset mails a password reset token to the user with the
+// email. Unknown emails succeed the same way, so that the call can't be used
+// to find out who is registered. Requests for an email and from the client
+// IP are rate limited whether or not the email is registered.
+func (a *Auth) RequestPasswordReset(
+	email string,
+	client models.ClientInfo,
+) error {
+	const op = "auth.RequestPasswordReset"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	if client.IP != "" {
+		if err := a.throttle(log, "password_reset:ip:"+client.IP, a.passwordResetIPLimit); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := a.throttle(log, "password_reset:email:"+strings.ToLower(email), a.passwordResetEmailLimit); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByEmail(email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return nil
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.sendPasswordReset(log, user); err != nil {
+		log.Error("failed to send password reset mail", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the reset token was mailed
+// to and ends all of their sessions. Tokens can be used once and only while
+// the user still has that email.
+func (a *Auth) ResetPassword(
+	token string,
+	newPassword string,
+) error {
+	const op = "auth.ResetPassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	stored, err := a.useEmailToken(log, token, models.EmailTokenResetPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", stored.UserID))
+
+	user, err := a.userProvider.UserByID(stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailToken)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Email != stored.Email {
+		log.Info("user changed the email")
+		return fmt.Errorf("%s: %w", op, ErrInvalidEmailToken)
+	}
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error("failed to generate hash", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.userSaver.UpdatePassword(user.ID, passHash); err != nil {
+		log.Error("failed to update password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Other reset mails sent before must not be able to change the password
+	// once more.
+	if err := a.emailTokens.DeleteEmailTokens(user.ID, models.EmailTokenResetPassword); err != nil {
+		log.Error("failed to delete reset tokens", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeUserTokens(log, user.ID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Following the link proves the user owns the email.
+	if !user.EmailVerified {
+		if err := a.userSaver.SetEmailVerified(user.ID, user.Email); err != nil {
+			log.Error("failed to mark email verified", sl.Err(err))
+		}
+	}
+
+	log.Info("password reset")
+
+	return nil
+}
+
+// ChangePassword sets a new password for the user the access token belongs
+// to once the current one is confirmed, and ends all other sessions of the
+// user.
+func (a *Auth) ChangePassword(
+	accessToken string,
+	currentPassword string,
+	newPassword string,
+) error {
+	const op = "auth.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, user, err := a.confirmPassword(log, accessToken, currentPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error("failed to generate hash", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.userSaver.UpdatePassword(user.ID, passHash); err != nil {
+		log.Error("failed to update password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// A reset link mailed before must not undo the change.
+	if err := a.emailTokens.DeleteEmailTokens(user.ID, models.EmailTokenResetPassword); err != nil {
+		log.Error("failed to delete reset tokens", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeOtherSessions(log, user.ID, claims.FamilyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed")
+
+	return nil
+}
+
+// confirmPassword authenticates the access token and checks the password of
+// the user it belongs to, for changes that need more than a token.
+func (a *Auth) confirmPassword(log *slog.Logger, accessToken string, password string) (*jwt.Claims, models.User, error) {
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, models.User{}, err
+	}
+
+	user, err := a.userProvider.UserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, models.User{}, ErrInvalidAccessToken
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return nil, models.User{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+		log.Info("invalid password", slog.Int64("user_id", user.ID))
+		return nil, models.User{}, ErrInvalidPassword
+	}
+
+	return claims, user, nil
+}
+
+// sendPasswordReset mails a link with a new reset token to the user.
+func (a *Auth) sendPasswordReset(log *slog.Logger, user models.User) error {
+	token, expiresAt, err := a.newEmailToken(user, models.EmailTokenResetPassword, a.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	err = a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Choose a new password by opening the link below:\n\n%s\n\nThe link expires at %s. If you didn't ask to reset your password, ignore this email.\n",
+			a.accountURL("/reset_password", token),
+			expiresAt.UTC().Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("password reset mail sent", slog.Int64("user_id", user.ID))
+
+	return nil
+}
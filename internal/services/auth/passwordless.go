@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+const (
+	loginCodeDigits  = 6
+	loginCodeModulus = 1_000_000 // 10^loginCodeDigits
+
+	// maxLoginCodeAttempts wrong codes end the login, so that six digit
+	// codes can't be guessed.
+	maxLoginCodeAttempts = 5
+)
+
+// StartPasswordlessLogin mails a login code or a login link to the email and
+// returns the login token that CompletePasswordlessLogin takes together with
+// the code. Unknown emails are mailed as well, completing the login
+// registers the user. The codes mailed to an email and requested from the
+// client IP are rate limited.
+func (a *Auth) StartPasswordlessLogin(
+	req models.PasswordlessRequest,
+) (string, error) {
+	const op = "auth.StartPasswordlessLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", req.Email),
+		slog.Int("app_id", int(req.AppID)),
+	)
+
+	app, err := a.appProvider.App(req.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+		log.Error("failed to get app", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	scopes, err := requestedScopes(app, req.Scopes)
+	if err != nil {
+		log.Info("invalid scope")
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if key := req.Client.IP; key != "" {
+		if err := a.throttle(log, "passwordless:ip:"+key, a.passwordlessIPLimit); err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := a.throttle(log, "passwordless:email:"+strings.ToLower(req.Email), a.passwordlessEmailLimit); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var code string
+	switch req.Method {
+	case models.PasswordlessCode:
+		code, err = newLoginCode()
+	case models.PasswordlessLink:
+		if !app.HasRedirectURI(req.RedirectURI) {
+			log.Info("redirect uri is not registered", slog.String("redirect_uri", req.RedirectURI))
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+		}
+		// Links aren't typed in, so they carry a long code.
+		code, err = oauth.NewCode()
+	default:
+		log.Info("invalid login method", slog.String("method", req.Method))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidLoginMethod)
+	}
+	if err != nil {
+		log.Error("failed to generate login code", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	loginToken, err := oauth.NewCode()
+	if err != nil {
+		log.Error("failed to generate login token", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	expiresAt := time.Now().Add(a.passwordlessTTL)
+
+	err = a.passwordless.SavePasswordlessLogin(models.PasswordlessLogin{
+		ID:        oauth.HashCode(loginToken),
+		Email:     req.Email,
+		AppID:     app.ID,
+		Scope:     strings.Join(scopes, " "),
+		CodeHash:  oauth.HashCode(code),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Error("failed to save passwordless login", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	msg := mail.Message{
+		To:      req.Email,
+		Subject: "Your sign-in code for " + app.Name,
+		Body: fmt.Sprintf(
+			"Enter this code to sign in to %s:\n\n%s\n\nThe code expires at %s. If you didn't try to sign in, ignore this email.\n",
+			app.Name, code, expiresAt.UTC().Format(time.RFC1123),
+		),
+	}
+	if req.Method == models.PasswordlessLink {
+		msg.Subject = "Sign in to " + app.Name
+		msg.Body = fmt.Sprintf(
+			"Sign in to %s by opening the link below:\n\n%s\n\nThe link expires at %s. If you didn't try to sign in, ignore this email.\n",
+			app.Name, loginLink(req.RedirectURI, loginToken, code), expiresAt.UTC().Format(time.RFC1123),
+		)
+	}
+
+	if err := a.mailer.Send(msg); err != nil {
+		log.Error("failed to send login mail", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passwordless login started", slog.String("method", req.Method))
+
+	return loginToken, nil
+}
+
+// CompletePasswordlessLogin signs in with the code mailed for the login
+// token. Codes can be used once, and the login ends after
+// maxLoginCodeAttempts wrong codes. Like Login it returns an MFA challenge
+// token instead of tokens if the user turned on MFA.
+func (a *Auth) CompletePasswordlessLogin(
+	loginToken string,
+	code string,
+	client models.ClientInfo,
+) (jwt.TokenPair, string, error) {
+	const op = "auth.CompletePasswordlessLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if loginToken == "" {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+	}
+
+	login, err := a.passwordless.AttemptPasswordlessLogin(oauth.HashCode(loginToken), maxLoginCodeAttempts)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasswordlessLoginNotFound) {
+			log.Info("passwordless login not found or attempts exhausted")
+			return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+		}
+		log.Error("failed to get passwordless login", sl.Err(err))
+
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.String("email", login.Email), slog.Int("app_id", int(login.AppID)))
+
+	if !time.Now().Before(login.ExpiresAt) {
+		log.Info("passwordless login expired")
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+	}
+
+	codeHash := oauth.HashCode(strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(login.CodeHash)) != 1 {
+		log.Info("invalid login code", slog.Int("attempts", login.Attempts))
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+	}
+
+	if err := a.passwordless.DeletePasswordlessLogin(login.ID); err != nil {
+		if errors.Is(err, storage.ErrPasswordlessLoginNotFound) {
+			log.Warn("passwordless login already completed")
+			return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, ErrInvalidLoginCode)
+		}
+		log.Error("failed to delete passwordless login", sl.Err(err))
+
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.passwordlessUser(log, login.Email)
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	app, err := a.appProvider.App(login.AppID)
+	if err != nil {
+		log.Error("failed to get app", sl.Err(err))
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfaToken, err := a.mfaChallenge(log, user, app, login.Scope)
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if mfaToken != "" {
+		log.Info("mfa required")
+		return jwt.TokenPair{}, mfaToken, nil
+	}
+
+	if err := a.grantScopes(log, user.ID, app.ID, strings.Fields(login.Scope)); err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	familyID, err := jwt.NewTokenID()
+	if err != nil {
+		log.Error("failed to generate token family", sl.Err(err))
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.startSession(log, user, app, familyID, time.Now(), login.Scope, client)
+	if err != nil {
+		return jwt.TokenPair{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in without password")
+
+	return tokens, "", nil
+}
+
+// passwordlessUser returns the user with the email, registering a new one
+// if there is none. The code proves the user owns the email. A user whose
+// email wasn't verified yet may have been registered by someone else, so the
+// account is claimed: its password, sessions and second factors are reset
+// before the owner of the email gets in.
+func (a *Auth) passwordlessUser(log *slog.Logger, email string) (models.User, error) {
+	user, err := a.userProvider.UserByEmail(email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		user, err = a.createUserWithoutPassword(email)
+		if errors.Is(err, storage.ErrUserExists) {
+			// Registered concurrently.
+			user, err = a.userProvider.UserByEmail(email)
+		}
+		if err != nil {
+			log.Error("failed to create user", sl.Err(err))
+			return models.User{}, err
+		}
+		log.Info("created user for passwordless login", slog.Int64("user_id", user.ID))
+	}
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, err
+	}
+
+	if !user.EmailVerified {
+		if err := a.claimUser(log, user); err != nil {
+			return models.User{}, err
+		}
+		user.EmailVerified = true
+	}
+
+	return user, nil
+}
+
+// claimUser hands a user with an unverified email over to the owner of the
+// email: whatever the registrant set up is dropped and the email is marked
+// verified.
+func (a *Auth) claimUser(log *slog.Logger, user models.User) error {
+	log.Info("claiming user with unverified email", slog.Int64("user_id", user.ID))
+
+	passHash, err := unusablePasswordHash()
+	if err != nil {
+		log.Error("failed to generate hash", sl.Err(err))
+		return err
+	}
+
+	if err := a.userSaver.ResetCredentials(user.ID, passHash); err != nil {
+		log.Error("failed to reset credentials", sl.Err(err))
+		return err
+	}
+
+	if err := a.emailTokens.DeleteEmailTokens(user.ID, models.EmailTokenResetPassword); err != nil {
+		log.Error("failed to delete reset tokens", sl.Err(err))
+		return err
+	}
+
+	if err := a.revokeUserTokens(log, user.ID); err != nil {
+		return err
+	}
+
+	if err := a.userSaver.SetEmailVerified(user.ID, user.Email); err != nil {
+		log.Error("failed to mark email verified", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// newLoginCode generates a random code of loginCodeDigits digits.
+func newLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(loginCodeModulus))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", loginCodeDigits, n.Int64()), nil
+}
+
+// loginLink adds the login token and the code to the redirect URI of the
+// app, which completes the login with them.
+func loginLink(redirectURI string, loginToken string, code string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	query.Set("login_token", loginToken)
+	query.Set("code", code)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"time"
+)
+
+// MaxAccessTTL bounds the access token lifetime an app may configure, see the
+// access_ttl check in the apps table. Revocations of all tokens of a user and
+// retired signing keys must be kept at least that long.
+const MaxAccessTTL = 24 * time.Hour
+
+// tokenPolicy holds the token lifetimes effective for an app. Zero session
+// lifetime and idle timeout mean there is no limit, zero refresh TTL means
+// no refresh tokens are issued.
+type tokenPolicy struct {
+	accessTTL       time.Duration
+	refreshTTL      time.Duration
+	sessionLifetime time.Duration
+	idleTimeout     time.Duration
+}
+
+// policy merges the app overrides with the global config.
+func (a *Auth) policy(app models.App) tokenPolicy {
+	p := tokenPolicy{
+		accessTTL:       a.tokenTTL,
+		refreshTTL:      a.refreshTTL,
+		sessionLifetime: a.sessionLifetime,
+		idleTimeout:     a.idleTimeout,
+	}
+
+	if app.AccessTTL != nil {
+		p.accessTTL = app.AccessTTL.Duration()
+	}
+	if app.RefreshTTL != nil {
+		p.refreshTTL = app.RefreshTTL.Duration()
+	}
+	if app.SessionLifetime != nil {
+		p.sessionLifetime = app.SessionLifetime.Duration()
+	}
+	if app.IdleTimeout != nil {
+		p.idleTimeout = app.IdleTimeout.Duration()
+	}
+
+	return p
+}
+
+// refreshLifetime is the lifetime of a single refresh token. Capping it by
+// the idle timeout makes a session expire when it isn't refreshed in time.
+func (p tokenPolicy) refreshLifetime() time.Duration {
+	if p.idleTimeout > 0 && p.idleTimeout < p.refreshTTL {
+		return p.idleTimeout
+	}
+
+	return p.refreshTTL
+}
+
+// session describes a login made at authTime within the given family.
+func (p tokenPolicy) session(familyID string, authTime time.Time) jwt.Session {
+	session := jwt.Session{
+		FamilyID: familyID,
+		AuthTime: authTime,
+	}
+	if p.sessionLifetime > 0 {
+		session.ExpiresAt = authTime.Add(p.sessionLifetime)
+	}
+
+	return session
+}
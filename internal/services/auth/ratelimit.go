@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"log/slog"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+// RateLimit allows Hits requests per key within Window. Zero Hits mean
+// there is no limit.
+type RateLimit struct {
+	Hits   int
+	Window time.Duration
+}
+
+// throttle counts a request for the key and returns ErrTooManyRequests once
+// the limit is exceeded. Requests are counted in the database, so that the
+// limit holds across instances.
+func (a *Auth) throttle(log *slog.Logger, key string, limit RateLimit) error {
+	if limit.Hits <= 0 {
+		return nil
+	}
+
+	hits, err := a.rateLimits.HitRateLimit(key, limit.Window, time.Now())
+	if err != nil {
+		log.Error("failed to count request", sl.Err(err))
+		return err
+	}
+
+	if hits > limit.Hits {
+		log.Warn("rate limit exceeded", slog.String("key", key))
+		return ErrTooManyRequests
+	}
+
+	return nil
+}
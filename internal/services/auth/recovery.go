@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+
+	// recoveryCodeAlphabet leaves out characters that are easily confused.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user the access
+// token belongs to once the password is confirmed. The old codes stop
+// working.
+func (a *Auth) RegenerateRecoveryCodes(
+	accessToken string,
+	password string,
+) ([]string, error) {
+	const op = "auth.RegenerateRecoveryCodes"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	_, user, err := a.confirmPassword(log, accessToken, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	enabled, err := a.mfaEnabled(log, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !enabled {
+		log.Info("mfa not enabled")
+		return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+	}
+
+	codes, err := a.newRecoveryCodes(log, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("recovery codes regenerated")
+
+	return codes, nil
+}
+
+// newRecoveryCodes generates a set of recovery codes for the user and stores
+// their hashes in place of the old set.
+func (a *Auth) newRecoveryCodes(log *slog.Logger, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			log.Error("failed to generate recovery code", sl.Err(err))
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(userID, code)
+	}
+
+	if err := a.mfa.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		log.Error("failed to save recovery codes", sl.Err(err))
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode redeems a recovery code of the user and records it in the
+// audit log. It reports false if the code isn't one of the user's.
+func (a *Auth) useRecoveryCode(log *slog.Logger, userID int64, code string, client models.ClientInfo) (bool, error) {
+	err := a.mfa.UseRecoveryCode(userID, hashRecoveryCode(userID, code))
+	if err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			return false, nil
+		}
+		log.Error("failed to use recovery code", sl.Err(err))
+
+		return false, err
+	}
+
+	err = a.audit.SaveAuditEvent(models.AuditEvent{
+		UserID:    userID,
+		Event:     models.AuditRecoveryCodeUsed,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("failed to save audit event", sl.Err(err))
+		return false, err
+	}
+
+	log.Warn("recovery code used", slog.Int64("user_id", userID))
+
+	return true, nil
+}
+
+// newRecoveryCode returns a code like "abcde-fghjk".
+func newRecoveryCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	var code strings.Builder
+	for i := range recoveryCodeLength {
+		if i == recoveryCodeLength/2 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+// hashRecoveryCode hashes the code as typed in by the user, ignoring case,
+// dashes and spaces. The user ID keeps equal codes of different users apart.
+func hashRecoveryCode(userID int64, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	return oauth.HashCode(strconv.FormatInt(userID, 10) + ":" + normalized)
+}
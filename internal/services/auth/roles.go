@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"time"
+)
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// CreateRole adds a role with the permissions to the app of the access
+// token and returns its id. The token must belong to a user allowed to
+// manage roles in its app, and the role can only be granted in that app.
+// Permissions are free-form like scopes, apps check them with UserRoles.
+func (a *Auth) CreateRole(
+	accessToken string,
+	name string,
+	description string,
+	permissions []string,
+) (int32, error) {
+	const op = "auth.CreateRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("role", name),
+	)
+
+	claims, err := a.authorize(log, accessToken, models.PermissionManageRoles)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !roleNameRe.MatchString(name) {
+		log.Info("invalid role name")
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidRole)
+	}
+
+	for _, permission := range permissions {
+		if !oauth.ValidScope(permission) {
+			log.Info("invalid permission", slog.String("permission", permission))
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidPermission)
+		}
+	}
+
+	slices.Sort(permissions)
+
+	id, err := a.roles.SaveRole(models.Role{
+		AppID:       &claims.AppID,
+		Name:        name,
+		Description: description,
+		Permissions: slices.Compact(permissions),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleExists) {
+			log.Info("role already exists")
+			return 0, fmt.Errorf("%s: %w", op, ErrRoleExists)
+		}
+		log.Error("failed to save role", sl.Err(err))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role created", slog.Int64("admin_id", claims.UserID))
+
+	return id, nil
+}
+
+// GrantRole gives the role to the user in the app. The access token must
+// belong to a user allowed to manage roles in that app.
+func (a *Auth) GrantRole(
+	accessToken string,
+	userID int64,
+	appID int32,
+	roleName string,
+) error {
+	const op = "auth.GrantRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int("app_id", int(appID)),
+		slog.String("role", roleName),
+	)
+
+	claims, role, err := a.authorizeRoleChange(log, accessToken, appID, roleName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.userProvider.UserByID(userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.roles.GrantRole(userID, appID, role.ID, time.Now()); err != nil {
+		log.Error("failed to grant role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role granted", slog.Int64("admin_id", claims.UserID))
+
+	return nil
+}
+
+// RevokeRole takes the role of the user in the app away. The access token
+// must belong to a user allowed to manage roles in that app.
+func (a *Auth) RevokeRole(
+	accessToken string,
+	userID int64,
+	appID int32,
+	roleName string,
+) error {
+	const op = "auth.RevokeRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int("app_id", int(appID)),
+		slog.String("role", roleName),
+	)
+
+	claims, role, err := a.authorizeRoleChange(log, accessToken, appID, roleName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.roles.RevokeRole(userID, appID, role.ID); err != nil {
+		if errors.Is(err, storage.ErrRoleNotGranted) {
+			log.Info("role not granted")
+			return fmt.Errorf("%s: %w", op, ErrRoleNotGranted)
+		}
+		log.Error("failed to revoke role", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role revoked", slog.Int64("admin_id", claims.UserID))
+
+	return nil
+}
+
+// UserRoles returns the roles of the user in the app with their
+// permissions. The access token must belong to the user or to a user allowed
+// to manage roles in that app.
+func (a *Auth) UserRoles(
+	accessToken string,
+	userID int64,
+	appID int32,
+) ([]models.Role, error) {
+	const op = "auth.UserRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int("app_id", int(appID)),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if claims.UserID != userID {
+		if err := a.checkPermission(log, claims.UserID, appID, models.PermissionManageRoles); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	roles, err := a.roles.UserRoles(userID, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		log.Error("failed to get user roles", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// templateRoles returns the names of the roles the user holds in the app if
+// its claims template embeds them. Roles are looked up per app, so that the
+// tokens of an app only ever reflect the roles granted in it.
+func (a *Auth) templateRoles(user models.User, app models.App) ([]string, error) {
+	if user.ID == 0 || !app.ClaimsTemplate.UsesRoles() {
+		return nil, nil
+	}
+
+	roles, err := a.roles.UserRoles(user.ID, app.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+
+	return names, nil
+}
+
+func (a *Auth) authorizeRoleChange(
+	log *slog.Logger,
+	accessToken string,
+	appID int32,
+	roleName string,
+) (*jwt.Claims, models.Role, error) {
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, models.Role{}, err
+	}
+
+	if err := a.checkPermission(log, claims.UserID, appID, models.PermissionManageRoles); err != nil {
+		return nil, models.Role{}, err
+	}
+
+	// Roles of other apps are as unknown as roles that don't exist.
+	role, err := a.roles.Role(roleName, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			log.Info("role not found")
+			return nil, models.Role{}, ErrRoleNotFound
+		}
+		log.Error("failed to get role", sl.Err(err))
+
+		return nil, models.Role{}, err
+	}
+
+	return claims, role, nil
+}
+
+// authorize authenticates the user of the access token and checks that a
+// role of the user in the app the token was issued for carries the
+// permission.
+func (a *Auth) authorize(log *slog.Logger, accessToken string, permission string) (*jwt.Claims, error) {
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkPermission(log, claims.UserID, claims.AppID, permission); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (a *Auth) checkPermission(log *slog.Logger, userID int64, appID int32, permission string) error {
+	ok, err := a.roles.HasPermission(userID, appID, permission)
+	if err != nil {
+		log.Error("failed to check permission", sl.Err(err))
+		return err
+	}
+
+	if !ok {
+		log.Warn("permission denied",
+			slog.Int64("user_id", userID),
+			slog.Int("app_id", int(appID)),
+			slog.String("permission", permission),
+		)
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
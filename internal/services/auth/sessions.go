@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// ListSessions returns the active sessions of the user the access token
+// belongs to.
+func (a *Auth) ListSessions(
+	accessToken string,
+) ([]models.Session, error) {
+	const op = "auth.ListSessions"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.sessions.UserSessions(claims.UserID, time.Now())
+	if err != nil {
+		log.Error("failed to get sessions", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.FamilyID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends a session of the user the access token belongs to.
+// Sessions of other users are reported as not found.
+func (a *Auth) RevokeSession(
+	accessToken string,
+	sessionID string,
+) error {
+	const op = "auth.RevokeSession"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("session_id", sessionID),
+	)
+
+	claims, err := a.authenticate(accessToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	session, err := a.session(log, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if session.UserID != claims.UserID {
+		log.Warn("session belongs to another user", slog.Int64("user_id", claims.UserID))
+		return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+	}
+
+	if err := a.revokeSession(log, session); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AdminListSessions returns the active sessions of any user in the app of
+// the access token, which must belong to a user allowed to manage sessions
+// in that app.
+func (a *Auth) AdminListSessions(
+	accessToken string,
+	userID int64,
+) ([]models.Session, error) {
+	const op = "auth.AdminListSessions"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	claims, err := a.authorize(log, accessToken, models.PermissionManageSessions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.sessions.UserSessions(userID, time.Now())
+	if err != nil {
+		log.Error("failed to get sessions", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions = slices.DeleteFunc(sessions, func(session models.Session) bool {
+		return session.AppID != claims.AppID
+	})
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.FamilyID
+	}
+
+	return sessions, nil
+}
+
+// AdminRevokeSession ends a session of any user in the app of the access
+// token, which must belong to a user allowed to manage sessions in that app.
+// Sessions of other apps are reported as not found.
+func (a *Auth) AdminRevokeSession(
+	accessToken string,
+	sessionID string,
+) error {
+	const op = "auth.AdminRevokeSession"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("session_id", sessionID),
+	)
+
+	claims, err := a.authorize(log, accessToken, models.PermissionManageSessions)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	session, err := a.session(log, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if session.AppID != claims.AppID {
+		log.Warn("session belongs to another app",
+			slog.Int64("admin_id", claims.UserID),
+			slog.Int("app_id", int(session.AppID)),
+		)
+		return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+	}
+
+	log.Info("admin revokes session",
+		slog.Int64("admin_id", claims.UserID),
+		slog.Int64("user_id", session.UserID),
+	)
+
+	if err := a.revokeSession(log, session); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *Auth) session(log *slog.Logger, sessionID string) (models.Session, error) {
+	session, err := a.sessions.Session(sessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Info("session not found")
+			return models.Session{}, ErrSessionNotFound
+		}
+		log.Error("failed to get session", sl.Err(err))
+
+		return models.Session{}, err
+	}
+
+	if session.RevokedAt != nil || !session.ExpiresAt.After(time.Now()) {
+		log.Info("session is not active")
+		return models.Session{}, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+// revokeSession revokes the refresh token family of the session and denies
+// the access tokens issued within it until the session would have expired.
+func (a *Auth) revokeSession(log *slog.Logger, session models.Session) error {
+	if err := a.tokenSaver.RevokeTokenFamily(session.ID); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+		return err
+	}
+
+	if err := a.revocations.RevokeToken(session.ID, session.ExpiresAt); err != nil {
+		log.Error("failed to revoke tokens", sl.Err(err))
+		return err
+	}
+
+	log.Info("session revoked", slog.Int64("user_id", session.UserID))
+
+	return nil
+}
+
+// revokeOtherSessions ends every session of the user except the current
+// one.
+func (a *Auth) revokeOtherSessions(log *slog.Logger, userID int64, currentID string) error {
+	sessions, err := a.sessions.UserSessions(userID, time.Now())
+	if err != nil {
+		log.Error("failed to get sessions", sl.Err(err))
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == currentID {
+			continue
+		}
+		if err := a.revokeSession(log, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sessionExpiry is the moment the last token issued within a session
+// expires.
+func sessionExpiry(tokens jwt.TokenPair) time.Time {
+	if tokens.RefreshExpiresAt.After(tokens.AccessExpiresAt) {
+		return tokens.RefreshExpiresAt
+	}
+
+	return tokens.AccessExpiresAt
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/oauth"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// VerifyEmail marks the email a verification token was mailed to as
+// verified. Tokens can be used once and only while the user still has that
+// email.
+func (a *Auth) VerifyEmail(
+	token string,
+) error {
+	const op = "auth.VerifyEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	stored, err := a.useEmailToken(log, token, models.EmailTokenVerify)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", stored.UserID))
+
+	if err := a.userSaver.SetEmailVerified(stored.UserID, stored.Email); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user changed the email or was deleted")
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailToken)
+		}
+		log.Error("failed to mark email verified", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified")
+
+	return nil
+}
+
+// ResendVerification mails a new verification token to the user with the
+// email. It succeeds for unknown and already verified emails as well, so
+// that it can't be used to find out who is registered.
+func (a *Auth) ResendVerification(
+	email string,
+) error {
+	const op = "auth.ResendVerification"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.userProvider.UserByEmail(email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return nil
+		}
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.EmailVerified {
+		log.Info("email already verified")
+		return nil
+	}
+
+	if err := a.sendVerification(log, user); err != nil {
+		log.Error("failed to send verification mail", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ChangeEmail sets a new email for the user the access token belongs to
+// once the password is confirmed. The new email has to be verified again and
+// the old one is told about the change. All other sessions of the user end.
+func (a *Auth) ChangeEmail(
+	accessToken string,
+	password string,
+	newEmail string,
+) error {
+	const op = "auth.ChangeEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, user, err := a.confirmPassword(log, accessToken, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	if newEmail == user.Email {
+		return nil
+	}
+
+	if err := a.userSaver.UpdateEmail(user.ID, newEmail); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Info("email is taken")
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		log.Error("failed to update email", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeOtherSessions(log, user.ID, claims.FamilyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email changed")
+
+	// Tokens mailed to the old email stop working as they are bound to it.
+	// The email is changed already, so failing mails are only logged and the
+	// user can ask for another verification mail.
+	oldEmail := user.Email
+	user.Email = newEmail
+
+	if err := a.sendVerification(log, user); err != nil {
+		log.Error("failed to send verification mail", sl.Err(err))
+	}
+
+	err = a.mailer.Send(mail.Message{
+		To:      oldEmail,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf(
+			"The email of your account was changed to %s. If you didn't change it, reset your password and contact support.\n",
+			newEmail,
+		),
+	})
+	if err != nil {
+		log.Error("failed to notify old email", sl.Err(err))
+	}
+
+	return nil
+}
+
+// sendVerification mails a link with a new verification token to the user.
+func (a *Auth) sendVerification(log *slog.Logger, user models.User) error {
+	token, expiresAt, err := a.newEmailToken(user, models.EmailTokenVerify, a.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	err = a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Confirm your email address by opening the link below:\n\n%s\n\nThe link expires at %s. If you didn't create an account, ignore this email.\n",
+			a.accountURL("/verify_email", token),
+			expiresAt.UTC().Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("verification mail sent", slog.Int64("user_id", user.ID))
+
+	return nil
+}
+
+// newEmailToken stores a new single-use token of the purpose for the current
+// email of the user and returns the secret to mail.
+func (a *Auth) newEmailToken(user models.User, purpose string, ttl time.Duration) (string, time.Time, error) {
+	token, err := oauth.NewCode()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
+	err = a.emailTokens.SaveEmailToken(models.EmailToken{
+		ID:        oauth.HashCode(token),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// useEmailToken redeems a token of the purpose.
+func (a *Auth) useEmailToken(log *slog.Logger, token string, purpose string) (models.EmailToken, error) {
+	if token == "" {
+		return models.EmailToken{}, ErrInvalidEmailToken
+	}
+
+	stored, err := a.emailTokens.UseEmailToken(oauth.HashCode(token), purpose)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailTokenNotFound) {
+			log.Info("email token not found")
+			return models.EmailToken{}, ErrInvalidEmailToken
+		}
+		log.Error("failed to use email token", sl.Err(err))
+
+		return models.EmailToken{}, err
+	}
+
+	if !time.Now().Before(stored.ExpiresAt) {
+		log.Info("email token expired")
+		return models.EmailToken{}, ErrInvalidEmailToken
+	}
+
+	return stored, nil
+}
+
+// accountURL links to the account page at path of the HTTP server, which is
+// served under the issuer URL.
+func (a *Auth) accountURL(path string, token string) string {
+	return strings.TrimSuffix(a.issuer.Name, "/") + path + "?token=" + token
+}
+
+// requireVerifiedEmail refuses the login if the app requires verified
+// emails and the user hasn't verified theirs.
+func requireVerifiedEmail(log *slog.Logger, user models.User, app models.App) error {
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		log.Info("email is not verified", slog.Int64("user_id", user.ID))
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/secret"
+	"time"
+)
+
+// Keys keeps the shared jwt.KeySet in sync with the signing_keys table and
+// rotates the stored keys on schedule.
+//
+// A new key is created in the pending state publishAhead before the active
+// one is due for rotation, so resource servers caching the JWKS learn about
+// it before it signs anything. Once activated, the previous key is retired
+// and stays published for retention, which must exceed the access token
+// lifetime, so tokens it signed keep verifying until they expire.
+//
+// Private keys are stored encrypted with secrets.
+type Keys struct {
+	log          *slog.Logger
+	keyStorage   KeyStorage
+	secrets      *secret.Box
+	keys         *jwt.KeySet
+	static       []jwt.SigningKey
+	rotation     bool
+	algorithm    string
+	interval     time.Duration
+	publishAhead time.Duration
+	retention    time.Duration
+}
+
+type KeyStorage interface {
+	SigningKeys() ([]models.SigningKey, error)
+	SaveSigningKey(key models.SigningKey) error
+	ActivateSigningKey(id string, appID int32, activatedAt time.Time, expiresAt time.Time) error
+	DeleteExpiredSigningKeys(now time.Time) (int64, error)
+}
+
+// New creates the key service. Static keys, e.g. the ones loaded from the
+// config, are always kept in the key set after the stored ones, so that an
+// active stored key takes over signing from them once rotation created it,
+// while the static keys keep verifying the tokens they signed. Without
+// secrets keys can neither be stored nor loaded, so rotation must be off.
+func New(
+	log *slog.Logger,
+	keyStorage KeyStorage,
+	secrets *secret.Box,
+	keys *jwt.KeySet,
+	static []jwt.SigningKey,
+	rotation bool,
+	algorithm string,
+	interval time.Duration,
+	publishAhead time.Duration,
+	retention time.Duration,
+) *Keys {
+	return &Keys{
+		log,
+		keyStorage,
+		secrets,
+		keys,
+		static,
+		rotation,
+		algorithm,
+		interval,
+		publishAhead,
+		retention,
+	}
+}
+
+// Sync rotates the stored keys if rotation is enabled and reloads the key
+// set from storage.
+func (k *Keys) Sync() error {
+	const op = "keys.Sync"
+
+	log := k.log.With(
+		slog.String("op", op),
+	)
+
+	if k.rotation {
+		if err := k.rotate(log, time.Now()); err != nil {
+			log.Error("failed to rotate signing keys", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := k.reload(); err != nil {
+		log.Error("failed to reload signing keys", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (k *Keys) rotate(log *slog.Logger, now time.Time) error {
+	stored, err := k.keyStorage.SigningKeys()
+	if err != nil {
+		return err
+	}
+
+	byApp := map[int32][]models.SigningKey{0: nil}
+	for _, key := range stored {
+		byApp[key.AppID] = append(byApp[key.AppID], key)
+	}
+
+	for appID, appKeys := range byApp {
+		if err := k.rotateApp(log, appID, appKeys, now); err != nil {
+			return err
+		}
+	}
+
+	deleted, err := k.keyStorage.DeleteExpiredSigningKeys(now)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Info("deleted expired signing keys", slog.Int64("count", deleted))
+	}
+
+	return nil
+}
+
+// rotateApp moves the keys of one app (0 for the global keys) through their
+// states. Keys are ordered by activation time, newest first.
+func (k *Keys) rotateApp(log *slog.Logger, appID int32, appKeys []models.SigningKey, now time.Time) error {
+	log = log.With(slog.Int("app_id", int(appID)))
+
+	var active, pending *models.SigningKey
+	for i, key := range appKeys {
+		switch key.State {
+		case models.KeyStateActive:
+			if active == nil {
+				active = &appKeys[i]
+			}
+		case models.KeyStatePending:
+			pending = &appKeys[i]
+		}
+	}
+
+	switch {
+	case active == nil && pending == nil:
+		key, err := k.newKey(appID, models.KeyStatePending, now)
+		if err != nil {
+			return err
+		}
+		log.Info("created initial signing key", slog.String("kid", key.ID))
+
+		return k.activate(key, now)
+	case pending != nil && !pending.ActivatesAt.After(now):
+		log.Info("activating signing key", slog.String("kid", pending.ID))
+
+		return k.activate(*pending, now)
+	case pending == nil && !active.ActivatesAt.Add(k.interval-k.publishAhead).After(now):
+		key, err := k.newKey(appID, models.KeyStatePending, active.ActivatesAt.Add(k.interval))
+		if err != nil {
+			return err
+		}
+		log.Info("published pending signing key",
+			slog.String("kid", key.ID),
+			slog.Time("activates_at", key.ActivatesAt),
+		)
+	}
+
+	return nil
+}
+
+func (k *Keys) newKey(appID int32, state string, activatesAt time.Time) (models.SigningKey, error) {
+	private, err := jwt.GenerateKey(k.algorithm)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	sealed, err := k.secrets.Seal(der)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	id, err := jwt.NewTokenID()
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	key := models.SigningKey{
+		ID:          id,
+		AppID:       appID,
+		Algorithm:   k.algorithm,
+		PrivateKey:  sealed,
+		State:       state,
+		ActivatesAt: activatesAt,
+	}
+	if err := k.keyStorage.SaveSigningKey(key); err != nil {
+		return models.SigningKey{}, err
+	}
+
+	return key, nil
+}
+
+func (k *Keys) activate(key models.SigningKey, now time.Time) error {
+	return k.keyStorage.ActivateSigningKey(key.ID, key.AppID, now, now.Add(k.retention))
+}
+
+func (k *Keys) reload() error {
+	stored, err := k.keyStorage.SigningKeys()
+	if err != nil {
+		return err
+	}
+
+	if len(stored) > 0 && k.secrets == nil {
+		return errors.New("stored signing keys can't be decrypted without an encryption key")
+	}
+
+	keys := make([]jwt.SigningKey, 0, len(stored)+len(k.static))
+
+	for _, storedKey := range stored {
+		der, err := k.secrets.Open(storedKey.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", storedKey.ID, err)
+		}
+
+		private, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", storedKey.ID, err)
+		}
+
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("key %q: unsupported private key type", storedKey.ID)
+		}
+
+		key, err := jwt.NewSigningKey(storedKey.ID, storedKey.Algorithm, storedKey.AppID, signer)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", storedKey.ID, err)
+		}
+		key.Active = storedKey.State == models.KeyStateActive
+
+		keys = append(keys, key)
+	}
+
+	keys = append(keys, k.static...)
+
+	k.keys.Replace(keys...)
+
+	return nil
+}
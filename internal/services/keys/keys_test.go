@@ -0,0 +1,217 @@
+package keys
+
+import (
+	"crypto/x509"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/slogdiscard"
+	"sso/internal/lib/secret"
+)
+
+const (
+	interval     = 720 * time.Hour
+	publishAhead = 24 * time.Hour
+	retention    = 48 * time.Hour
+)
+
+func TestRotate_PendingActiveRetired(t *testing.T) {
+	k, keyStorage := newKeys(t)
+	log := slogdiscard.NewDiscardLogger()
+
+	start := time.Now()
+
+	// The first key is activated right away.
+	require.NoError(t, k.rotate(log, start))
+	require.NoError(t, k.reload())
+
+	require.Len(t, keyStorage.keys, 1)
+	first := keyStorage.keys[0]
+	assert.Equal(t, models.KeyStateActive, first.State)
+	assert.Equal(t, []string{first.ID}, publishedKeys(k))
+	assert.Equal(t, first.ID, signingKey(t, k))
+
+	// Nothing happens until the next key is due to be published.
+	require.NoError(t, k.rotate(log, start.Add(interval-publishAhead-time.Minute)))
+	require.Len(t, keyStorage.keys, 1)
+
+	// The next key is published ahead of its activation, but doesn't sign
+	// anything yet.
+	require.NoError(t, k.rotate(log, start.Add(interval-publishAhead)))
+	require.NoError(t, k.reload())
+
+	second := keyStorage.key(t, models.KeyStatePending)
+	assert.Equal(t, start.Add(interval), second.ActivatesAt)
+	assert.ElementsMatch(t, []string{first.ID, second.ID}, publishedKeys(k))
+	assert.Equal(t, first.ID, signingKey(t, k))
+
+	// Once activated it takes over, the previous key is retired but stays
+	// published for the tokens it signed.
+	activatedAt := start.Add(interval)
+	require.NoError(t, k.rotate(log, activatedAt))
+	require.NoError(t, k.reload())
+
+	assert.Equal(t, second.ID, keyStorage.key(t, models.KeyStateActive).ID)
+	retired := keyStorage.key(t, models.KeyStateRetired)
+	assert.Equal(t, first.ID, retired.ID)
+	require.NotNil(t, retired.ExpiresAt)
+	assert.Equal(t, activatedAt.Add(retention), *retired.ExpiresAt)
+	assert.ElementsMatch(t, []string{first.ID, second.ID}, publishedKeys(k))
+	assert.Equal(t, second.ID, signingKey(t, k))
+
+	_, ok := k.keys.VerificationKey(first.ID)
+	assert.True(t, ok)
+
+	// After the retention the retired key is gone.
+	require.NoError(t, k.rotate(log, activatedAt.Add(retention+time.Second)))
+	require.NoError(t, k.reload())
+
+	assert.Equal(t, []string{second.ID}, publishedKeys(k))
+	_, ok = k.keys.VerificationKey(first.ID)
+	assert.False(t, ok)
+}
+
+func TestRotate_SupersedesStaticKeys(t *testing.T) {
+	private, err := jwt.GenerateKey(jwt.AlgES256)
+	require.NoError(t, err)
+
+	static, err := jwt.NewSigningKey("static", jwt.AlgES256, 0, private)
+	require.NoError(t, err)
+	static.Active = true
+
+	k, keyStorage := newKeys(t, static)
+
+	require.NoError(t, k.reload())
+	assert.Equal(t, static.ID, signingKey(t, k))
+
+	require.NoError(t, k.rotate(slogdiscard.NewDiscardLogger(), time.Now()))
+	require.NoError(t, k.reload())
+
+	stored := keyStorage.key(t, models.KeyStateActive)
+	assert.Equal(t, stored.ID, signingKey(t, k))
+	assert.ElementsMatch(t, []string{static.ID, stored.ID}, publishedKeys(k))
+}
+
+func TestRotate_StoresEncryptedKeys(t *testing.T) {
+	k, keyStorage := newKeys(t)
+
+	require.NoError(t, k.rotate(slogdiscard.NewDiscardLogger(), time.Now()))
+
+	stored := keyStorage.key(t, models.KeyStateActive)
+	_, err := x509.ParsePKCS8PrivateKey([]byte(stored.PrivateKey))
+	assert.Error(t, err)
+
+	der, err := k.secrets.Open(stored.PrivateKey)
+	require.NoError(t, err)
+	_, err = x509.ParsePKCS8PrivateKey(der)
+	assert.NoError(t, err)
+
+	// Stored keys can't be loaded without the encryption key.
+	k.secrets = nil
+	assert.Error(t, k.reload())
+}
+
+func newKeys(t *testing.T, static ...jwt.SigningKey) (*Keys, *keyStorage) {
+	t.Helper()
+
+	box, err := secret.NewBox("c3NvLXRlc3Qtc2lnbmluZy1rZXktZW5jcnlwdGlvbi0=")
+	require.NoError(t, err)
+
+	keyStorage := &keyStorage{}
+
+	k := New(
+		slogdiscard.NewDiscardLogger(),
+		keyStorage,
+		box,
+		jwt.NewKeySet(),
+		static,
+		true,
+		jwt.AlgES256,
+		interval,
+		publishAhead,
+		retention,
+	)
+
+	return k, keyStorage
+}
+
+func publishedKeys(k *Keys) []string {
+	var ids []string
+	for _, key := range k.keys.JWKS().Keys {
+		ids = append(ids, key.Kid)
+	}
+
+	return ids
+}
+
+func signingKey(t *testing.T, k *Keys) string {
+	t.Helper()
+
+	key, ok := k.keys.SigningKey(1)
+	require.True(t, ok)
+
+	return key.ID
+}
+
+// keyStorage keeps the signing keys in memory the way the postgres storage
+// does.
+type keyStorage struct {
+	keys []models.SigningKey
+}
+
+func (s *keyStorage) SigningKeys() ([]models.SigningKey, error) {
+	keys := slices.Clone(s.keys)
+	slices.SortFunc(keys, func(a, b models.SigningKey) int {
+		return b.ActivatesAt.Compare(a.ActivatesAt)
+	})
+
+	return keys, nil
+}
+
+func (s *keyStorage) SaveSigningKey(key models.SigningKey) error {
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *keyStorage) ActivateSigningKey(id string, appID int32, activatedAt time.Time, expiresAt time.Time) error {
+	for i, key := range s.keys {
+		switch {
+		case key.ID == id:
+			s.keys[i].State = models.KeyStateActive
+			s.keys[i].ActivatesAt = activatedAt
+		case key.AppID == appID && key.State == models.KeyStateActive:
+			s.keys[i].State = models.KeyStateRetired
+			s.keys[i].RetiredAt = &activatedAt
+			s.keys[i].ExpiresAt = &expiresAt
+		}
+	}
+
+	return nil
+}
+
+func (s *keyStorage) DeleteExpiredSigningKeys(now time.Time) (int64, error) {
+	before := len(s.keys)
+	s.keys = slices.DeleteFunc(s.keys, func(key models.SigningKey) bool {
+		return key.State == models.KeyStateRetired && key.ExpiresAt.Before(now)
+	})
+
+	return int64(before - len(s.keys)), nil
+}
+
+func (s *keyStorage) key(t *testing.T, state string) models.SigningKey {
+	t.Helper()
+
+	for _, key := range s.keys {
+		if key.State == state {
+			return key
+		}
+	}
+	t.Fatalf("no %s key", state)
+
+	return models.SigningKey{}
+}
@@ -6,10 +6,23 @@ import (
 	"fmt"
 	"sso/internal/domain/models"
 	"sso/internal/storage"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is the Postgres error code of unique constraint violations.
+const uniqueViolation = "23505"
+
+// userColumns selects a user.
+const userColumns = `id, email, pass_hash, email_verified`
+
+// roleColumns selects a role of the roles table aliased as r together with
+// its permissions.
+const roleColumns = `r.id, r.app_id, r.name, r.description, r.created_at,
+	ARRAY(SELECT p.permission FROM role_permissions p WHERE p.role_id = r.id ORDER BY p.permission) AS permissions`
+
 type Storage struct {
 	db *sqlx.DB
 }
@@ -40,16 +53,14 @@ func (s *Storage) Close() error {
 
 func (s *Storage) SaveUser(email string, passHash []byte) (int64, error) {
 	const op = "storage.postgres.SaveUser"
-	resp, err := s.db.Exec(`INSERT INTO users (email, pass_hash) VALUES ($1, $2)`, email, passHash)
-	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
-	}
-	id, err := resp.LastInsertId()
+	// lib/pq doesn't support LastInsertId, the id is returned by the insert.
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO users (email, pass_hash) VALUES ($1, $2) RETURNING id`, email, passHash).Scan(&id)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
-	}
-	rowsAffected, err := resp.RowsAffected()
-	if rowsAffected == 0 {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	return id, nil
@@ -60,9 +71,12 @@ func (s *Storage) UserByEmail(email string) (models.User, error) {
 
 	var user models.User
 
-	err := s.db.Get(&user, `SELECT id, email, pass_hash FROM users WHERE email = $1`, email)
+	err := s.db.Get(&user, `SELECT `+userColumns+` FROM users WHERE email = $1`, email)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -73,17 +87,22 @@ func (s *Storage) UserByID(id int64) (models.User, error) {
 	const op = "storage.postgres.UserByID"
 
 	var user models.User
-	err := s.db.Get(&user, `SELECT id, email FROM users WHERE id = $1`, id)
+	err := s.db.Get(&user, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 	return user, nil
 }
 
-func (s *Storage) DeleteUser(id int64) error {
-	const op = "storage.postgres.DeleteUser"
+// SetEmailVerified marks the email of the user as verified, unless the user
+// changed it in the meantime.
+func (s *Storage) SetEmailVerified(id int64, email string) error {
+	const op = "storage.postgres.SetEmailVerified"
 
-	res, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	res, err := s.db.Exec(`UPDATE users SET email_verified = TRUE WHERE id = $1 AND email = $2`, id, email)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -92,24 +111,120 @@ func (s *Storage) DeleteUser(id int64) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdatePassword(id int64, passHash []byte) error {
+	const op = "storage.postgres.UpdatePassword"
+
+	res, err := s.db.Exec(`UPDATE users SET pass_hash = $1 WHERE id = $2`, passHash, id)
+	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
 	return nil
 }
 
-func (s *Storage) IsAdmin(userID int64) (bool, error) {
-	const op = "storage.postgres.isAdmin"
+// UpdateEmail changes the email of the user, which has to be verified
+// again.
+func (s *Storage) UpdateEmail(id int64, email string) error {
+	const op = "storage.postgres.UpdateEmail"
 
-	stmt, err := s.db.Prepare("SELECT is_admin FROM users WHERE id = ?")
+	res, err := s.db.Exec(`UPDATE users SET email = $1, email_verified = FALSE WHERE id = $2`, email, id)
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
 	}
 
-	row := stmt.QueryRow(userID)
+	return nil
+}
 
-	var isAdmin bool
+// ResetCredentials replaces the password of the user and removes the second
+// factors: TOTP, recovery codes and passkeys.
+func (s *Storage) ResetCredentials(id int64, passHash []byte) error {
+	const op = "storage.postgres.ResetCredentials"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET pass_hash = $1 WHERE id = $2`, passHash, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM webauthn_credentials WHERE user_id = $1`,
+		`DELETE FROM mfa_challenges WHERE user_id = $1`,
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-	err = row.Scan(&isAdmin)
+	return nil
+}
+
+func (s *Storage) DeleteUser(id int64) error {
+	const op = "storage.postgres.DeleteUser"
+
+	res, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// IsAdmin reports whether the user has the admin role in any app.
+func (s *Storage) IsAdmin(userID int64) (bool, error) {
+	const op = "storage.postgres.IsAdmin"
+
+	var isAdmin bool
+	err := s.db.Get(&isAdmin, `SELECT EXISTS (
+		SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id AND r.name = 'admin'
+	) FROM users WHERE id = $1`, userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -133,3 +248,1254 @@ func (s *Storage) App(appID int32) (models.App, error) {
 	}
 	return app, nil
 }
+
+// SaveClientCredentials makes the app a confidential client with the given
+// secret hash and scopes, replacing the previous ones.
+func (s *Storage) SaveClientCredentials(appID int32, secretHash string, scopes []string) error {
+	const op = "storage.postgres.SaveClientCredentials"
+
+	res, err := s.db.Exec(
+		`UPDATE apps SET client_secret_hash = $1, client_scopes = $2 WHERE id = $3`,
+		secretHash, pq.Array(scopes), appID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveRefreshToken(token models.RefreshToken) error {
+	const op = "storage.postgres.SaveRefreshToken"
+
+	_, err := s.db.Exec(
+		`INSERT INTO refresh_tokens (id, family_id, user_id, app_id, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.ID, token.FamilyID, token.UserID, token.AppID, token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RefreshToken(id string) (models.RefreshToken, error) {
+	const op = "storage.postgres.RefreshToken"
+
+	var token models.RefreshToken
+	err := s.db.Get(&token, `SELECT * FROM refresh_tokens WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// MarkRefreshTokenUsed atomically marks the token as used. It returns
+// storage.ErrTokenAlreadyUsed if the token was rotated before.
+func (s *Storage) MarkRefreshTokenUsed(id string) error {
+	const op = "storage.postgres.MarkRefreshTokenUsed"
+
+	res, err := s.db.Exec(
+		`UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL`,
+		time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenAlreadyUsed)
+	}
+
+	return nil
+}
+
+// RevokeTokenFamily revokes the refresh tokens of the family and the session
+// they belong to.
+func (s *Storage) RevokeTokenFamily(familyID string) error {
+	const op = "storage.postgres.RevokeTokenFamily"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	_, err = tx.Exec(
+		`UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`,
+		now, familyID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		now, familyID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeToken(id string, expiresAt time.Time) error {
+	const op = "storage.postgres.RevokeToken"
+
+	_, err := s.db.Exec(
+		`INSERT INTO revoked_tokens (id, expires_at) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`,
+		id, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes every token of the user issued before revokedAt
+// and every refresh token family and session the user has.
+func (s *Storage) RevokeUserTokens(userID int64, revokedAt time.Time, expiresAt time.Time) error {
+	const op = "storage.postgres.RevokeUserTokens"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO revoked_users (user_id, revoked_at, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at, expires_at = EXCLUDED.expires_at`,
+		userID, revokedAt, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`,
+		revokedAt, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`,
+		revokedAt, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsRevoked(ids []string, userID int64, issuedAt time.Time) (bool, error) {
+	const op = "storage.postgres.IsRevoked"
+
+	var revoked bool
+	err := s.db.Get(&revoked, `SELECT
+		EXISTS (SELECT 1 FROM revoked_tokens WHERE id = ANY($1))
+		OR EXISTS (SELECT 1 FROM revoked_users WHERE user_id = $2 AND revoked_at > $3)`,
+		pq.Array(ids), userID, issuedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// PruneRevocations removes denylist entries, refresh tokens, sessions,
+// authorization and device codes, federation states, email tokens, MFA
+// challenges, WebAuthn ceremonies and passwordless logins that have expired
+// by now and therefore can't be presented anymore, as well as rate limit
+// windows that have ended.
+func (s *Storage) PruneRevocations(now time.Time) (int64, error) {
+	const op = "storage.postgres.PruneRevocations"
+
+	var pruned int64
+	for _, query := range []string{
+		`DELETE FROM revoked_tokens WHERE expires_at < $1`,
+		`DELETE FROM revoked_users WHERE expires_at < $1`,
+		`DELETE FROM refresh_tokens WHERE expires_at < $1`,
+		`DELETE FROM sessions WHERE expires_at < $1`,
+		`DELETE FROM authorization_codes WHERE expires_at < $1`,
+		`DELETE FROM device_codes WHERE expires_at < $1`,
+		`DELETE FROM federation_states WHERE expires_at < $1`,
+		`DELETE FROM email_tokens WHERE expires_at < $1`,
+		`DELETE FROM mfa_challenges WHERE expires_at < $1`,
+		`DELETE FROM webauthn_ceremonies WHERE expires_at < $1`,
+		`DELETE FROM passwordless_logins WHERE expires_at < $1`,
+		`DELETE FROM rate_limits WHERE expires_at < $1`,
+	} {
+		res, err := s.db.Exec(query, now)
+		if err != nil {
+			return pruned, fmt.Errorf("%s: %w", op, err)
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return pruned, fmt.Errorf("%s: %w", op, err)
+		}
+		pruned += rowsAffected
+	}
+
+	return pruned, nil
+}
+
+func (s *Storage) SaveSession(session models.Session) error {
+	const op = "storage.postgres.SaveSession"
+
+	_, err := s.db.Exec(
+		`INSERT INTO sessions (id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.UserID, session.AppID, session.IP, session.UserAgent,
+		session.CreatedAt, session.LastUsedAt, session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TouchSession records that the session was used to refresh its tokens and
+// extends it until the new tokens expire.
+func (s *Storage) TouchSession(id string, lastUsedAt time.Time, expiresAt time.Time) error {
+	const op = "storage.postgres.TouchSession"
+
+	_, err := s.db.Exec(
+		`UPDATE sessions SET last_used_at = $1, expires_at = $2 WHERE id = $3`,
+		lastUsedAt, expiresAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Session(id string) (models.Session, error) {
+	const op = "storage.postgres.Session"
+
+	var session models.Session
+	err := s.db.Get(&session, `SELECT * FROM sessions WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return models.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// UserSessions returns the sessions of the user that are neither revoked nor
+// expired by now, most recently used first.
+func (s *Storage) UserSessions(userID int64, now time.Time) ([]models.Session, error) {
+	const op = "storage.postgres.UserSessions"
+
+	sessions := []models.Session{}
+	err := s.db.Select(&sessions,
+		`SELECT * FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 ORDER BY last_used_at DESC`,
+		userID, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(code models.AuthorizationCode) error {
+	const op = "storage.postgres.SaveAuthorizationCode"
+
+	_, err := s.db.Exec(
+		`INSERT INTO authorization_codes
+		(id, app_id, user_id, family_id, redirect_uri, code_challenge, code_challenge_method, scope, nonce, ip, user_agent, auth_time, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		code.ID, code.AppID, code.UserID, code.FamilyID, code.RedirectURI, code.CodeChallenge,
+		code.CodeChallengeMethod, code.Scope, code.Nonce, code.IP, code.UserAgent, code.AuthTime, code.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseAuthorizationCode atomically marks the code as used and returns it. If
+// the code was used before, it is returned along with
+// storage.ErrCodeAlreadyUsed so that the tokens issued for it can be revoked.
+func (s *Storage) UseAuthorizationCode(id string, usedAt time.Time) (models.AuthorizationCode, error) {
+	const op = "storage.postgres.UseAuthorizationCode"
+
+	var code models.AuthorizationCode
+	err := s.db.Get(&code,
+		`UPDATE authorization_codes SET used_at = $1 WHERE id = $2 AND used_at IS NULL RETURNING *`,
+		usedAt, id,
+	)
+	if err == nil {
+		return code, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.Get(&code, `SELECT * FROM authorization_codes WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrCodeNotFound)
+		}
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, fmt.Errorf("%s: %w", op, storage.ErrCodeAlreadyUsed)
+}
+
+// SaveDeviceCode returns storage.ErrDeviceCodeExists if either code is
+// already taken.
+func (s *Storage) SaveDeviceCode(code models.DeviceCode) error {
+	const op = "storage.postgres.SaveDeviceCode"
+
+	_, err := s.db.Exec(
+		`INSERT INTO device_codes (id, user_code, app_id, scope, state, interval, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		code.ID, code.UserCode, code.AppID, code.Scope, code.State, code.Interval, code.ExpiresAt,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeviceCode(id string) (models.DeviceCode, error) {
+	const op = "storage.postgres.DeviceCode"
+
+	var code models.DeviceCode
+	err := s.db.Get(&code, `SELECT * FROM device_codes WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+		}
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+func (s *Storage) DeviceCodeByUserCode(userCode string) (models.DeviceCode, error) {
+	const op = "storage.postgres.DeviceCodeByUserCode"
+
+	var code models.DeviceCode
+	err := s.db.Get(&code, `SELECT * FROM device_codes WHERE user_code = $1`, userCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+		}
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// PollDeviceCode records a poll of the client and the interval it must wait
+// before the next one.
+func (s *Storage) PollDeviceCode(id string, polledAt time.Time, interval models.Seconds) error {
+	const op = "storage.postgres.PollDeviceCode"
+
+	_, err := s.db.Exec(
+		`UPDATE device_codes SET last_polled_at = $1, interval = $2 WHERE id = $3`,
+		polledAt, interval, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DecideDeviceCode approves or denies a pending, unexpired code on behalf
+// of the user. It returns storage.ErrDeviceCodeNotFound if there is no such
+// code.
+func (s *Storage) DecideDeviceCode(userCode string, state string, userID int64, decidedAt time.Time) error {
+	const op = "storage.postgres.DecideDeviceCode"
+
+	res, err := s.db.Exec(
+		`UPDATE device_codes SET state = $1, user_id = $2, auth_time = $3
+		WHERE user_code = $4 AND state = $5 AND expires_at > $3`,
+		state, userID, decidedAt, userCode, models.DeviceCodePending,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	return nil
+}
+
+// RedeemDeviceCode atomically marks an approved code as redeemed. It
+// returns storage.ErrCodeAlreadyUsed if tokens were issued for it before.
+func (s *Storage) RedeemDeviceCode(id string) error {
+	const op = "storage.postgres.RedeemDeviceCode"
+
+	res, err := s.db.Exec(
+		`UPDATE device_codes SET state = $1 WHERE id = $2 AND state = $3`,
+		models.DeviceCodeRedeemed, id, models.DeviceCodeApproved,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrCodeAlreadyUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) UserIdentity(provider string, subject string) (models.UserIdentity, error) {
+	const op = "storage.postgres.UserIdentity"
+
+	var identity models.UserIdentity
+	err := s.db.Get(&identity, `SELECT * FROM user_identities WHERE provider = $1 AND subject = $2`, provider, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.UserIdentity{}, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+		}
+		return models.UserIdentity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identity, nil
+}
+
+// SaveUserIdentity returns storage.ErrIdentityExists if the identity is
+// already linked to a user.
+func (s *Storage) SaveUserIdentity(identity models.UserIdentity) error {
+	const op = "storage.postgres.SaveUserIdentity"
+
+	_, err := s.db.Exec(
+		`INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveFederationState(state models.FederationState) error {
+	const op = "storage.postgres.SaveFederationState"
+
+	_, err := s.db.Exec(
+		`INSERT INTO federation_states (id, provider, nonce, code_verifier, app_id, redirect_uri, code_challenge, code_challenge_method, scope, client_nonce, client_state, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		state.ID, state.Provider, state.Nonce, state.CodeVerifier, state.AppID, state.RedirectURI, state.CodeChallenge,
+		state.CodeChallengeMethod, state.Scope, state.ClientNonce, state.ClientState, state.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseFederationState atomically removes the state and returns it, so that
+// every state can complete a login only once.
+func (s *Storage) UseFederationState(id string) (models.FederationState, error) {
+	const op = "storage.postgres.UseFederationState"
+
+	var state models.FederationState
+	err := s.db.Get(&state, `DELETE FROM federation_states WHERE id = $1 RETURNING *`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.FederationState{}, fmt.Errorf("%s: %w", op, storage.ErrFederationStateNotFound)
+		}
+		return models.FederationState{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return state, nil
+}
+
+func (s *Storage) SaveEmailToken(token models.EmailToken) error {
+	const op = "storage.postgres.SaveEmailToken"
+
+	_, err := s.db.Exec(
+		`INSERT INTO email_tokens (id, user_id, purpose, email, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.ID, token.UserID, token.Purpose, token.Email, token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseEmailToken atomically removes the token and returns it, so that every
+// token can be used only once.
+func (s *Storage) UseEmailToken(id string, purpose string) (models.EmailToken, error) {
+	const op = "storage.postgres.UseEmailToken"
+
+	var token models.EmailToken
+	err := s.db.Get(&token, `DELETE FROM email_tokens WHERE id = $1 AND purpose = $2 RETURNING *`, id, purpose)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, fmt.Errorf("%s: %w", op, storage.ErrEmailTokenNotFound)
+		}
+		return models.EmailToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// DeleteEmailTokens removes the unused tokens of the purpose of the user.
+func (s *Storage) DeleteEmailTokens(userID int64, purpose string) error {
+	const op = "storage.postgres.DeleteEmailTokens"
+
+	_, err := s.db.Exec(`DELETE FROM email_tokens WHERE user_id = $1 AND purpose = $2`, userID, purpose)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveConsent adds the scopes to the consent of the user for the app.
+func (s *Storage) SaveConsent(userID int64, appID int32, scopes []string, grantedAt time.Time) error {
+	const op = "storage.postgres.SaveConsent"
+
+	_, err := s.db.Exec(
+		`INSERT INTO consents (user_id, app_id, scopes, granted_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (user_id, app_id) DO UPDATE
+		SET scopes     = ARRAY(SELECT DISTINCT unnest(consents.scopes || EXCLUDED.scopes) ORDER BY 1),
+		    updated_at = EXCLUDED.updated_at`,
+		userID, appID, pq.Array(scopes), grantedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UserConsents(userID int64) ([]models.Consent, error) {
+	const op = "storage.postgres.UserConsents"
+
+	consents := []models.Consent{}
+	err := s.db.Select(&consents,
+		`SELECT c.*, a.name AS app_name FROM consents c JOIN apps a ON a.id = c.app_id WHERE c.user_id = $1 ORDER BY c.updated_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return consents, nil
+}
+
+func (s *Storage) DeleteConsent(userID int64, appID int32) error {
+	const op = "storage.postgres.DeleteConsent"
+
+	res, err := s.db.Exec(`DELETE FROM consents WHERE user_id = $1 AND app_id = $2`, userID, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrConsentNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SigningKeys() ([]models.SigningKey, error) {
+	const op = "storage.postgres.SigningKeys"
+
+	var keys []models.SigningKey
+	err := s.db.Select(&keys, `SELECT * FROM signing_keys ORDER BY activates_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) SaveSigningKey(key models.SigningKey) error {
+	const op = "storage.postgres.SaveSigningKey"
+
+	_, err := s.db.Exec(
+		`INSERT INTO signing_keys (id, app_id, algorithm, private_key, state, activates_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, key.AppID, key.Algorithm, key.PrivateKey, key.State, key.ActivatesAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ActivateSigningKey makes the key active and retires the keys that were
+// active for the same app. Retired keys stay in the table until expiresAt so
+// that tokens they signed can still be verified.
+func (s *Storage) ActivateSigningKey(id string, appID int32, activatedAt time.Time, expiresAt time.Time) error {
+	const op = "storage.postgres.ActivateSigningKey"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE signing_keys SET state = $1, retired_at = $2, expires_at = $3 WHERE app_id = $4 AND state = $5 AND id <> $6`,
+		models.KeyStateRetired, activatedAt, expiresAt, appID, models.KeyStateActive, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE signing_keys SET state = $1, activates_at = $2 WHERE id = $3`,
+		models.KeyStateActive, activatedAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredSigningKeys(now time.Time) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredSigningKeys"
+
+	res, err := s.db.Exec(
+		`DELETE FROM signing_keys WHERE state = $1 AND expires_at < $2`,
+		models.KeyStateRetired, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rowsAffected, nil
+}
+
+// SaveTOTP stores a new enrollment of the user, replacing an unconfirmed
+// one.
+func (s *Storage) SaveTOTP(totp models.TOTP) error {
+	const op = "storage.postgres.SaveTOTP"
+
+	res, err := s.db.Exec(
+		`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0, last_failed_at = NULL, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL`,
+		totp.UserID, totp.Secret,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPExists)
+	}
+
+	return nil
+}
+
+func (s *Storage) TOTP(userID int64) (models.TOTP, error) {
+	const op = "storage.postgres.TOTP"
+
+	var totp models.TOTP
+	err := s.db.Get(&totp, `SELECT * FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return totp, nil
+}
+
+// ConfirmTOTP enables the enrollment of the user with the first code used.
+func (s *Storage) ConfirmTOTP(userID int64, step int64, confirmedAt time.Time) error {
+	const op = "storage.postgres.ConfirmTOTP"
+
+	res, err := s.db.Exec(
+		`UPDATE user_totp SET confirmed_at = $3, last_used_step = $2, failed_attempts = 0, last_failed_at = NULL
+		WHERE user_id = $1 AND confirmed_at IS NULL`,
+		userID, step, confirmedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records a successful code and clears the failed attempts. A
+// code of the time step or an earlier one can't be used again.
+func (s *Storage) UseTOTPStep(userID int64, step int64) error {
+	const op = "storage.postgres.UseTOTPStep"
+
+	res, err := s.db.Exec(
+		`UPDATE user_totp SET last_used_step = $2, failed_attempts = 0, last_failed_at = NULL
+		WHERE user_id = $1 AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+// FailTOTP counts a wrong code of the user.
+func (s *Storage) FailTOTP(userID int64, failedAt time.Time) error {
+	const op = "storage.postgres.FailTOTP"
+
+	_, err := s.db.Exec(
+		`UPDATE user_totp SET failed_attempts = failed_attempts + 1, last_failed_at = $2 WHERE user_id = $1`,
+		userID, failedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveMFAChallenge(challenge models.MFAChallenge) error {
+	const op = "storage.postgres.SaveMFAChallenge"
+
+	_, err := s.db.Exec(
+		`INSERT INTO mfa_challenges (id, user_id, app_id, scope, auth_time, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		challenge.ID, challenge.UserID, challenge.AppID, challenge.Scope, challenge.AuthTime, challenge.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) MFAChallenge(id string) (models.MFAChallenge, error) {
+	const op = "storage.postgres.MFAChallenge"
+
+	var challenge models.MFAChallenge
+	err := s.db.Get(&challenge, `SELECT * FROM mfa_challenges WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+		}
+		return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return challenge, nil
+}
+
+// DeleteMFAChallenge removes a completed challenge. Only one of concurrent
+// completions succeeds.
+func (s *Storage) DeleteMFAChallenge(id string) error {
+	const op = "storage.postgres.DeleteMFAChallenge"
+
+	res, err := s.db.Exec(`DELETE FROM mfa_challenges WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes stores a new set of recovery codes of the user in
+// place of the old one.
+func (s *Storage) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	const op = "storage.postgres.ReplaceRecoveryCodes"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, UNNEST($2::TEXT[])`,
+		userID, pq.StringArray(codeHashes),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode removes the code, so that every code can be used only
+// once, and clears the failed MFA attempts of the user.
+func (s *Storage) UseRecoveryCode(userID int64, codeHash string) error {
+	const op = "storage.postgres.UseRecoveryCode"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2`, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	_, err = tx.Exec(`UPDATE user_totp SET failed_attempts = 0, last_failed_at = NULL WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveAuditEvent(event models.AuditEvent) error {
+	const op = "storage.postgres.SaveAuditEvent"
+
+	_, err := s.db.Exec(
+		`INSERT INTO audit_events (user_id, event, ip, user_agent, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		event.UserID, event.Event, event.IP, event.UserAgent, event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(credential models.WebAuthnCredential) error {
+	const op = "storage.postgres.SaveWebAuthnCredential"
+
+	_, err := s.db.Exec(
+		`INSERT INTO webauthn_credentials (id, user_id, rp_id, public_key, sign_count, name) VALUES ($1, $2, $3, $4, $5, $6)`,
+		credential.ID, credential.UserID, credential.RPID, credential.PublicKey, credential.SignCount, credential.Name,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) WebAuthnCredential(id string) (models.WebAuthnCredential, error) {
+	const op = "storage.postgres.WebAuthnCredential"
+
+	var credential models.WebAuthnCredential
+	err := s.db.Get(&credential, `SELECT * FROM webauthn_credentials WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCredentialNotFound)
+		}
+		return models.WebAuthnCredential{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return credential, nil
+}
+
+// UserWebAuthnCredentials returns the credentials of the user for the
+// relying party.
+func (s *Storage) UserWebAuthnCredentials(userID int64, rpID string) ([]models.WebAuthnCredential, error) {
+	const op = "storage.postgres.UserWebAuthnCredentials"
+
+	var credentials []models.WebAuthnCredential
+	err := s.db.Select(
+		&credentials,
+		`SELECT * FROM webauthn_credentials WHERE user_id = $1 AND rp_id = $2 ORDER BY created_at`,
+		userID, rpID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return credentials, nil
+}
+
+// UseWebAuthnCredential records an authentication with the credential. The
+// sign count has to grow unless the authenticator doesn't keep one, a lower
+// count means the credential was cloned.
+func (s *Storage) UseWebAuthnCredential(id string, signCount int64, usedAt time.Time) error {
+	const op = "storage.postgres.UseWebAuthnCredential"
+
+	res, err := s.db.Exec(
+		`UPDATE webauthn_credentials SET sign_count = $2, last_used_at = $3
+		WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))`,
+		id, signCount, usedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebAuthnSignCount)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCeremony(ceremony models.WebAuthnCeremony) error {
+	const op = "storage.postgres.SaveWebAuthnCeremony"
+
+	_, err := s.db.Exec(
+		`INSERT INTO webauthn_ceremonies (id, purpose, user_id, app_id, scope, mfa_challenge_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		ceremony.ID, ceremony.Purpose, ceremony.UserID, ceremony.AppID, ceremony.Scope, ceremony.MFAChallengeID, ceremony.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseWebAuthnCeremony atomically removes the ceremony and returns it, so
+// that every challenge can be answered only once.
+func (s *Storage) UseWebAuthnCeremony(id string) (models.WebAuthnCeremony, error) {
+	const op = "storage.postgres.UseWebAuthnCeremony"
+
+	var ceremony models.WebAuthnCeremony
+	err := s.db.Get(&ceremony, `DELETE FROM webauthn_ceremonies WHERE id = $1 RETURNING *`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebAuthnCeremony{}, fmt.Errorf("%s: %w", op, storage.ErrWebAuthnCeremonyNotFound)
+		}
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ceremony, nil
+}
+
+func (s *Storage) SavePasswordlessLogin(login models.PasswordlessLogin) error {
+	const op = "storage.postgres.SavePasswordlessLogin"
+
+	_, err := s.db.Exec(
+		`INSERT INTO passwordless_logins (id, email, app_id, scope, code_hash, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		login.ID, login.Email, login.AppID, login.Scope, login.CodeHash, login.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AttemptPasswordlessLogin counts an attempt to complete the login and
+// returns it, unless maxAttempts were made already. Attempts are counted
+// before the code is checked, so that concurrent guesses can't exceed the
+// limit.
+func (s *Storage) AttemptPasswordlessLogin(id string, maxAttempts int) (models.PasswordlessLogin, error) {
+	const op = "storage.postgres.AttemptPasswordlessLogin"
+
+	var login models.PasswordlessLogin
+	err := s.db.Get(
+		&login,
+		`UPDATE passwordless_logins SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2 RETURNING *`,
+		id, maxAttempts,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordlessLogin{}, fmt.Errorf("%s: %w", op, storage.ErrPasswordlessLoginNotFound)
+		}
+		return models.PasswordlessLogin{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return login, nil
+}
+
+// DeletePasswordlessLogin removes a completed login. Only one of concurrent
+// completions succeeds.
+func (s *Storage) DeletePasswordlessLogin(id string) error {
+	const op = "storage.postgres.DeletePasswordlessLogin"
+
+	res, err := s.db.Exec(`DELETE FROM passwordless_logins WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasswordlessLoginNotFound)
+	}
+
+	return nil
+}
+
+// HitRateLimit counts a hit for the key and returns the hits counted since
+// the window of the key started. A new window of the given length starts
+// with the first hit after the previous one ended.
+func (s *Storage) HitRateLimit(key string, window time.Duration, now time.Time) (int, error) {
+	const op = "storage.postgres.HitRateLimit"
+
+	var hits int
+	err := s.db.Get(
+		&hits,
+		`INSERT INTO rate_limits (key, hits, expires_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET hits = CASE WHEN rate_limits.expires_at <= $3 THEN 1 ELSE rate_limits.hits + 1 END,
+			expires_at = CASE WHEN rate_limits.expires_at <= $3 THEN EXCLUDED.expires_at ELSE rate_limits.expires_at END
+		RETURNING hits`,
+		key, now.Add(window), now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hits, nil
+}
+
+// SaveRole creates the role with its permissions and returns its id. Names
+// are unique among the built-in roles and the roles of the app.
+func (s *Storage) SaveRole(role models.Role) (int32, error) {
+	const op = "storage.postgres.SaveRole"
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// App roles can't shadow the built-in roles of the same name.
+	var id int32
+	err = tx.QueryRow(
+		`INSERT INTO roles (name, description, app_id)
+		SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM roles WHERE name = $1 AND app_id IS NULL)
+		RETURNING id`,
+		role.Name, role.Description, role.AppID,
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+		}
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO role_permissions (role_id, permission) SELECT $1, UNNEST($2::TEXT[]) ON CONFLICT DO NOTHING`,
+		id, pq.StringArray(role.Permissions),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// Role returns the built-in role or the role of the app with the name.
+func (s *Storage) Role(name string, appID int32) (models.Role, error) {
+	const op = "storage.postgres.Role"
+
+	var role models.Role
+	err := s.db.Get(&role,
+		`SELECT `+roleColumns+` FROM roles r WHERE r.name = $1 AND (r.app_id IS NULL OR r.app_id = $2)`,
+		name, appID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Role{}, fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+		}
+		return models.Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return role, nil
+}
+
+// GrantRole gives the role to the user in the app. Granting a role the user
+// already has is a no-op.
+func (s *Storage) GrantRole(userID int64, appID int32, roleID int32, grantedAt time.Time) error {
+	const op = "storage.postgres.GrantRole"
+
+	_, err := s.db.Exec(
+		`INSERT INTO user_roles (user_id, app_id, role_id, granted_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+		userID, appID, roleID, grantedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRole(userID int64, appID int32, roleID int32) error {
+	const op = "storage.postgres.RevokeRole"
+
+	res, err := s.db.Exec(
+		`DELETE FROM user_roles WHERE user_id = $1 AND app_id = $2 AND role_id = $3`,
+		userID, appID, roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotGranted)
+	}
+
+	return nil
+}
+
+// UserRoles returns the roles of the user in the app.
+func (s *Storage) UserRoles(userID int64, appID int32) ([]models.Role, error) {
+	const op = "storage.postgres.UserRoles"
+
+	var exists bool
+	if err := s.db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	roles := []models.Role{}
+	err := s.db.Select(&roles,
+		`SELECT `+roleColumns+` FROM roles r JOIN user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = $1 AND ur.app_id = $2 ORDER BY r.name`,
+		userID, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// HasPermission reports whether any role of the user in the app carries the
+// permission.
+func (s *Storage) HasPermission(userID int64, appID int32, permission string) (bool, error) {
+	const op = "storage.postgres.HasPermission"
+
+	var ok bool
+	err := s.db.Get(&ok,
+		`SELECT EXISTS (
+			SELECT 1 FROM user_roles ur JOIN role_permissions p ON p.role_id = ur.role_id
+			WHERE ur.user_id = $1 AND ur.app_id = $2 AND p.permission = $3
+		)`,
+		userID, appID, permission,
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, nil
+}
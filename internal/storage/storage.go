@@ -6,4 +6,40 @@ var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrAppNotFound  = errors.New("app not found")
+
+	ErrTokenNotFound    = errors.New("token not found")
+	ErrTokenAlreadyUsed = errors.New("token already used")
+
+	ErrSessionNotFound = errors.New("session not found")
+
+	ErrCodeNotFound    = errors.New("authorization code not found")
+	ErrCodeAlreadyUsed = errors.New("authorization code already used")
+
+	ErrDeviceCodeNotFound = errors.New("device code not found")
+	ErrDeviceCodeExists   = errors.New("device code already exists")
+
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrIdentityExists          = errors.New("identity already linked")
+	ErrFederationStateNotFound = errors.New("federation state not found")
+
+	ErrConsentNotFound = errors.New("consent not found")
+
+	ErrEmailTokenNotFound = errors.New("email token not found")
+
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPExists           = errors.New("totp already confirmed")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already exists")
+	ErrWebAuthnSignCount          = errors.New("webauthn sign count did not increase")
+	ErrWebAuthnCeremonyNotFound   = errors.New("webauthn ceremony not found")
+
+	ErrPasswordlessLoginNotFound = errors.New("passwordless login not found")
+
+	ErrRoleNotFound   = errors.New("role not found")
+	ErrRoleExists     = errors.New("role already exists")
+	ErrRoleNotGranted = errors.New("role not granted")
 )
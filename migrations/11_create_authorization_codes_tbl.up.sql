@@ -0,0 +1,19 @@
+ALTER TABLE apps
+    ADD COLUMN redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    id                    TEXT PRIMARY KEY,
+    app_id                INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    user_id               INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family_id             TEXT      NOT NULL,
+    redirect_uri          TEXT      NOT NULL,
+    code_challenge        TEXT      NOT NULL,
+    code_challenge_method TEXT      NOT NULL,
+    ip                    TEXT      NOT NULL DEFAULT '',
+    user_agent            TEXT      NOT NULL DEFAULT '',
+    auth_time             TIMESTAMP NOT NULL,
+    expires_at            TIMESTAMP NOT NULL,
+    used_at               TIMESTAMP,
+    created_at            TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes
+    DROP COLUMN scope,
+    DROP COLUMN nonce;
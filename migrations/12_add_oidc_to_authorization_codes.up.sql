@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes
+    ADD COLUMN scope TEXT NOT NULL DEFAULT '',
+    ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    DROP COLUMN client_secret_hash,
+    DROP COLUMN client_scopes;
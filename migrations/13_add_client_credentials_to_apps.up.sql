@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    ADD COLUMN client_secret_hash TEXT,
+    ADD COLUMN client_scopes      TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS device_codes
+(
+    id             TEXT PRIMARY KEY,
+    user_code      TEXT      NOT NULL UNIQUE,
+    app_id         INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    scope          TEXT      NOT NULL DEFAULT '',
+    state          TEXT      NOT NULL CHECK (state IN ('pending', 'approved', 'denied', 'redeemed')),
+    user_id        INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    auth_time      TIMESTAMP,
+    interval       INTEGER   NOT NULL CHECK (interval > 0),
+    last_polled_at TIMESTAMP,
+    expires_at     TIMESTAMP NOT NULL,
+    created_at     TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider   TEXT      NOT NULL,
+    subject    TEXT      NOT NULL,
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS federation_states
+(
+    id                    TEXT PRIMARY KEY,
+    provider              TEXT      NOT NULL,
+    nonce                 TEXT      NOT NULL,
+    code_verifier         TEXT      NOT NULL,
+    app_id                INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    redirect_uri          TEXT      NOT NULL,
+    code_challenge        TEXT      NOT NULL,
+    code_challenge_method TEXT      NOT NULL,
+    scope                 TEXT      NOT NULL DEFAULT '',
+    client_nonce          TEXT      NOT NULL DEFAULT '',
+    client_state          TEXT      NOT NULL DEFAULT '',
+    expires_at            TIMESTAMP NOT NULL,
+    created_at            TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS consents
+(
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    scopes     TEXT[]    NOT NULL DEFAULT '{}',
+    granted_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, app_id)
+);
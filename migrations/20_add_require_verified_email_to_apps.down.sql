@@ -0,0 +1,2 @@
+ALTER TABLE apps
+    DROP COLUMN require_verified_email;
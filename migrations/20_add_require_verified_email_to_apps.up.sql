@@ -0,0 +1,2 @@
+ALTER TABLE apps
+    ADD COLUMN require_verified_email BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS email_tokens
+(
+    id         TEXT PRIMARY KEY,
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    TEXT      NOT NULL,
+    email      TEXT      NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_user_id ON email_tokens (user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id         INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret          TEXT      NOT NULL,
+    confirmed_at    TIMESTAMP,
+    last_used_step  BIGINT    NOT NULL DEFAULT 0,
+    failed_attempts INTEGER   NOT NULL DEFAULT 0,
+    last_failed_at  TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    id         TEXT PRIMARY KEY,
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    scope      TEXT      NOT NULL DEFAULT '',
+    auth_time  TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code_hash)
+);
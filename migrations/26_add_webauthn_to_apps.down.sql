@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    DROP COLUMN webauthn_rp_id,
+    DROP COLUMN webauthn_origins;
@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    ADD COLUMN webauthn_rp_id   TEXT,
+    ADD COLUMN webauthn_origins TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id           TEXT PRIMARY KEY,
+    user_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rp_id        TEXT      NOT NULL,
+    public_key   BYTEA     NOT NULL,
+    sign_count   BIGINT    NOT NULL DEFAULT 0,
+    name         TEXT      NOT NULL DEFAULT '',
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id, rp_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies
+(
+    id               TEXT PRIMARY KEY,
+    purpose          TEXT      NOT NULL,
+    user_id          INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    app_id           INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    scope            TEXT      NOT NULL DEFAULT '',
+    mfa_challenge_id TEXT      NOT NULL DEFAULT '',
+    expires_at       TIMESTAMP NOT NULL,
+    created_at       TIMESTAMP NOT NULL DEFAULT NOW()
+);
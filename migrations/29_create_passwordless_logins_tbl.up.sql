@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS passwordless_logins
+(
+    id         TEXT PRIMARY KEY,
+    email      TEXT      NOT NULL,
+    app_id     INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    scope      TEXT      NOT NULL DEFAULT '',
+    code_hash  TEXT      NOT NULL,
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
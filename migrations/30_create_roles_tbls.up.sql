@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          SERIAL PRIMARY KEY,
+    name        TEXT      NOT NULL UNIQUE,
+    description TEXT      NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id    INTEGER NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission TEXT    NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role_id    INTEGER   NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    granted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, app_id, role_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (name, description)
+VALUES ('admin', 'Manages roles, sessions and clients of the app'),
+       ('moderator', 'Manages sessions of users of the app'),
+       ('common', 'Regular user')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+         JOIN (VALUES ('admin', 'roles:manage'),
+                      ('admin', 'sessions:manage'),
+                      ('admin', 'clients:manage'),
+                      ('admin', 'users:impersonate'),
+                      ('moderator', 'sessions:manage')) AS p (role, permission) ON p.role = r.name
+ON CONFLICT DO NOTHING;
+
+-- Admins become admins of every existing app.
+INSERT INTO user_roles (user_id, app_id, role_id)
+SELECT u.id, a.id, r.id
+FROM users u
+         CROSS JOIN apps a
+         JOIN roles r ON r.name = 'admin'
+WHERE u.is_admin
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users
+    DROP COLUMN is_admin;
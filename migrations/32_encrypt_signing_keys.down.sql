@@ -0,0 +1,4 @@
+DELETE FROM signing_keys;
+
+ALTER TABLE signing_keys
+    ALTER COLUMN private_key TYPE BYTEA USING private_key::BYTEA;
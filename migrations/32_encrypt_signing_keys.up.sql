@@ -0,0 +1,6 @@
+-- Private keys used to be stored in plaintext. They can't be encrypted here,
+-- so they are dropped and rotation creates new ones on the next sync.
+DELETE FROM signing_keys;
+
+ALTER TABLE signing_keys
+    ALTER COLUMN private_key TYPE TEXT;
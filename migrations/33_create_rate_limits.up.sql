@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limits
+(
+    key        TEXT PRIMARY KEY,
+    hits       INTEGER   NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
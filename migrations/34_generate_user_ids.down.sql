@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ALTER COLUMN id DROP IDENTITY IF EXISTS;
@@ -0,0 +1,7 @@
+-- users.id had no default, so inserts without an id failed.
+ALTER TABLE users
+    ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY;
+
+-- Start after the users that were inserted with an explicit id.
+SELECT setval(pg_get_serial_sequence('users', 'id'), COALESCE(MAX(id), 0) + 1, false)
+FROM users;
@@ -0,0 +1,10 @@
+DELETE FROM roles
+WHERE app_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_roles_app_name;
+DROP INDEX IF EXISTS idx_roles_global_name;
+ALTER TABLE roles
+    ADD CONSTRAINT roles_name_key UNIQUE (name);
+
+ALTER TABLE roles
+    DROP COLUMN app_id;
@@ -0,0 +1,10 @@
+-- Roles are created within an app and can only be granted there. Built-in
+-- roles have no app and can be granted in every app. Roles created before
+-- are kept that way, since it isn't known which app created them.
+ALTER TABLE roles
+    ADD COLUMN app_id INTEGER REFERENCES apps (id) ON DELETE CASCADE;
+
+ALTER TABLE roles
+    DROP CONSTRAINT IF EXISTS roles_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_global_name ON roles (name) WHERE app_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_app_name ON roles (app_id, name) WHERE app_id IS NOT NULL;
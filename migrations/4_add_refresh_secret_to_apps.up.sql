@@ -0,0 +1,2 @@
+ALTER TABLE apps
+    ADD COLUMN refresh_secret TEXT NOT NULL DEFAULT gen_random_uuid()::TEXT;
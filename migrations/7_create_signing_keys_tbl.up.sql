@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    id           TEXT PRIMARY KEY,
+    app_id       INTEGER   NOT NULL DEFAULT 0,
+    algorithm    TEXT      NOT NULL,
+    private_key  BYTEA     NOT NULL,
+    state        TEXT      NOT NULL CHECK (state IN ('pending', 'active', 'retired')),
+    activates_at TIMESTAMP NOT NULL,
+    retired_at   TIMESTAMP,
+    expires_at   TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_app_id ON signing_keys (app_id);
@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    ADD COLUMN claims_template JSONB NOT NULL DEFAULT '{}'
+        CHECK (octet_length(claims_template::TEXT) <= 4096);
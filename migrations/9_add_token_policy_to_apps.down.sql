@@ -0,0 +1,5 @@
+ALTER TABLE apps
+    DROP COLUMN access_ttl,
+    DROP COLUMN refresh_ttl,
+    DROP COLUMN session_lifetime,
+    DROP COLUMN idle_timeout;
@@ -0,0 +1,5 @@
+ALTER TABLE apps
+    ADD COLUMN access_ttl       INTEGER CHECK (access_ttl > 0 AND access_ttl <= 86400),
+    ADD COLUMN refresh_ttl      INTEGER CHECK (refresh_ttl >= 0),
+    ADD COLUMN session_lifetime INTEGER CHECK (session_lifetime > 0),
+    ADD COLUMN idle_timeout     INTEGER CHECK (idle_timeout > 0);
@@ -0,0 +1,3 @@
+module github.com/nikitauty/protos
+
+go 1.23.2
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestIntrospect_ActiveToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.Introspect(ctx, introspectRequest(respLog.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, resp.GetActive())
+	assert.Equal(t, int32(appID), resp.GetAppId())
+	assert.NotEmpty(t, resp.GetUserId())
+	assert.NotEmpty(t, resp.GetJti())
+	assert.Equal(t, strconv.FormatInt(resp.GetUserId(), 10), resp.GetSub())
+	assert.Equal(t, []string{strconv.Itoa(appID)}, resp.GetAud())
+	assert.Equal(t, st.Cfg.Issuer, resp.GetIss())
+	assert.Equal(t, "Bearer", resp.GetTokenType())
+}
+
+func TestIntrospect_RevokedToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.Introspect(ctx, introspectRequest(respLog.GetToken()))
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+	assert.Empty(t, resp.GetUserId())
+}
+
+func TestIntrospect_MalformedToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.Introspect(ctx, introspectRequest("token"))
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+}
+
+func TestIntrospect_ClientToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	client, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+
+	// Resource servers can authenticate with the token they got for
+	// themselves instead of the client credentials.
+	resp, err := st.AuthClient.Introspect(withAccessToken(ctx, client.GetToken()), &ssov1.IntrospectRequest{
+		Token: respLog.GetToken(),
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.GetActive())
+}
+
+func TestIntrospect_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	tests := []struct {
+		name string
+		ctx  func() context.Context
+		req  *ssov1.IntrospectRequest
+	}{
+		{
+			name: "Without credentials",
+			req:  &ssov1.IntrospectRequest{Token: respLog.GetToken()},
+		},
+		{
+			name: "Wrong client secret",
+			req: &ssov1.IntrospectRequest{
+				Token:        respLog.GetToken(),
+				ClientId:     strconv.Itoa(appID),
+				ClientSecret: "wrong",
+			},
+		},
+		{
+			name: "Public client",
+			req: &ssov1.IntrospectRequest{
+				Token:    respLog.GetToken(),
+				ClientId: strconv.Itoa(verifiedEmailAppID),
+			},
+		},
+		{
+			name: "User access token",
+			ctx:  func() context.Context { return withAccessToken(ctx, respLog.GetToken()) },
+			req:  &ssov1.IntrospectRequest{Token: respLog.GetToken()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCtx := ctx
+			if tt.ctx != nil {
+				reqCtx = tt.ctx()
+			}
+
+			_, err := st.AuthClient.Introspect(reqCtx, tt.req)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, "invalid client credentials")
+		})
+	}
+}
+
+func TestIntrospect_Endpoint(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/introspect"), strings.NewReader(url.Values{
+			"token": {respLog.GetToken()},
+		}.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return req
+	}
+
+	resp, err := http.DefaultClient.Do(newRequest())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req := newRequest()
+	req.SetBasicAuth(strconv.Itoa(appID), clientSecret)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Active    bool     `json:"active"`
+		Sub       string   `json:"sub"`
+		Aud       []string `json:"aud"`
+		Iss       string   `json:"iss"`
+		TokenType string   `json:"token_type"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.True(t, body.Active)
+	assert.NotEmpty(t, body.Sub)
+	assert.Equal(t, []string{strconv.Itoa(appID)}, body.Aud)
+	assert.Equal(t, st.Cfg.Issuer, body.Iss)
+	assert.Equal(t, "Bearer", body.TokenType)
+}
+
+// introspectRequest asks about the token as the test app, only resource
+// servers may introspect tokens.
+func introspectRequest(token string) *ssov1.IntrospectRequest {
+	return &ssov1.IntrospectRequest{
+		Token:        token,
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"sso/tests/suite"
+)
+
+func TestLogout_RevokesRefreshToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestLogoutAll_RevokesEverySession(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	var sessions []*ssov1.LoginResponse
+	for range 2 {
+		respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+			Email:    email,
+			Password: password,
+			AppId:    appID,
+		})
+		require.NoError(t, err)
+		sessions = append(sessions, respLog)
+	}
+
+	_, err = st.AuthClient.LogoutAll(withAccessToken(ctx, sessions[0].GetToken()), &ssov1.LogoutAllRequest{})
+	require.NoError(t, err)
+
+	for _, session := range sessions {
+		_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+			RefreshToken: session.GetRefreshToken(),
+			AppId:        appID,
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "invalid refresh token")
+	}
+}
+
+func TestLogoutAll_WithoutAccessToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.LogoutAll(ctx, &ssov1.LogoutAllRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "access token is required")
+}
+
+func registerAndLogin(ctx context.Context, t *testing.T, st *suite.Suite) *ssov1.LoginResponse {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	return respLog
+}
+
+func withAccessToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
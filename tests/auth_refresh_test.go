@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestRefresh_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respLog.GetRefreshToken())
+
+	respRefresh, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respRefresh.GetToken())
+	assert.NotEmpty(t, respRefresh.GetRefreshToken())
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	rotated, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: rotated.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestRefresh_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		appID        int32
+		expectedErr  string
+	}{
+		{
+			name:         "Refresh with Empty Token",
+			refreshToken: "",
+			appID:        appID,
+			expectedErr:  "refresh token is required",
+		},
+		{
+			name:         "Refresh without AppID",
+			refreshToken: "token",
+			appID:        emptyAppID,
+			expectedErr:  "wrong app id",
+		},
+		{
+			name:         "Refresh with Malformed Token",
+			refreshToken: "token",
+			appID:        appID,
+			expectedErr:  "invalid refresh token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+				RefreshToken: tt.refreshToken,
+				AppId:        tt.appID,
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
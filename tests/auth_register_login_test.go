@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"sso/tests/suite"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -14,7 +15,7 @@ import (
 const (
 	emptyAppID        = 0
 	appID             = 1
-	appSecret         = "secret"
+	appSecret         = "sso_secret"
 	passDefaultLength = 10
 )
 
@@ -53,12 +54,17 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	claims, ok := tokenParsed.Claims.(jwt.MapClaims)
 	assert.True(t, ok)
 
-	assert.Equal(t, respReg.GetUserId(), int64(claims["uid"].(float64)))
-	assert.Equal(t, email, claims["email"].(string))
+	assert.Equal(t, strconv.FormatInt(respReg.GetUserId(), 10), claims["sub"].(string))
+	assert.Equal(t, respReg.GetUserId(), int64(claims["user_id"].(float64)))
 	assert.Equal(t, appID, int(claims["app_id"].(float64)))
+	assert.Equal(t, st.Cfg.Issuer, claims["iss"].(string))
+	assert.Equal(t, []interface{}{strconv.Itoa(appID)}, claims["aud"])
+	assert.NotEmpty(t, claims["jti"])
 
 	const deltaSeconds = 1
 
+	assert.InDelta(t, loginTime.Unix(), claims["iat"].(float64), deltaSeconds)
+	assert.InDelta(t, loginTime.Unix(), claims["nbf"].(float64), deltaSeconds)
 	assert.InDelta(t, loginTime.Add(st.Cfg.TokenTTL).Unix(), claims["exp"].(float64), deltaSeconds)
 }
 
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestListSessions_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ListSessions(withAccessToken(ctx, respLog.GetToken()), &ssov1.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 1)
+
+	session := resp.GetSessions()[0]
+	assert.NotEmpty(t, session.GetId())
+	assert.Equal(t, int32(appID), session.GetAppId())
+	assert.NotEmpty(t, session.GetIp())
+	assert.NotEmpty(t, session.GetUserAgent())
+	assert.True(t, session.GetCurrent())
+	assert.Greater(t, session.GetExpiresAt(), session.GetCreatedAt())
+}
+
+func TestRevokeSession_RevokesRefreshToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authCtx := withAccessToken(ctx, respLog.GetToken())
+
+	resp, err := st.AuthClient.ListSessions(authCtx, &ssov1.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 1)
+
+	_, err = st.AuthClient.RevokeSession(authCtx, &ssov1.RevokeSessionRequest{
+		SessionId: resp.GetSessions()[0].GetId(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+
+	// The access token belongs to the revoked session as well.
+	_, err = st.AuthClient.ListSessions(authCtx, &ssov1.ListSessionsRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid access token")
+}
+
+func TestRevokeSession_OtherUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	victim := registerAndLogin(ctx, t, st)
+	attacker := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ListSessions(withAccessToken(ctx, victim.GetToken()), &ssov1.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 1)
+
+	_, err = st.AuthClient.RevokeSession(withAccessToken(ctx, attacker.GetToken()), &ssov1.RevokeSessionRequest{
+		SessionId: resp.GetSessions()[0].GetId(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "session not found")
+}
+
+func TestAdminSessions_OwnAppOnly(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	userID, email, password := registerUser(ctx, t, st)
+
+	_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	other, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: exchangeTargetAppID})
+	require.NoError(t, err)
+
+	// The token of the seeded admin only manages sessions of the test app.
+	resp, err := st.AuthClient.AdminListSessions(adminCtx, &ssov1.AdminListSessionsRequest{UserId: userID})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 1)
+	assert.Equal(t, int32(appID), resp.GetSessions()[0].GetAppId())
+
+	otherSessions, err := st.AuthClient.ListSessions(withAccessToken(ctx, other.GetToken()), &ssov1.ListSessionsRequest{})
+	require.NoError(t, err)
+
+	var otherID string
+	for _, session := range otherSessions.GetSessions() {
+		if session.GetCurrent() {
+			otherID = session.GetId()
+		}
+	}
+	require.NotEmpty(t, otherID)
+
+	_, err = st.AuthClient.AdminRevokeSession(adminCtx, &ssov1.RevokeSessionRequest{SessionId: otherID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "session not found")
+
+	_, err = st.AuthClient.AdminRevokeSession(adminCtx, &ssov1.RevokeSessionRequest{SessionId: resp.GetSessions()[0].GetId()})
+	require.NoError(t, err)
+}
+
+func TestAdminListSessions_NotAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.AdminListSessions(withAccessToken(ctx, respLog.GetToken()), &ssov1.AdminListSessionsRequest{
+		UserId: 1,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestChangePassword_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	var sessions []*ssov1.LoginResponse
+	for range 2 {
+		respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+		require.NoError(t, err)
+		sessions = append(sessions, respLog)
+	}
+
+	newPassword := randomFakePassword()
+
+	_, err = st.AuthClient.ChangePassword(withAccessToken(ctx, sessions[0].GetToken()), &ssov1.ChangePasswordRequest{
+		CurrentPassword: password,
+		NewPassword:     newPassword,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid email or password")
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: newPassword, AppId: appID})
+	require.NoError(t, err)
+
+	// The session that changed the password stays, the other one ends.
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: sessions[0].GetRefreshToken(), AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: sessions[1].GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.ChangePassword(withAccessToken(ctx, respLog.GetToken()), &ssov1.ChangePasswordRequest{
+		CurrentPassword: randomFakePassword(),
+		NewPassword:     randomFakePassword(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid password")
+}
+
+func TestChangePassword_WithoutAccessToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+		CurrentPassword: randomFakePassword(),
+		NewPassword:     randomFakePassword(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "access token is required")
+}
+
+func TestChangeEmail_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, email)})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	other, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	sent := len(mailFiles(t, st, email))
+	newEmail := gofakeit.Email()
+
+	_, err = st.AuthClient.ChangeEmail(withAccessToken(ctx, respLog.GetToken()), &ssov1.ChangeEmailRequest{
+		Password: password,
+		NewEmail: newEmail,
+	})
+	require.NoError(t, err)
+
+	// The old email is told about the change.
+	assert.Len(t, mailFiles(t, st, email), sent+1)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.Error(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: other.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+
+	// The new email has to be verified again.
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: newEmail, Password: password, AppId: verifiedEmailAppID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "email is not verified")
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, newEmail)})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: newEmail, Password: password, AppId: verifiedEmailAppID})
+	require.NoError(t, err)
+}
+
+func TestChangeEmail_Taken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	taken := gofakeit.Email()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: taken, Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ChangeEmail(withAccessToken(ctx, respLog.GetToken()), &ssov1.ChangeEmailRequest{
+		Password: password,
+		NewEmail: taken,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "user already exists")
+}
+
+func TestChangeEmail_WrongPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.ChangeEmail(withAccessToken(ctx, respLog.GetToken()), &ssov1.ChangeEmailRequest{
+		Password: randomFakePassword(),
+		NewEmail: gofakeit.Email(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid password")
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+const clientSecret = "sso_client_secret"
+
+func TestClientCredentials_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Scopes:       []string{"reports:read"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetToken())
+	assert.Positive(t, resp.GetExpiresIn())
+	assert.Equal(t, []string{"reports:read"}, resp.GetScopes())
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, strconv.Itoa(appID), info.GetClientId())
+	assert.Zero(t, info.GetUserId())
+	assert.Equal(t, []string{"reports:read"}, info.GetScopes())
+}
+
+func TestClientCredentials_AllScopesByDefault(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"reports:read", "reports:write", "token_exchange"}, resp.GetScopes())
+}
+
+func TestClientCredentials_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name        string
+		clientID    string
+		secret      string
+		scopes      []string
+		expectedErr string
+	}{
+		{
+			name:        "Wrong secret",
+			clientID:    strconv.Itoa(appID),
+			secret:      "wrong",
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name:        "Unknown client",
+			clientID:    "999",
+			secret:      clientSecret,
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name:        "Scope not allowed",
+			clientID:    strconv.Itoa(appID),
+			secret:      clientSecret,
+			scopes:      []string{"admin"},
+			expectedErr: "invalid scope",
+		},
+		{
+			name:        "Empty secret",
+			clientID:    strconv.Itoa(appID),
+			expectedErr: "client_secret is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+				ClientId:     tt.clientID,
+				ClientSecret: tt.secret,
+				Scopes:       tt.scopes,
+			})
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestClientCredentials_NotAUserToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ListSessions(withAccessToken(ctx, resp.GetToken()), &ssov1.ListSessionsRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid access token")
+}
+
+func TestClientCredentials_TokenEndpoint(t *testing.T) {
+	_, st := suite.New(t)
+
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/token"), strings.NewReader(url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"reports:write"},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(strconv.Itoa(appID), clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotEmpty(t, body.AccessToken)
+	assert.Empty(t, body.RefreshToken)
+}
+
+func TestRegisterClient_NotAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.RegisterClient(withAccessToken(ctx, respLog.GetToken()), &ssov1.RegisterClientRequest{
+		AppId:  appID,
+		Scopes: []string{"reports:read"},
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
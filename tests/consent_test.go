@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestConsent_LoginWithScopes(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+		Scopes:   []string{"reports:read", "profile", "profile"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"profile", "reports:read"}, respLog.GetScopes())
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(respLog.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"profile", "reports:read"}, info.GetScopes())
+
+	// Refreshed tokens keep the scopes.
+	respRefresh, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	info, err = st.AuthClient.Introspect(ctx, introspectRequest(respRefresh.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"profile", "reports:read"}, info.GetScopes())
+
+	consents, err := st.AuthClient.ListConsents(withAccessToken(ctx, respLog.GetToken()), &ssov1.ListConsentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, consents.GetConsents(), 1)
+	assert.Equal(t, int32(appID), consents.GetConsents()[0].GetAppId())
+	assert.Equal(t, []string{"profile", "reports:read"}, consents.GetConsents()[0].GetScopes())
+}
+
+func TestConsent_UndeclaredScope(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+		Scopes:   []string{"reports:write"},
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid scope")
+}
+
+func TestConsent_Revoke(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	scoped, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+		Scopes:   []string{"reports:read"},
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RevokeConsent(withAccessToken(ctx, scoped.GetToken()), &ssov1.RevokeConsentRequest{AppId: appID})
+	require.NoError(t, err)
+
+	// The sessions of the user in the app end with the consent.
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(scoped.GetToken()))
+	require.NoError(t, err)
+	assert.False(t, info.GetActive())
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: scoped.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	consents, err := st.AuthClient.ListConsents(withAccessToken(ctx, respLog.GetToken()), &ssov1.ListConsentsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, consents.GetConsents())
+
+	_, err = st.AuthClient.RevokeConsent(withAccessToken(ctx, respLog.GetToken()), &ssov1.RevokeConsentRequest{AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "consent not found")
+}
+
+func TestConsent_AuthorizationCodeScopes(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	verifier, challenge := pkcePair(t)
+
+	code := authorizeUser(ctx, t, st, challenge, url.Values{"scope": {"openid reports:read"}})
+
+	status, tokens := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "openid reports:read", tokens.Scope)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(tokens.AccessToken))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid", "reports:read"}, info.GetScopes())
+}
+
+func TestConsent_LoginPageListsScopes(t *testing.T) {
+	_, st := suite.New(t)
+
+	_, challenge := pkcePair(t)
+
+	resp, err := http.Get(st.HTTPURL("/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"scope":                 {"openid reports:read"},
+	}.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "<li>reports:read</li>")
+	assert.NotContains(t, string(body), "<li>openid</li>")
+}
+
+func TestConsent_AuthorizeFailCases(t *testing.T) {
+	_, st := suite.New(t)
+
+	_, challenge := pkcePair(t)
+
+	tests := []struct {
+		name          string
+		method        string
+		params        url.Values
+		expectedError string
+	}{
+		{
+			name:          "Undeclared scope",
+			method:        http.MethodGet,
+			params:        url.Values{"scope": {"reports:write"}},
+			expectedError: "invalid_scope",
+		},
+		{
+			name:          "Denied by the user",
+			method:        http.MethodPost,
+			params:        url.Values{"scope": {"reports:read"}, "deny": {"1"}},
+			expectedError: "access_denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := url.Values{
+				"response_type":         {"code"},
+				"client_id":             {strconv.Itoa(appID)},
+				"redirect_uri":          {redirectURI},
+				"code_challenge":        {challenge},
+				"code_challenge_method": {"S256"},
+			}
+			for name, values := range tt.params {
+				params[name] = values
+			}
+
+			var (
+				resp *http.Response
+				err  error
+			)
+			if tt.method == http.MethodGet {
+				resp, err = noRedirectClient.Get(st.HTTPURL("/authorize?" + params.Encode()))
+			} else {
+				resp, err = noRedirectClient.PostForm(st.HTTPURL("/authorize"), params)
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Equal(t, http.StatusFound, resp.StatusCode)
+
+			location, err := url.Parse(resp.Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedError, location.Query().Get("error"))
+			assert.Empty(t, location.Query().Get("code"))
+		})
+	}
+}
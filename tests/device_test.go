@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+func TestDeviceFlow_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	da := authorizeDevice(t, st)
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, da.UserCode)
+	assert.Equal(t, strings.TrimSuffix(st.Cfg.Issuer, "/")+"/device", da.VerificationURI)
+	assert.Positive(t, da.ExpiresIn)
+	assert.Positive(t, da.Interval)
+
+	status, resp := pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "authorization_pending", resp.Error)
+
+	login := registerAndLogin(ctx, t, st)
+
+	// User codes are accepted as typed, in lower case and without the dash.
+	_, err := st.AuthClient.ApproveDevice(withAccessToken(ctx, login.GetToken()), &ssov1.ApproveDeviceRequest{
+		UserCode: " " + strings.ToLower(da.UserCode[:4]+da.UserCode[5:]) + " ",
+		Approve:  true,
+	})
+	require.NoError(t, err)
+
+	status, resp = pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, resp.AccessToken)
+	assert.NotEmpty(t, resp.RefreshToken)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.AccessToken))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, int32(appID), info.GetAppId())
+
+	// The device code is single-use.
+	status, resp = pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", resp.Error)
+}
+
+func TestDeviceFlow_SlowDown(t *testing.T) {
+	_, st := suite.New(t)
+
+	da := authorizeDevice(t, st)
+
+	status, resp := pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "authorization_pending", resp.Error)
+
+	status, resp = pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "slow_down", resp.Error)
+}
+
+func TestDeviceFlow_DeniedOnVerificationPage(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	da := authorizeDevice(t, st)
+
+	page, err := http.Get(da.VerificationURIComplete)
+	require.NoError(t, err)
+	page.Body.Close()
+	require.Equal(t, http.StatusOK, page.StatusCode)
+
+	page, err = http.PostForm(st.HTTPURL("/device"), url.Values{
+		"user_code": {da.UserCode},
+		"email":     {email},
+		"password":  {password},
+		"action":    {"deny"},
+	})
+	require.NoError(t, err)
+	page.Body.Close()
+	require.Equal(t, http.StatusOK, page.StatusCode)
+
+	status, resp := pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "access_denied", resp.Error)
+
+	// A decided code can't be approved anymore.
+	login := registerAndLogin(ctx, t, st)
+	_, err = st.AuthClient.ApproveDevice(withAccessToken(ctx, login.GetToken()), &ssov1.ApproveDeviceRequest{
+		UserCode: da.UserCode,
+		Approve:  true,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "user code not found or expired")
+}
+
+func TestDeviceFlow_ApprovalNeedsTokenOfTheApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	da := authorizeDevice(t, st)
+
+	_, email, password := registerUser(ctx, t, st)
+
+	// Tokens of another app can't approve the code, nor deny it.
+	other, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: exchangeTargetAppID})
+	require.NoError(t, err)
+
+	for _, approve := range []bool{true, false} {
+		_, err = st.AuthClient.ApproveDevice(withAccessToken(ctx, other.GetToken()), &ssov1.ApproveDeviceRequest{
+			UserCode: da.UserCode,
+			Approve:  approve,
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "the access token can't approve the device code")
+	}
+
+	// The device asked for full access, which a narrowed token doesn't grant.
+	narrowed, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+		Scopes:   []string{"profile"},
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ApproveDevice(withAccessToken(ctx, narrowed.GetToken()), &ssov1.ApproveDeviceRequest{
+		UserCode: da.UserCode,
+		Approve:  true,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "the access token can't approve the device code")
+
+	status, resp := pollDeviceToken(t, st, da.DeviceCode)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "authorization_pending", resp.Error)
+}
+
+func TestDeviceFlow_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	login := registerAndLogin(ctx, t, st)
+
+	_, err := st.AuthClient.ApproveDevice(withAccessToken(ctx, login.GetToken()), &ssov1.ApproveDeviceRequest{
+		UserCode: "BCDF-GHJK",
+		Approve:  true,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "user code not found or expired")
+
+	_, err = st.AuthClient.ApproveDevice(ctx, &ssov1.ApproveDeviceRequest{
+		UserCode: "BCDF-GHJK",
+		Approve:  true,
+	})
+	require.Error(t, err)
+
+	status, resp := pollDeviceToken(t, st, "unknown")
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", resp.Error)
+}
+
+func authorizeDevice(t *testing.T, st *suite.Suite) deviceAuthorizationResponse {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL("/device_authorization"), url.Values{
+		"client_id": {strconv.Itoa(appID)},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body deviceAuthorizationResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotEmpty(t, body.DeviceCode)
+
+	return body
+}
+
+func pollDeviceToken(t *testing.T, st *suite.Suite, deviceCode string) (int, tokenResponse) {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL("/token"), url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"client_id":   {strconv.Itoa(appID)},
+		"device_code": {deviceCode},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp.StatusCode, body
+}
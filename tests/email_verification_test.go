@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+// verifiedEmailAppID requires verified emails, see tests/migrations.
+const verifiedEmailAppID = 2
+
+var mailTokenRe = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func TestEmailVerification_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	// Apps that don't require verified emails let the user in right away.
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: verifiedEmailAppID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "email is not verified")
+
+	token := lastMailToken(t, st, email)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: token})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: verifiedEmailAppID})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respLog.GetToken())
+
+	// Tokens can be used once.
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: token})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestEmailVerification_Resend(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	first := lastMailToken(t, st, email)
+
+	_, err = st.AuthClient.ResendVerification(ctx, &ssov1.ResendVerificationRequest{Email: email})
+	require.NoError(t, err)
+
+	second := lastMailToken(t, st, email)
+	assert.NotEqual(t, first, second)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: second})
+	require.NoError(t, err)
+}
+
+func TestEmailVerification_ResendUnknownEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.ResendVerification(ctx, &ssov1.ResendVerificationRequest{Email: gofakeit.Email()})
+	require.NoError(t, err)
+}
+
+func TestEmailVerification_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: gofakeit.UUID()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired token")
+}
+
+// lastMailToken returns the token linked in the last mail sent to the email
+// by the file mail sender configured in config/local.yaml.
+func lastMailToken(t *testing.T, st *suite.Suite, email string) string {
+	t.Helper()
+
+	body := lastMail(t, st, email)
+
+	match := mailTokenRe.FindStringSubmatch(body)
+	require.NotNil(t, match, "no token in mail to %s", email)
+
+	return match[1]
+}
+
+// lastMail reads the newest mail sent to the email.
+func lastMail(t *testing.T, st *suite.Suite, email string) string {
+	t.Helper()
+
+	files := mailFiles(t, st, email)
+	require.NotEmpty(t, files, "no mail sent to %s", email)
+
+	// File names end with the time the mail was sent.
+	last := slices.MaxFunc(files, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), strings.Compare(a, b))
+	})
+
+	body, err := os.ReadFile(last)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+// mailFiles lists the mails sent to the email.
+func mailFiles(t *testing.T, st *suite.Suite, email string) []string {
+	t.Helper()
+
+	// The server runs from the repository root, the tests from tests/.
+	files, err := filepath.Glob(filepath.Join("..", st.Cfg.Mail.Dir, email+"-*.eml"))
+	require.NoError(t, err)
+
+	return files
+}
@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+// The fake identity provider is configured as "test" in config/local.yaml.
+const (
+	fakeProviderAddr   = "localhost:5447"
+	fakeProviderIssuer = "http://" + fakeProviderAddr
+	fakeClientID       = "sso-test"
+	fakeClientSecret   = "sso-test-secret"
+)
+
+type fakeIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	nonce         string
+}
+
+var (
+	fakeProviderOnce sync.Once
+	fakeProviderErr  error
+	// fakeCodes maps the codes the fake provider issued to identities.
+	fakeCodes sync.Map
+)
+
+func TestFederatedLogin_NewUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	identity := fakeIdentity{Subject: gofakeit.UUID(), Email: gofakeit.Email(), EmailVerified: true}
+
+	userID := federatedLogin(ctx, t, st, identity)
+	assert.Positive(t, userID)
+
+	// The identity stays linked to the user.
+	assert.Equal(t, userID, federatedLogin(ctx, t, st, identity))
+}
+
+func TestFederatedLogin_LinksVerifiedEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	registered, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: randomFakePassword(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, email)})
+	require.NoError(t, err)
+
+	userID := federatedLogin(ctx, t, st, fakeIdentity{Subject: gofakeit.UUID(), Email: email, EmailVerified: true})
+	assert.Equal(t, registered.GetUserId(), userID)
+}
+
+func TestFederatedLogin_UnverifiedAccount(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	// Anyone can register an email they don't own, so the account isn't
+	// linked to the identity until its email is verified.
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: randomFakePassword(),
+	})
+	require.NoError(t, err)
+
+	_, challenge := pkcePair(t)
+	location := completeFederatedLogin(t, st, challenge, fakeIdentity{Subject: gofakeit.UUID(), Email: email, EmailVerified: true})
+	assert.Equal(t, "access_denied", location.Get("error"))
+	assert.Empty(t, location.Get("code"))
+}
+
+func TestFederatedLogin_MFARequired(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _, _, _ := enableTOTP(ctx, t, st)
+
+	_, err := st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, email)})
+	require.NoError(t, err)
+
+	// The provider can't vouch for the second factor.
+	_, challenge := pkcePair(t)
+	location := completeFederatedLogin(t, st, challenge, fakeIdentity{Subject: gofakeit.UUID(), Email: email, EmailVerified: true})
+	assert.Equal(t, "access_denied", location.Get("error"))
+	assert.Contains(t, location.Get("error_description"), "second factor")
+	assert.Empty(t, location.Get("code"))
+}
+
+func TestFederatedLogin_UnverifiedEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: randomFakePassword(),
+	})
+	require.NoError(t, err)
+
+	_, challenge := pkcePair(t)
+	location := completeFederatedLogin(t, st, challenge, fakeIdentity{Subject: gofakeit.UUID(), Email: email})
+	assert.Equal(t, "access_denied", location.Get("error"))
+	assert.Empty(t, location.Get("code"))
+}
+
+func TestFederatedLogin_InvalidState(t *testing.T) {
+	_, st := suite.New(t)
+
+	resp, err := noRedirectClient.Get(st.HTTPURL("/login/test/callback?" + url.Values{
+		"code":  {"code"},
+		"state": {"unknown"},
+	}.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFederatedLogin_LoginPageLinksProvider(t *testing.T) {
+	_, st := suite.New(t)
+
+	_, challenge := pkcePair(t)
+
+	resp, err := http.Get(st.HTTPURL("/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `href="/login/test?`)
+}
+
+// federatedLogin signs in with the fake provider and returns the id of the
+// user the tokens were issued for.
+func federatedLogin(ctx context.Context, t *testing.T, st *suite.Suite, identity fakeIdentity) int64 {
+	t.Helper()
+
+	verifier, challenge := pkcePair(t)
+
+	location := completeFederatedLogin(t, st, challenge, identity)
+	require.Empty(t, location.Get("error"))
+
+	status, tokens := exchangeCode(t, st, location.Get("code"), verifier)
+	require.Equal(t, http.StatusOK, status)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(tokens.AccessToken))
+	require.NoError(t, err)
+	require.True(t, info.GetActive())
+
+	return info.GetUserId()
+}
+
+// completeFederatedLogin goes through the redirects of a federated login
+// and returns the query of the final redirect to the client.
+func completeFederatedLogin(t *testing.T, st *suite.Suite, challenge string, identity fakeIdentity) url.Values {
+	t.Helper()
+
+	startFakeProvider(t)
+
+	clientState := gofakeit.UUID()
+
+	resp, err := noRedirectClient.Get(st.HTTPURL("/login/test?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"state":                 {clientState},
+	}.Encode()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	providerURL, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, fakeProviderIssuer+"/authorize", providerURL.Scheme+"://"+providerURL.Host+providerURL.Path)
+	assert.Equal(t, fakeClientID, providerURL.Query().Get("client_id"))
+
+	// The user signs in at the provider, which redirects back with a code.
+	code := gofakeit.UUID()
+	identity.nonce = providerURL.Query().Get("nonce")
+	fakeCodes.Store(code, identity)
+
+	resp, err = noRedirectClient.Get(st.HTTPURL("/login/test/callback?" + url.Values{
+		"code":  {code},
+		"state": {providerURL.Query().Get("state")},
+	}.Encode()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, clientState, location.Query().Get("state"))
+
+	return location.Query()
+}
+
+// startFakeProvider serves the discovery document and the token endpoint of
+// an OpenID Connect provider. The tokens endpoint issues ID tokens for the
+// identities stored in fakeCodes.
+func startFakeProvider(t *testing.T) {
+	t.Helper()
+
+	fakeProviderOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 fakeProviderIssuer,
+				"authorization_endpoint": fakeProviderIssuer + "/authorize",
+				"token_endpoint":         fakeProviderIssuer + "/token",
+			})
+		})
+		mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+			clientID, secret, ok := r.BasicAuth()
+			if !ok || clientID != fakeClientID || secret != fakeClientSecret {
+				w.WriteHeader(http.StatusUnauthorized)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+				return
+			}
+
+			value, ok := fakeCodes.LoadAndDelete(r.PostFormValue("code"))
+			if !ok || r.PostFormValue("code_verifier") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			identity := value.(fakeIdentity)
+
+			idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"iss":            fakeProviderIssuer,
+				"sub":            identity.Subject,
+				"aud":            fakeClientID,
+				"exp":            time.Now().Add(time.Minute).Unix(),
+				"iat":            time.Now().Unix(),
+				"nonce":          identity.nonce,
+				"email":          identity.Email,
+				"email_verified": identity.EmailVerified,
+			}).SignedString([]byte(fakeClientSecret))
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"access_token": gofakeit.UUID(),
+				"token_type":   "Bearer",
+				"id_token":     idToken,
+			})
+		})
+
+		ln, err := net.Listen("tcp", fakeProviderAddr)
+		if err != nil {
+			fakeProviderErr = err
+			return
+		}
+
+		go func() { _ = http.Serve(ln, mux) }()
+	})
+
+	require.NoError(t, fakeProviderErr)
+}
@@ -0,0 +1,23 @@
+package tests
+
+import (
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestJWKS_PublishesOnlyPublicKeys(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.JWKS(ctx, &ssov1.JWKSRequest{})
+	require.NoError(t, err)
+
+	for _, key := range resp.GetKeys() {
+		assert.NotEmpty(t, key.GetKid())
+		assert.Equal(t, "sig", key.GetUse())
+		assert.NotEqual(t, "oct", key.GetKty())
+	}
+}
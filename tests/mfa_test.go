@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/lib/totp"
+	"sso/tests/suite"
+)
+
+func TestMFA_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, _ := enableTOTP(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+	assert.Empty(t, respLog.GetToken())
+	assert.Empty(t, respLog.GetRefreshToken())
+	require.NotEmpty(t, respLog.GetMfaToken())
+
+	respMFA, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respMFA.GetToken())
+	assert.NotEmpty(t, respMFA.GetRefreshToken())
+
+	// Challenges can be completed once.
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired mfa token")
+}
+
+func TestMFA_WrongCodeKeepsChallenge(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, _ := enableTOTP(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: "000000"})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid mfa code")
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.NoError(t, err)
+}
+
+func TestMFA_CodeCantBeReused(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, _ := enableTOTP(ctx, t, st)
+	code := nextTOTPCode(t, secret, 1)
+
+	for i, wantErr := range []bool{false, true} {
+		respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: code})
+		if !wantErr {
+			require.NoError(t, err, "attempt %d", i)
+			continue
+		}
+		require.Error(t, err, "attempt %d", i)
+		assert.ErrorContains(t, err, "invalid mfa code")
+	}
+}
+
+func TestMFA_LockedAfterWrongCodes(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, _ := enableTOTP(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	for range 5 {
+		_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: "000000"})
+		require.Error(t, err)
+	}
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "too many wrong mfa codes")
+}
+
+func TestMFA_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: gofakeit.UUID(), Code: "123456"})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired mfa token")
+}
+
+func TestMFA_EnrollTwice(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authCtx := withAccessToken(ctx, respLog.GetToken())
+
+	// Unconfirmed enrollments are replaced.
+
+	first, err := st.AuthClient.EnrollTOTP(authCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+
+	second, err := st.AuthClient.EnrollTOTP(authCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.GetSecret(), second.GetSecret())
+
+	_, err = st.AuthClient.ConfirmTOTP(authCtx, &ssov1.ConfirmTOTPRequest{Code: nextTOTPCode(t, first.GetSecret(), 0)})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid mfa code")
+
+	_, err = st.AuthClient.ConfirmTOTP(authCtx, &ssov1.ConfirmTOTPRequest{Code: nextTOTPCode(t, second.GetSecret(), 0)})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.EnrollTOTP(authCtx, &ssov1.EnrollTOTPRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "mfa already enabled")
+}
+
+func TestMFA_EnrollWithoutAccessToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.EnrollTOTP(ctx, &ssov1.EnrollTOTPRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "access token is required")
+}
+
+// enableTOTP registers a user and turns on MFA for them. It returns the
+// email, password, TOTP secret and recovery codes of the user.
+func enableTOTP(ctx context.Context, t *testing.T, st *suite.Suite) (string, string, string, []string) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	authCtx := withAccessToken(ctx, respLog.GetToken())
+
+	enrollment, err := st.AuthClient.EnrollTOTP(authCtx, &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	require.NotEmpty(t, enrollment.GetSecret())
+	assert.Contains(t, enrollment.GetUri(), "otpauth://totp/")
+
+	respConfirm, err := st.AuthClient.ConfirmTOTP(authCtx, &ssov1.ConfirmTOTPRequest{Code: nextTOTPCode(t, enrollment.GetSecret(), 0)})
+	require.NoError(t, err)
+	require.NotEmpty(t, respConfirm.GetRecoveryCodes())
+
+	return email, password, enrollment.GetSecret(), respConfirm.GetRecoveryCodes()
+}
+
+// nextTOTPCode returns the code of the time step offset steps from now.
+// Every step can be used once, so consecutive logins need the next one.
+func nextTOTPCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	require.NoError(t, err)
+
+	return code
+}
@@ -0,0 +1,7 @@
+INSERT INTO apps (id, name, secret, refresh_secret, scopes)
+VALUES (3, 'test-exchange-target', 'sso_secret_exchange_target', 'sso_refresh_secret_exchange_target', '{"reports:read"}')
+ON CONFLICT DO NOTHING;
+
+UPDATE apps
+SET exchange_audiences = '{3}'
+WHERE id = 1;
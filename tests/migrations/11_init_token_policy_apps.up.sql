@@ -0,0 +1,7 @@
+INSERT INTO apps (id, name, secret, refresh_secret, access_ttl, refresh_ttl)
+VALUES (4, 'test-token-ttl', 'sso_secret_token_ttl', 'sso_refresh_secret_token_ttl', 60, 120)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO apps (id, name, secret, refresh_secret, session_lifetime, idle_timeout)
+VALUES (5, 'test-session-limits', 'sso_secret_session_limits', 'sso_refresh_secret_session_limits', 6, 4)
+ON CONFLICT DO NOTHING;
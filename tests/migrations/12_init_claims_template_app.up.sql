@@ -0,0 +1,4 @@
+INSERT INTO apps (id, name, secret, refresh_secret, claims_template)
+VALUES (6, 'test-claims-template', 'sso_secret_claims_template', 'sso_refresh_secret_claims_template',
+        '{"user": {"admin": "is_admin", "roles": "roles"}}')
+ON CONFLICT DO NOTHING;
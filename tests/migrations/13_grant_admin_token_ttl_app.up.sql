@@ -0,0 +1,8 @@
+-- The seeded admin manages a second app to check that roles stay in the app
+-- that created them.
+INSERT INTO user_roles (user_id, app_id, role_id)
+SELECT u.id, 4, r.id
+FROM users u
+         JOIN roles r ON r.name = 'admin' AND r.app_id IS NULL
+WHERE u.email = 'admin@sso.test'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+UPDATE apps
+SET refresh_secret = 'sso_refresh_secret'
+WHERE id = 1;
@@ -0,0 +1,5 @@
+-- client_secret_hash is the SHA-256 of 'sso_client_secret'.
+UPDATE apps
+SET client_secret_hash = '3220d0c4543d38b511b10dec5f349cab206d051bd06770ab1773d933c35df11e',
+    client_scopes      = '{"reports:read","reports:write"}'
+WHERE id = 1;
@@ -0,0 +1,3 @@
+UPDATE apps
+SET client_scopes = '{"reports:read","reports:write","token_exchange"}'
+WHERE id = 1;
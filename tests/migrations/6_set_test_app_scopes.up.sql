@@ -0,0 +1,3 @@
+UPDATE apps
+SET scopes = '{"profile","reports:read"}'
+WHERE id = 1;
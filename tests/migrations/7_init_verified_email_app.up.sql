@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, refresh_secret, require_verified_email)
+VALUES (2, 'test-verified-email', 'sso_secret_verified_email', 'sso_refresh_secret_verified_email', TRUE)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+UPDATE apps
+SET webauthn_rp_id   = 'localhost',
+    webauthn_origins = '{"http://localhost:5446"}'
+WHERE id = 1;
@@ -0,0 +1,11 @@
+-- pass_hash is the bcrypt hash of 'sso_admin_password'.
+INSERT INTO users (email, pass_hash, email_verified)
+VALUES ('admin@sso.test', '$2a$10$rfQaC.UiaIsZqdS0jhIzB.bwR3ltsHgnH6EyH38.7yLQnavUpA8j.', TRUE)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, app_id, role_id)
+SELECT u.id, 1, r.id
+FROM users u
+         JOIN roles r ON r.name = 'admin'
+WHERE u.email = 'admin@sso.test'
+ON CONFLICT DO NOTHING;
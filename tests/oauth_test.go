@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+const redirectURI = "http://localhost:3000/callback"
+
+// noRedirectClient returns redirects as responses so that the authorization
+// code can be read from the Location header.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+	Error        string `json:"error"`
+}
+
+func TestOAuthAuthorizationCode_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	verifier, challenge := pkcePair(t)
+	code := authorizeUser(ctx, t, st, challenge, nil)
+
+	status, resp := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.NotEmpty(t, resp.RefreshToken)
+	assert.Positive(t, resp.ExpiresIn)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.AccessToken))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, int32(appID), info.GetAppId())
+}
+
+func TestOAuthAuthorizationCode_CodeReuse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	verifier, challenge := pkcePair(t)
+	code := authorizeUser(ctx, t, st, challenge, nil)
+
+	status, first := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, status)
+
+	status, second := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", second.Error)
+
+	// Tokens issued for a reused code are revoked.
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(first.AccessToken))
+	require.NoError(t, err)
+	assert.False(t, info.GetActive())
+}
+
+func TestOAuthAuthorizationCode_WrongVerifier(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, challenge := pkcePair(t)
+	otherVerifier, _ := pkcePair(t)
+	code := authorizeUser(ctx, t, st, challenge, nil)
+
+	status, resp := exchangeCode(t, st, code, otherVerifier)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", resp.Error)
+}
+
+func TestOAuthAuthorize_UnregisteredRedirectURI(t *testing.T) {
+	_, st := suite.New(t)
+
+	_, challenge := pkcePair(t)
+
+	resp, err := noRedirectClient.Get(st.HTTPURL("/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {"https://evil.example.com/callback"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+}
+
+func pkcePair(t *testing.T) (string, string) {
+	t.Helper()
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorizeUser registers a user, signs in with the login form of the
+// authorization endpoint and returns the authorization code. Extra
+// parameters are added to the authorization request.
+func authorizeUser(ctx context.Context, t *testing.T, st *suite.Suite, challenge string, extra url.Values) string {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	state := gofakeit.UUID()
+
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"state":                 {state},
+		"email":                 {email},
+		"password":              {password},
+	}
+	for name, values := range extra {
+		form[name] = values
+	}
+
+	resp, err := noRedirectClient.PostForm(st.HTTPURL("/authorize"), form)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(location.String(), redirectURI))
+	assert.Equal(t, state, location.Query().Get("state"))
+
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	return code
+}
+
+func exchangeCode(t *testing.T, st *suite.Suite, code string, verifier string) (int, tokenResponse) {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL("/token"), url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appID)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp.StatusCode, body
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestOIDC_IDToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	nonce := gofakeit.UUID()
+
+	verifier, challenge := pkcePair(t)
+	code := authorizeUser(ctx, t, st, challenge, url.Values{
+		"scope": {"openid"},
+		"nonce": {nonce},
+	})
+
+	status, resp := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, resp.IDToken)
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, st.Cfg.Issuer, claims["iss"])
+	assert.Equal(t, []any{strconv.Itoa(appID)}, claims["aud"])
+	assert.Equal(t, nonce, claims["nonce"])
+	assert.NotEmpty(t, claims["sub"])
+	assert.NotEmpty(t, claims["auth_time"])
+	assert.NotEmpty(t, claims["at_hash"])
+}
+
+func TestOIDC_NoIDTokenWithoutOpenIDScope(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	verifier, challenge := pkcePair(t)
+	code := authorizeUser(ctx, t, st, challenge, nil)
+
+	status, resp := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, status)
+	assert.Empty(t, resp.IDToken)
+}
+
+func TestOIDC_UserInfo(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	req, err := http.NewRequest(http.MethodGet, st.HTTPURL("/userinfo"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+respLog.GetToken())
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Sub   string `json:"sub"`
+		Email string `json:"email"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotEmpty(t, body.Sub)
+	assert.NotEmpty(t, body.Email)
+}
+
+func TestOIDC_UserInfoWithoutToken(t *testing.T) {
+	_, st := suite.New(t)
+
+	resp, err := http.Get(st.HTTPURL("/userinfo"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestOIDC_Discovery(t *testing.T) {
+	_, st := suite.New(t)
+
+	resp, err := http.Get(st.HTTPURL("/.well-known/openid-configuration"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, st.Cfg.Issuer, body["issuer"])
+	assert.Equal(t, st.Cfg.Issuer+"/.well-known/jwks.json", body["jwks_uri"])
+	assert.Equal(t, st.Cfg.Issuer+"/token", body["token_endpoint"])
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestPasswordReset_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	token := requestPasswordReset(ctx, t, st, email)
+
+	// Tokens issued within the same second as the reset stay valid.
+	time.Sleep(time.Second)
+
+	newPassword := randomFakePassword()
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: newPassword})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid email or password")
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: newPassword, AppId: appID})
+	require.NoError(t, err)
+
+	// Sessions started with the old password are gone.
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: respLog.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+
+	_, err = st.AuthClient.LogoutAll(withAccessToken(ctx, respLog.GetToken()), &ssov1.LogoutAllRequest{})
+	require.Error(t, err)
+
+	// Tokens can be used once.
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: randomFakePassword()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestPasswordReset_OnlyLastTokenIsUsable(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	first := requestPasswordReset(ctx, t, st, email)
+	second := requestPasswordReset(ctx, t, st, email)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: second, NewPassword: randomFakePassword()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: first, NewPassword: randomFakePassword()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestPasswordReset_UnknownEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: gofakeit.Email()})
+	require.NoError(t, err)
+}
+
+func TestPasswordReset_RateLimited(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	registered := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: registered, Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	sent := len(mailFiles(t, st, registered))
+
+	// Registered and unknown emails are limited alike.
+	for _, email := range []string{registered, gofakeit.Email()} {
+		for range st.Cfg.Account.PasswordResetEmailLimit {
+			_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+			require.NoError(t, err)
+		}
+
+		_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "too many password resets requested")
+	}
+
+	assert.Len(t, mailFiles(t, st, registered), sent+st.Cfg.Account.PasswordResetEmailLimit)
+}
+
+func TestPasswordReset_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:       gofakeit.UUID(),
+		NewPassword: randomFakePassword(),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestPasswordReset_WithoutNewPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: gofakeit.UUID()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "new_password is required")
+}
+
+// requestPasswordReset asks for a reset mail and waits for it, as the mail
+// is sent in the background.
+func requestPasswordReset(ctx context.Context, t *testing.T, st *suite.Suite, email string) string {
+	t.Helper()
+
+	sent := len(mailFiles(t, st, email))
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+
+	// The mail is sent before the call returns.
+	require.Greater(t, len(mailFiles(t, st, email)), sent)
+
+	return lastMailToken(t, st, email)
+}
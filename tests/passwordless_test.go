@@ -0,0 +1,364 @@
+package tests
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+var (
+	mailLoginCodeRe = regexp.MustCompile(`(?m)^(\d{6})\s*$`)
+	mailLinkRe      = regexp.MustCompile(`https?://\S+`)
+)
+
+func TestPasswordlessLogin_CodeRegistersUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	respStart, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respStart.GetLoginToken())
+
+	respDone, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       lastLoginCode(t, st, email),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respDone.GetToken())
+	assert.NotEmpty(t, respDone.GetRefreshToken())
+	assert.Empty(t, respDone.GetMfaToken())
+
+	respIntrospect, err := st.AuthClient.Introspect(ctx, introspectRequest(respDone.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, respIntrospect.GetActive())
+
+	// The same email logs in to the same user.
+	respStart, err = st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	respAgain, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       lastLoginCode(t, st, email),
+	})
+	require.NoError(t, err)
+
+	respIntrospectAgain, err := st.AuthClient.Introspect(ctx, introspectRequest(respAgain.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, respIntrospect.GetUserId(), respIntrospectAgain.GetUserId())
+}
+
+func TestPasswordlessLogin_ExistingUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, email)})
+	require.NoError(t, err)
+
+	respDone := passwordlessLogin(ctx, t, st, email)
+
+	respIntrospect, err := st.AuthClient.Introspect(ctx, introspectRequest(respDone.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, respReg.GetUserId(), respIntrospect.GetUserId())
+
+	// The password keeps working.
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+}
+
+func TestPasswordlessLogin_ClaimsUnverifiedUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	// Someone registers an email they don't own and signs in.
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	// The owner of the email gets the account without the registrant's
+	// password and sessions.
+	respDone := passwordlessLogin(ctx, t, st, email)
+
+	respIntrospect, err := st.AuthClient.Introspect(ctx, introspectRequest(respDone.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, respReg.GetUserId(), respIntrospect.GetUserId())
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid email or password")
+
+	_, err = st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: respLog.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+}
+
+func TestPasswordlessLogin_CodeIsSingleUse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	respStart, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	code := lastLoginCode(t, st, email)
+
+	_, err = st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       code,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       code,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired login code")
+}
+
+func TestPasswordlessLogin_AttemptsAreLimited(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	respStart, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	code := lastLoginCode(t, st, email)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for range 5 {
+		_, err = st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+			LoginToken: respStart.GetLoginToken(),
+			Code:       wrong,
+		})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "invalid or expired login code")
+	}
+
+	// The right code no longer works once the attempts are used up.
+	_, err = st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       code,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired login code")
+}
+
+func TestPasswordlessLogin_Link(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email:       email,
+		AppId:       appID,
+		Scopes:      []string{"profile"},
+		Method:      "link",
+		RedirectUri: redirectURI,
+	})
+	require.NoError(t, err)
+
+	link := mailLinkRe.FindString(lastMail(t, st, email))
+	require.NotEmpty(t, link, "no link in mail to %s", email)
+	assert.True(t, strings.HasPrefix(link, redirectURI))
+
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+
+	respDone, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: u.Query().Get("login_token"),
+		Code:       u.Query().Get("code"),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respDone.GetToken())
+	assert.Equal(t, []string{"profile"}, respDone.GetScopes())
+}
+
+func TestPasswordlessLogin_RateLimited(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	start := func(email string) error {
+		_, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+			Email: email,
+			AppId: appID,
+		})
+		return err
+	}
+
+	for range st.Cfg.Account.PasswordlessEmailLimit {
+		require.NoError(t, start(email))
+	}
+
+	// The email is limited however it is spelled.
+	err := start(strings.ToUpper(email))
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "too many login codes requested")
+
+	// Other emails still get their codes.
+	require.NoError(t, start(gofakeit.Email()))
+}
+
+func TestPasswordlessLogin_MFA(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _, secret, _ := enableTOTP(ctx, t, st)
+
+	// Second factors of users with an unverified email are dropped.
+	_, err := st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastMailToken(t, st, email)})
+	require.NoError(t, err)
+
+	respStart, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	respDone, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       lastLoginCode(t, st, email),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, respDone.GetToken())
+	require.NotEmpty(t, respDone.GetMfaToken())
+
+	respMFA, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respDone.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respMFA.GetToken())
+}
+
+func TestPasswordlessLogin_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name        string
+		req         *ssov1.StartPasswordlessLoginRequest
+		expectedErr string
+	}{
+		{
+			name:        "Without email",
+			req:         &ssov1.StartPasswordlessLoginRequest{AppId: appID},
+			expectedErr: "email is required",
+		},
+		{
+			name:        "Invalid email",
+			req:         &ssov1.StartPasswordlessLoginRequest{Email: "not-an-email", AppId: appID},
+			expectedErr: "email is not valid",
+		},
+		{
+			name:        "Without app id",
+			req:         &ssov1.StartPasswordlessLoginRequest{Email: gofakeit.Email()},
+			expectedErr: "wrong app id",
+		},
+		{
+			name:        "Unknown app",
+			req:         &ssov1.StartPasswordlessLoginRequest{Email: gofakeit.Email(), AppId: 9999},
+			expectedErr: "invalid app id",
+		},
+		{
+			name:        "Unknown method",
+			req:         &ssov1.StartPasswordlessLoginRequest{Email: gofakeit.Email(), AppId: appID, Method: "sms"},
+			expectedErr: "method must be code or link",
+		},
+		{
+			name: "Link to unregistered redirect uri",
+			req: &ssov1.StartPasswordlessLoginRequest{
+				Email:       gofakeit.Email(),
+				AppId:       appID,
+				Method:      "link",
+				RedirectUri: "https://evil.example.com/callback",
+			},
+			expectedErr: "invalid redirect uri",
+		},
+		{
+			name: "Undeclared scope",
+			req: &ssov1.StartPasswordlessLoginRequest{
+				Email:  gofakeit.Email(),
+				AppId:  appID,
+				Scopes: []string{"admin:everything"},
+			},
+			expectedErr: "invalid scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.StartPasswordlessLogin(ctx, tt.req)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+
+	_, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: "unknown",
+		Code:       "123456",
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired login code")
+}
+
+// passwordlessLogin logs in to the test app with a mailed code.
+func passwordlessLogin(ctx context.Context, t *testing.T, st *suite.Suite, email string) *ssov1.CompletePasswordlessLoginResponse {
+	t.Helper()
+
+	respStart, err := st.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email: email,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	respDone, err := st.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		LoginToken: respStart.GetLoginToken(),
+		Code:       lastLoginCode(t, st, email),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respDone.GetToken())
+
+	return respDone
+}
+
+// lastLoginCode reads the login code from the newest mail sent to the email.
+func lastLoginCode(t *testing.T, st *suite.Suite, email string) string {
+	t.Helper()
+
+	match := mailLoginCodeRe.FindStringSubmatch(lastMail(t, st, email))
+	require.NotNil(t, match, "no login code in mail to %s", email)
+
+	return match[1]
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+func TestRecoveryCodes_LoginWithCode(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, _, recoveryCodes := enableTOTP(ctx, t, st)
+	assert.Len(t, recoveryCodes, 10)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	// Codes are accepted regardless of case and dashes.
+	respMFA, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", "")),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respMFA.GetToken())
+
+	// Every code can be used once.
+	respLog, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: recoveryCodes[0]})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid mfa code")
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: recoveryCodes[1]})
+	require.NoError(t, err)
+}
+
+func TestRecoveryCodes_Regenerate(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, oldCodes := enableTOTP(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	respMFA, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.NoError(t, err)
+
+	authCtx := withAccessToken(ctx, respMFA.GetToken())
+
+	_, err = st.AuthClient.RegenerateRecoveryCodes(authCtx, &ssov1.RegenerateRecoveryCodesRequest{Password: randomFakePassword()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid password")
+
+	respCodes, err := st.AuthClient.RegenerateRecoveryCodes(authCtx, &ssov1.RegenerateRecoveryCodesRequest{Password: password})
+	require.NoError(t, err)
+	require.Len(t, respCodes.GetRecoveryCodes(), 10)
+
+	// The old set stops working.
+	respLog, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{MfaToken: respLog.GetMfaToken(), Code: oldCodes[0]})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid mfa code")
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     respCodes.GetRecoveryCodes()[0],
+	})
+	require.NoError(t, err)
+}
+
+func TestRecoveryCodes_RegenerateWithoutMFA(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := gofakeit.Email(), randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RegenerateRecoveryCodes(withAccessToken(ctx, respLog.GetToken()), &ssov1.RegenerateRecoveryCodesRequest{
+		Password: password,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "mfa not enrolled")
+}
@@ -0,0 +1,374 @@
+package tests
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+// The admin user is seeded by tests/migrations with the admin role in the
+// test app and in the token TTL app.
+const (
+	adminEmail    = "admin@sso.test"
+	adminPassword = "sso_admin_password"
+)
+
+// claimsTemplateAppID embeds is_admin and the roles of the user into its
+// access tokens, see tests/migrations.
+const claimsTemplateAppID = 6
+
+func TestRoles_GrantAndRevoke(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	role := randomRoleName()
+	_, err := st.AuthClient.CreateRole(adminCtx, &ssov1.CreateRoleRequest{
+		Name:        role,
+		Description: "Reads reports",
+		Permissions: []string{"reports:read", "reports:export", "reports:read"},
+	})
+	require.NoError(t, err)
+
+	userID, email, password := registerUser(ctx, t, st)
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: role})
+	require.NoError(t, err)
+
+	// Granting twice changes nothing.
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: role})
+	require.NoError(t, err)
+
+	respRoles, err := st.AuthClient.UserRoles(adminCtx, &ssov1.UserRolesRequest{UserId: userID, AppId: appID})
+	require.NoError(t, err)
+	require.Len(t, respRoles.GetRoles(), 1)
+	assert.Equal(t, role, respRoles.GetRoles()[0].GetName())
+	assert.Equal(t, "Reads reports", respRoles.GetRoles()[0].GetDescription())
+	assert.Equal(t, []string{"reports:export", "reports:read"}, respRoles.GetRoles()[0].GetPermissions())
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	// Roles are granted per app, users can list their own.
+	respRoles, err = st.AuthClient.UserRoles(withAccessToken(ctx, respLog.GetToken()), &ssov1.UserRolesRequest{UserId: userID, AppId: verifiedEmailAppID})
+	require.NoError(t, err)
+	assert.Empty(t, respRoles.GetRoles())
+
+	respIntrospect, err := st.AuthClient.Introspect(ctx, introspectRequest(respLog.GetToken()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{role}, respIntrospect.GetRoles())
+
+	_, err = st.AuthClient.RevokeRole(adminCtx, &ssov1.RevokeRoleRequest{UserId: userID, AppId: appID, Role: role})
+	require.NoError(t, err)
+
+	respRoles, err = st.AuthClient.UserRoles(adminCtx, &ssov1.UserRolesRequest{UserId: userID, AppId: appID})
+	require.NoError(t, err)
+	assert.Empty(t, respRoles.GetRoles())
+
+	_, err = st.AuthClient.RevokeRole(adminCtx, &ssov1.RevokeRoleRequest{UserId: userID, AppId: appID, Role: role})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "role not granted")
+}
+
+func TestRoles_PermissionsAuthorizeAdminRPCs(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	userID, email, password := registerUser(ctx, t, st)
+
+	_, err := st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: "moderator"})
+	require.NoError(t, err)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	moderatorCtx := withAccessToken(ctx, respLog.GetToken())
+
+	// Moderators manage sessions.
+	_, err = st.AuthClient.AdminListSessions(moderatorCtx, &ssov1.AdminListSessionsRequest{UserId: userID})
+	require.NoError(t, err)
+
+	// But not clients or roles.
+	_, err = st.AuthClient.RegisterClient(moderatorCtx, &ssov1.RegisterClientRequest{AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+
+	_, err = st.AuthClient.CreateRole(moderatorCtx, &ssov1.CreateRoleRequest{Name: randomRoleName()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestRoles_CreatedPerApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	role := randomRoleName()
+	_, err := st.AuthClient.CreateRole(adminCtx, &ssov1.CreateRoleRequest{
+		Name:        role,
+		Permissions: []string{"users:impersonate"},
+	})
+	require.NoError(t, err)
+
+	userID, _, _ := registerUser(ctx, t, st)
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: role})
+	require.NoError(t, err)
+
+	// The seeded admin manages roles in the token TTL app as well, but the
+	// role belongs to the test app.
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: tokenTTLAppID, Role: role})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "role not found")
+
+	// The app can create its own role of the same name.
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: adminEmail, Password: adminPassword, AppId: tokenTTLAppID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.CreateRole(withAccessToken(ctx, respLog.GetToken()), &ssov1.CreateRoleRequest{Name: role})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: tokenTTLAppID, Role: role})
+	require.NoError(t, err)
+
+	// Built-in roles can't be shadowed.
+	_, err = st.AuthClient.CreateRole(adminCtx, &ssov1.CreateRoleRequest{Name: "moderator"})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "role already exists")
+}
+
+func TestRoles_GrantInOtherApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	userID, _, _ := registerUser(ctx, t, st)
+
+	// The seeded admin isn't an admin of the verified email app.
+	_, err := st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{
+		UserId: userID,
+		AppId:  verifiedEmailAppID,
+		Role:   "moderator",
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestRoles_ClaimsOfTheTokenApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// The seeded admin is an admin of the test app, not of the app the
+	// token is issued for.
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    adminEmail,
+		Password: adminPassword,
+		AppId:    claimsTemplateAppID,
+	})
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(respLog.GetToken(), claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, false, claims["admin"])
+	assert.Equal(t, []any{}, claims["roles"])
+}
+
+func TestIsAdmin_FollowsAdminRole(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	userID, _, _ := registerUser(ctx, t, st)
+
+	respAdmin, err := st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+	require.NoError(t, err)
+	assert.False(t, respAdmin.GetIsAdmin())
+
+	_, err = st.AuthClient.GrantRole(adminCtx, &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: "admin"})
+	require.NoError(t, err)
+
+	respAdmin, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+	require.NoError(t, err)
+	assert.True(t, respAdmin.GetIsAdmin())
+
+	_, err = st.AuthClient.RevokeRole(adminCtx, &ssov1.RevokeRoleRequest{UserId: userID, AppId: appID, Role: "admin"})
+	require.NoError(t, err)
+
+	respAdmin, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+	require.NoError(t, err)
+	assert.False(t, respAdmin.GetIsAdmin())
+}
+
+func TestRoles_NotAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	userCtx := withAccessToken(ctx, respLog.GetToken())
+
+	_, err := st.AuthClient.CreateRole(userCtx, &ssov1.CreateRoleRequest{Name: randomRoleName()})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+
+	_, err = st.AuthClient.GrantRole(userCtx, &ssov1.GrantRoleRequest{UserId: 1, AppId: appID, Role: "admin"})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestUserRoles_OnlyOwnOrManaged(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, _, _ := registerUser(ctx, t, st)
+
+	_, err := st.AuthClient.UserRoles(ctx, &ssov1.UserRolesRequest{UserId: userID, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "access token is required")
+
+	// Other users can't list the roles without roles:manage in the app.
+	other := registerAndLogin(ctx, t, st)
+
+	_, err = st.AuthClient.UserRoles(withAccessToken(ctx, other.GetToken()), &ssov1.UserRolesRequest{UserId: userID, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+
+	// The seeded admin doesn't manage roles in the verified email app.
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	_, err = st.AuthClient.UserRoles(adminCtx, &ssov1.UserRolesRequest{UserId: userID, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.UserRoles(adminCtx, &ssov1.UserRolesRequest{UserId: userID, AppId: verifiedEmailAppID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestRoles_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx := withAccessToken(ctx, adminLogin(ctx, t, st))
+
+	existing := randomRoleName()
+	_, err := st.AuthClient.CreateRole(adminCtx, &ssov1.CreateRoleRequest{Name: existing})
+	require.NoError(t, err)
+
+	createTests := []struct {
+		name        string
+		req         *ssov1.CreateRoleRequest
+		expectedErr string
+	}{
+		{
+			name:        "Without name",
+			req:         &ssov1.CreateRoleRequest{},
+			expectedErr: "name is required",
+		},
+		{
+			name:        "Invalid name",
+			req:         &ssov1.CreateRoleRequest{Name: "Not A Role"},
+			expectedErr: "invalid role name",
+		},
+		{
+			name:        "Invalid permission",
+			req:         &ssov1.CreateRoleRequest{Name: randomRoleName(), Permissions: []string{"two words"}},
+			expectedErr: "invalid permission",
+		},
+		{
+			name:        "Existing role",
+			req:         &ssov1.CreateRoleRequest{Name: existing},
+			expectedErr: "role already exists",
+		},
+	}
+
+	for _, tt := range createTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.CreateRole(adminCtx, tt.req)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+
+	userID, _, _ := registerUser(ctx, t, st)
+
+	grantTests := []struct {
+		name        string
+		req         *ssov1.GrantRoleRequest
+		expectedErr string
+	}{
+		{
+			name:        "Without user id",
+			req:         &ssov1.GrantRoleRequest{AppId: appID, Role: "moderator"},
+			expectedErr: "user_id is required",
+		},
+		{
+			name:        "Without app id",
+			req:         &ssov1.GrantRoleRequest{UserId: userID, Role: "moderator"},
+			expectedErr: "wrong app id",
+		},
+		{
+			name:        "Without role",
+			req:         &ssov1.GrantRoleRequest{UserId: userID, AppId: appID},
+			expectedErr: "role is required",
+		},
+		{
+			name:        "Unknown role",
+			req:         &ssov1.GrantRoleRequest{UserId: userID, AppId: appID, Role: randomRoleName()},
+			expectedErr: "role not found",
+		},
+		{
+			name:        "Unknown user",
+			req:         &ssov1.GrantRoleRequest{UserId: math.MaxInt32, AppId: appID, Role: "moderator"},
+			expectedErr: "user not found",
+		},
+	}
+
+	for _, tt := range grantTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.GrantRole(adminCtx, tt.req)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+
+	_, err = st.AuthClient.UserRoles(adminCtx, &ssov1.UserRolesRequest{UserId: math.MaxInt32, AppId: appID})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "user not found")
+}
+
+// adminLogin returns an access token of the seeded admin for the test app.
+func adminLogin(ctx context.Context, t *testing.T, st *suite.Suite) string {
+	t.Helper()
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    adminEmail,
+		Password: adminPassword,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respLog.GetToken())
+
+	return respLog.GetToken()
+}
+
+func registerUser(ctx context.Context, t *testing.T, st *suite.Suite) (int64, string, string) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: password})
+	require.NoError(t, err)
+
+	return respReg.GetUserId(), email, password
+}
+
+func randomRoleName() string {
+	return "role-" + strings.ToLower(gofakeit.LetterN(12))
+}
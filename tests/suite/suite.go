@@ -42,6 +42,11 @@ func New(t *testing.T) (context.Context, *Suite) {
 	}
 }
 
+// HTTPURL is the address of the path on the HTTP server.
+func (s *Suite) HTTPURL(path string) string {
+	return "http://" + net.JoinHostPort("localhost", strconv.Itoa(s.Cfg.HTTP.Port)) + path
+}
+
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort("localhost", strconv.Itoa(cfg.GRPC.Port))
 }
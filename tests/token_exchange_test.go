@@ -0,0 +1,350 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// exchangeTargetAppID is the app the test app may exchange tokens for, see
+// tests/migrations.
+const exchangeTargetAppID = 3
+
+func TestExchangeToken_ClientDelegation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Scopes:       []string{"reports:read"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetToken())
+	assert.Positive(t, resp.GetExpiresIn())
+	assert.Equal(t, []string{"reports:read"}, resp.GetScopes())
+
+	subject, err := st.AuthClient.Introspect(ctx, introspectRequest(respLog.GetToken()))
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, subject.GetUserId(), info.GetUserId())
+	assert.Equal(t, "client:"+strconv.Itoa(appID), info.GetActor())
+	assert.Equal(t, []string{"reports:read"}, info.GetScopes())
+}
+
+func TestExchangeToken_ClientAudiences(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Audience:     strconv.Itoa(exchangeTargetAppID),
+		Scopes:       []string{"reports:read"},
+	})
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, int32(exchangeTargetAppID), info.GetAppId())
+
+	// The client may only exchange tokens for the apps it lists.
+	_, err = st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Audience:     strconv.Itoa(verifiedEmailAppID),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid audience")
+
+	// Scopes must be requested for another audience.
+	_, err = st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Audience:     strconv.Itoa(exchangeTargetAppID),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "scopes are required for another audience")
+
+	// And declared by it.
+	_, err = st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Audience:     strconv.Itoa(exchangeTargetAppID),
+		Scopes:       []string{"reports:write"},
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid scope")
+}
+
+func TestExchangeToken_Impersonation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminToken := adminLogin(ctx, t, st)
+	respLog := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ActorToken:   adminToken,
+	})
+	require.NoError(t, err)
+
+	admin, err := st.AuthClient.Introspect(ctx, introspectRequest(adminToken))
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.Introspect(ctx, introspectRequest(resp.GetToken()))
+	require.NoError(t, err)
+	assert.True(t, info.GetActive())
+	assert.Equal(t, strconv.FormatInt(admin.GetUserId(), 10), info.GetActor())
+
+	// The seeded admin may not impersonate users in the target app.
+	_, err = st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ActorToken:   adminToken,
+		Audience:     strconv.Itoa(exchangeTargetAppID),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestExchangeToken_CantManageAccount(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	impersonated, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ActorToken:   adminLogin(ctx, t, st),
+	})
+	require.NoError(t, err)
+
+	delegated, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+
+	calls := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{
+			name: "EnrollTOTP",
+			call: func(ctx context.Context) error {
+				_, err := st.AuthClient.EnrollTOTP(ctx, &ssov1.EnrollTOTPRequest{})
+				return err
+			},
+		},
+		{
+			name: "BeginPasskeyRegistration",
+			call: func(ctx context.Context) error {
+				_, err := st.AuthClient.BeginPasskeyRegistration(ctx, &ssov1.BeginPasskeyRegistrationRequest{})
+				return err
+			},
+		},
+		{
+			name: "ListSessions",
+			call: func(ctx context.Context) error {
+				_, err := st.AuthClient.ListSessions(ctx, &ssov1.ListSessionsRequest{})
+				return err
+			},
+		},
+		{
+			name: "LogoutAll",
+			call: func(ctx context.Context) error {
+				_, err := st.AuthClient.LogoutAll(ctx, &ssov1.LogoutAllRequest{})
+				return err
+			},
+		},
+	}
+
+	for _, token := range []string{impersonated.GetToken(), delegated.GetToken()} {
+		for _, tt := range calls {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.call(withAccessToken(ctx, token))
+				require.Error(t, err)
+				assert.ErrorContains(t, err, "invalid access token")
+			})
+		}
+	}
+
+	// The user's own token still manages the account.
+	_, err = st.AuthClient.ListSessions(withAccessToken(ctx, respLog.GetToken()), &ssov1.ListSessionsRequest{})
+	require.NoError(t, err)
+}
+
+func TestExchangeToken_ScopesOnlyNarrow(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	narrowed, err := st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: respLog.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Scopes:       []string{"reports:read"},
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ExchangeToken(ctx, &ssov1.ExchangeTokenRequest{
+		SubjectToken: narrowed.GetToken(),
+		ClientId:     strconv.Itoa(appID),
+		ClientSecret: clientSecret,
+		Scopes:       []string{"reports:write"},
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid scope")
+}
+
+func TestExchangeToken_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	tests := []struct {
+		name        string
+		req         *ssov1.ExchangeTokenRequest
+		expectedErr string
+	}{
+		{
+			name: "Invalid subject token",
+			req: &ssov1.ExchangeTokenRequest{
+				SubjectToken: "invalid",
+				ClientId:     strconv.Itoa(appID),
+				ClientSecret: clientSecret,
+			},
+			expectedErr: "invalid subject token",
+		},
+		{
+			name: "Wrong client secret",
+			req: &ssov1.ExchangeTokenRequest{
+				SubjectToken: respLog.GetToken(),
+				ClientId:     strconv.Itoa(appID),
+				ClientSecret: "wrong",
+			},
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name:        "Without actor",
+			req:         &ssov1.ExchangeTokenRequest{SubjectToken: respLog.GetToken()},
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name: "Actor is not an admin",
+			req: &ssov1.ExchangeTokenRequest{
+				SubjectToken: respLog.GetToken(),
+				ActorToken:   registerAndLogin(ctx, t, st).GetToken(),
+			},
+			expectedErr: "permission denied",
+		},
+		{
+			name: "Scope not declared by the app",
+			req: &ssov1.ExchangeTokenRequest{
+				SubjectToken: respLog.GetToken(),
+				ClientId:     strconv.Itoa(appID),
+				ClientSecret: clientSecret,
+				Scopes:       []string{"reports:export"},
+			},
+			expectedErr: "invalid scope",
+		},
+		{
+			name: "Unknown audience",
+			req: &ssov1.ExchangeTokenRequest{
+				SubjectToken: respLog.GetToken(),
+				ClientId:     strconv.Itoa(appID),
+				ClientSecret: clientSecret,
+				Audience:     "999999",
+			},
+			expectedErr: "invalid audience",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ExchangeToken(ctx, tt.req)
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestExchangeToken_TokenEndpoint(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/token"), strings.NewReader(url.Values{
+		"grant_type":         {grantTypeTokenExchange},
+		"subject_token":      {respLog.GetToken()},
+		"subject_token_type": {tokenTypeAccessToken},
+		"scope":              {"reports:read"},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(strconv.Itoa(appID), clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		tokenResponse
+		IssuedTokenType string `json:"issued_token_type"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotEmpty(t, body.AccessToken)
+	assert.Empty(t, body.RefreshToken)
+	assert.Equal(t, tokenTypeAccessToken, body.IssuedTokenType)
+}
+
+func TestExchangeToken_TokenEndpointRequiresTokenType(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/token"), strings.NewReader(url.Values{
+		"grant_type":    {grantTypeTokenExchange},
+		"subject_token": {respLog.GetToken()},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(strconv.Itoa(appID), clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var body tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "invalid_request", body.Error)
+}
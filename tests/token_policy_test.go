@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+// The token policies of these apps are set in tests/migrations.
+const (
+	// tokenTTLAppID issues access tokens for a minute and refresh tokens
+	// for two.
+	tokenTTLAppID = 4
+	// sessionLimitsAppID ends sessions after 6 seconds, or after 4 seconds
+	// without a refresh.
+	sessionLimitsAppID = 5
+)
+
+func TestTokenPolicy_AppTTLs(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, email, password := registerUser(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: tokenTTLAppID})
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Minute, tokenLifetime(t, respLog.GetToken()))
+	assert.Equal(t, 2*time.Minute, tokenLifetime(t, respLog.GetRefreshToken()))
+
+	// The global config applies to other apps.
+	respLog, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	assert.Equal(t, st.Cfg.TokenTTL, tokenLifetime(t, respLog.GetToken()))
+	assert.Equal(t, st.Cfg.RefreshTTL, tokenLifetime(t, respLog.GetRefreshToken()))
+}
+
+func TestTokenPolicy_IdleTimeout(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := sessionLimitsLogin(ctx, t, st)
+
+	time.Sleep(5 * time.Second)
+
+	_, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLog.GetRefreshToken(),
+		AppId:        sessionLimitsAppID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestTokenPolicy_SessionLifetime(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := sessionLimitsLogin(ctx, t, st)
+	refreshToken := respLog.GetRefreshToken()
+
+	// Refreshing within the idle timeout keeps the session going, but only
+	// until its lifetime ends.
+	for range 2 {
+		time.Sleep(2 * time.Second)
+
+		respRefresh, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+			RefreshToken: refreshToken,
+			AppId:        sessionLimitsAppID,
+		})
+		require.NoError(t, err)
+		refreshToken = respRefresh.GetRefreshToken()
+	}
+
+	// The idle timeout alone would allow another refresh.
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err := st.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: refreshToken,
+		AppId:        sessionLimitsAppID,
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+func sessionLimitsLogin(ctx context.Context, t *testing.T, st *suite.Suite) *ssov1.LoginResponse {
+	t.Helper()
+
+	_, email, password := registerUser(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: sessionLimitsAppID})
+	require.NoError(t, err)
+	require.NotEmpty(t, respLog.GetRefreshToken())
+
+	return respLog
+}
+
+// tokenLifetime is the time between the iat and exp claims of the token.
+func tokenLifetime(t *testing.T, token string) time.Duration {
+	t.Helper()
+
+	claims := jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, &claims)
+	require.NoError(t, err)
+	require.NotNil(t, claims.IssuedAt)
+	require.NotNil(t, claims.ExpiresAt)
+
+	return claims.ExpiresAt.Sub(claims.IssuedAt.Time)
+}
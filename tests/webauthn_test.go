@@ -0,0 +1,403 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	ssov1 "github.com/nikitauty/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+)
+
+// webAuthnOrigin is the origin passkeys of the test app are used at, see
+// tests/migrations.
+const webAuthnOrigin = "http://localhost:5446"
+
+func TestPasskey_PasswordlessLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authenticator := registerPasskey(withAccessToken(ctx, respLog.GetToken()), t, st)
+
+	respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	var options struct {
+		RPID             string `json:"rpId"`
+		UserVerification string `json:"userVerification"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(respBegin.GetOptionsJson()), &options))
+	assert.Equal(t, "localhost", options.RPID)
+	assert.Equal(t, "required", options.UserVerification)
+
+	assertion := authenticator.get(t, respBegin.GetOptionsJson())
+
+	respFinish, err := st.AuthClient.FinishPasskeyLogin(ctx, &ssov1.FinishPasskeyLoginRequest{CredentialJson: assertion})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respFinish.GetToken())
+	assert.NotEmpty(t, respFinish.GetRefreshToken())
+
+	// Challenges can be answered once.
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, &ssov1.FinishPasskeyLoginRequest{CredentialJson: assertion})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid passkey")
+}
+
+func TestPasskey_SecondFactor(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password, secret, _ := enableTOTP(ctx, t, st)
+
+	respLog, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+
+	respMFA, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 1),
+	})
+	require.NoError(t, err)
+
+	authenticator := registerPasskey(withAccessToken(ctx, respMFA.GetToken()), t, st)
+
+	respLog, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: password, AppId: appID})
+	require.NoError(t, err)
+	require.NotEmpty(t, respLog.GetMfaToken())
+
+	respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{MfaToken: respLog.GetMfaToken()})
+	require.NoError(t, err)
+
+	respFinish, err := st.AuthClient.FinishPasskeyLogin(ctx, &ssov1.FinishPasskeyLoginRequest{
+		CredentialJson: authenticator.get(t, respBegin.GetOptionsJson()),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respFinish.GetToken())
+
+	// The passkey completed the MFA challenge.
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     nextTOTPCode(t, secret, 2),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid or expired mfa token")
+}
+
+func TestPasskey_ClonedAuthenticator(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authenticator := registerPasskey(withAccessToken(ctx, respLog.GetToken()), t, st)
+
+	signCount := authenticator.signCount
+	for i, wantErr := range []bool{false, true} {
+		// The clone reports the same sign count as the original.
+		authenticator.signCount = signCount
+
+		respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{AppId: appID})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.FinishPasskeyLogin(ctx, &ssov1.FinishPasskeyLoginRequest{
+			CredentialJson: authenticator.get(t, respBegin.GetOptionsJson()),
+		})
+		if !wantErr {
+			require.NoError(t, err, "attempt %d", i)
+			continue
+		}
+		require.Error(t, err, "attempt %d", i)
+		assert.ErrorContains(t, err, "invalid passkey")
+	}
+}
+
+func TestPasskey_WrongOrigin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authenticator := registerPasskey(withAccessToken(ctx, respLog.GetToken()), t, st)
+	authenticator.origin = "http://phishing.example"
+
+	respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, &ssov1.FinishPasskeyLoginRequest{
+		CredentialJson: authenticator.get(t, respBegin.GetOptionsJson()),
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid passkey")
+}
+
+func TestPasskey_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name        string
+		req         *ssov1.BeginPasskeyLoginRequest
+		expectedErr string
+	}{
+		{
+			name:        "Without app id",
+			req:         &ssov1.BeginPasskeyLoginRequest{},
+			expectedErr: "wrong app id",
+		},
+		{
+			name:        "App without relying party",
+			req:         &ssov1.BeginPasskeyLoginRequest{AppId: verifiedEmailAppID},
+			expectedErr: "passkeys are not available for the app",
+		},
+		{
+			name:        "Undeclared scope",
+			req:         &ssov1.BeginPasskeyLoginRequest{AppId: appID, Scopes: []string{"admin"}},
+			expectedErr: "invalid scope",
+		},
+		{
+			name:        "Unknown mfa token",
+			req:         &ssov1.BeginPasskeyLoginRequest{MfaToken: "unknown"},
+			expectedErr: "invalid or expired mfa token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.BeginPasskeyLogin(ctx, tt.req)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestPasskey_HTTPLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLog := registerAndLogin(ctx, t, st)
+	authenticator := registerPasskey(withAccessToken(ctx, respLog.GetToken()), t, st)
+
+	resp, err := http.PostForm(st.HTTPURL("/webauthn/login/begin"), url.Values{"client_id": {"1"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var options json.RawMessage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&options))
+
+	resp, err = http.Post(st.HTTPURL("/webauthn/login/finish"), "application/json", strings.NewReader(authenticator.get(t, string(options))))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var token tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+	assert.NotEmpty(t, token.AccessToken)
+	assert.Equal(t, "Bearer", token.TokenType)
+}
+
+// registerPasskey creates a passkey on a new software authenticator for the
+// user of the access token in ctx.
+func registerPasskey(ctx context.Context, t *testing.T, st *suite.Suite) *softAuthenticator {
+	t.Helper()
+
+	respBegin, err := st.AuthClient.BeginPasskeyRegistration(ctx, &ssov1.BeginPasskeyRegistrationRequest{})
+	require.NoError(t, err)
+
+	authenticator := newSoftAuthenticator(t)
+
+	_, err = st.AuthClient.FinishPasskeyRegistration(ctx, &ssov1.FinishPasskeyRegistrationRequest{
+		CredentialJson: authenticator.create(t, respBegin.GetOptionsJson()),
+		Name:           "test",
+	})
+	require.NoError(t, err)
+
+	return authenticator
+}
+
+// softAuthenticator is a platform authenticator holding a single P-256
+// passkey, which always verifies the user.
+type softAuthenticator struct {
+	origin     string
+	key        *ecdsa.PrivateKey
+	id         []byte
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+
+	return &softAuthenticator{origin: webAuthnOrigin, key: key, id: id}
+}
+
+// create answers navigator.credentials.create() with "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, optionsJSON string) string {
+	t.Helper()
+
+	var options struct {
+		RP struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Challenge string `json:"challenge"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(optionsJSON), &options))
+
+	a.rpID = options.RP.ID
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.User.ID)
+	require.NoError(t, err)
+	a.userHandle = userHandle
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.PublicKey.X.FillBytes(x)
+	a.key.PublicKey.Y.FillBytes(y)
+	coseKey := cborMap(
+		cborInt(1), cborInt(2), // kty: EC2
+		cborInt(3), cborInt(-7), // alg: ES256
+		cborInt(-1), cborInt(1), // crv: P-256
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)
+
+	// Attested credential data: AAGUID, credential ID length, credential ID
+	// and public key.
+	attested := make([]byte, 16, 18)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, coseKey...)
+
+	authData := append(a.authenticatorData(0x45), attested...)
+
+	attestationObject := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    a.clientData(t, "webauthn.create", options.Challenge),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+	})
+}
+
+// get answers navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, optionsJSON string) string {
+	t.Helper()
+
+	var options struct {
+		Challenge string `json:"challenge"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(optionsJSON), &options))
+
+	a.signCount++
+	authData := a.authenticatorData(0x05)
+	clientData := a.clientData(t, "webauthn.get", options.Challenge)
+
+	rawClientData, err := base64.RawURLEncoding.DecodeString(clientData)
+	require.NoError(t, err)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    clientData,
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(sig),
+		"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	data := append(rpIDHash[:], flags)
+
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, challenge string) string {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) string {
+	t.Helper()
+
+	id := base64.RawURLEncoding.EncodeToString(a.id)
+	data, err := json.Marshal(map[string]any{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": response,
+	})
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+// Just enough CBOR (RFC 8949) to encode attestation objects and COSE keys.
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func cborInt(n int64) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes alternating keys and values.
+func cborMap(items ...[]byte) []byte {
+	data := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		data = append(data, item...)
+	}
+
+	return data
+}